@@ -0,0 +1,314 @@
+// Package config maps command line flags and environment variables onto
+// the kubeflake GeneratorOptions, for the binaries under cmd.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FlorinBalint/kubeflake/pkg/cloud"
+	kubeflake "github.com/FlorinBalint/kubeflake/v1"
+)
+
+// EnvPrefix prefixes the environment variable of every flag, e.g.
+// KUBEFLAKE_SEQUENCE_BITS for -sequence-bits.
+const EnvPrefix = "KUBEFLAKE_"
+
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Config holds the generator settings given on the command line. Settings
+// that are neither given as flags nor in the environment keep the defaults
+// of kubeflake.New.
+type Config struct {
+	fs  *flag.FlagSet
+	set map[string]bool
+
+	layout         string
+	sequenceBits   int
+	clusterBits    int
+	machineBits    int
+	timeUnit       time.Duration
+	epoch          string
+	base           string
+	alphabet       string
+	checksum       string
+	obfuscation    string
+	clockRollback  string
+	maxRollback    time.Duration
+	clusterId      int
+	clusterIdFile  string
+	machineId      int
+	machineLease   bool
+	provider       string
+	lockFree       bool
+	resolveTimeout time.Duration
+	resolveRetries int
+	resolveBackoff time.Duration
+}
+
+// Register defines the generator flags on fs.
+func Register(fs *flag.FlagSet) *Config {
+	c := &Config{fs: fs}
+	fs.StringVar(&c.layout, "layout", "default", "ID layout: default, snowflake or sonyflake")
+	fs.IntVar(&c.sequenceBits, "sequence-bits", 0, "bit length of the sequence number")
+	fs.IntVar(&c.clusterBits, "cluster-bits", 0, "bit length of the cluster ID")
+	fs.IntVar(&c.machineBits, "machine-bits", 0, "bit length of the machine ID")
+	fs.DurationVar(&c.timeUnit, "time-unit", 0, "time unit of the timestamp")
+	fs.StringVar(&c.epoch, "epoch", "", "epoch of the timestamp, in RFC 3339 format")
+	fs.StringVar(&c.base, "base", "base62", "key encoding: base62, base64, base64url, raw-base64, raw-base64url, sortable, base32 or hex")
+	fs.StringVar(&c.alphabet, "alphabet", "", "custom key alphabet, overrides -base")
+	fs.StringVar(&c.checksum, "checksum", "none", "key check character: none, luhn or damm")
+	fs.StringVar(&c.obfuscation, "obfuscation-keys", "", "comma separated version:secret obfuscation keys, the current one first, e.g. 2:new,1:old")
+	fs.StringVar(&c.clockRollback, "clock-rollback", "borrow", "what to do when the clock moves backwards: borrow, wait or error")
+	fs.DurationVar(&c.maxRollback, "max-clock-rollback", time.Second, "how far the clock may move backwards before IDs fail")
+	fs.IntVar(&c.clusterId, "cluster-id", -1, "cluster ID, detected from the cloud availability zone if negative")
+	fs.StringVar(&c.clusterIdFile, "cluster-id-file", "", "JSON or YAML file mapping cluster names or zones to cluster IDs, e.g. a mounted ConfigMap")
+	fs.IntVar(&c.machineId, "machine-id", -1, "machine ID, the StatefulSet ordinal of the pod if negative")
+	fs.BoolVar(&c.machineLease, "machine-lease", false, "claim the machine ID through a Kubernetes Lease")
+	fs.StringVar(&c.provider, "cloud-provider", "detect", "cloud of the cluster ID: detect, gcp, aws, aws-zone or azure")
+	fs.BoolVar(&c.lockFree, "lock-free", false, "generate IDs with compare-and-swap instead of a mutex")
+	fs.DurationVar(&c.resolveTimeout, "resolve-timeout", 0, "timeout of every cluster and machine ID lookup")
+	fs.IntVar(&c.resolveRetries, "resolve-retries", 0, "retries of a failed cluster or machine ID lookup")
+	fs.DurationVar(&c.resolveBackoff, "resolve-backoff", 100*time.Millisecond, "wait before the first lookup retry")
+	return c
+}
+
+// LoadEnv sets every flag that was not given on the command line from its
+// environment variable, if that is set. Call it after parsing the flags.
+func (c *Config) LoadEnv() error {
+	c.set = map[string]bool{}
+	c.fs.Visit(func(f *flag.Flag) {
+		c.set[f.Name] = true
+	})
+	var errs []error
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if c.set[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(EnvName(f.Name))
+		if !ok {
+			return
+		}
+		if err := c.fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, EnvName(f.Name), err))
+			return
+		}
+		c.set[f.Name] = true
+	})
+	return errors.Join(errs...)
+}
+
+// EnvName returns the environment variable of the flag name.
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Options returns the GeneratorOptions for the flags that were set.
+func (c *Config) Options() ([]kubeflake.GeneratorOptions, error) {
+	var opts []kubeflake.GeneratorOptions
+	if c.layout != "default" {
+		layout, err := c.namedLayout()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kubeflake.WithLayout(layout))
+	}
+	if c.set["sequence-bits"] {
+		opts = append(opts, kubeflake.WithSequenceBits(c.sequenceBits))
+	}
+	if c.set["cluster-bits"] {
+		opts = append(opts, kubeflake.WithClusterBits(c.clusterBits))
+	}
+	if c.set["machine-bits"] {
+		opts = append(opts, kubeflake.WithMachineBits(c.machineBits))
+	}
+	if c.set["time-unit"] {
+		opts = append(opts, kubeflake.WithTimeUnit(c.timeUnit))
+	}
+	if c.epoch != "" {
+		epoch, err := time.Parse(time.RFC3339, c.epoch)
+		if err != nil {
+			return nil, fmt.Errorf("%w: epoch: %v", ErrInvalidConfig, err)
+		}
+		opts = append(opts, kubeflake.WithEpoch(epoch))
+	}
+
+	base, err := c.keyBase()
+	if err != nil {
+		return nil, err
+	}
+	if base != nil {
+		opts = append(opts, base)
+	}
+	switch c.checksum {
+	case "none":
+	case "luhn":
+		opts = append(opts, kubeflake.WithChecksum(kubeflake.ChecksumLuhn))
+	case "damm":
+		opts = append(opts, kubeflake.WithChecksum(kubeflake.ChecksumDamm))
+	default:
+		return nil, fmt.Errorf("%w: unknown checksum %q", ErrInvalidConfig, c.checksum)
+	}
+	if c.obfuscation != "" {
+		keys, err := c.obfuscationKeys()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kubeflake.WithObfuscation(keys...))
+	}
+	if c.set["clock-rollback"] || c.set["max-clock-rollback"] {
+		policy, err := c.rollbackPolicy()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kubeflake.WithClockRollbackPolicy(policy, c.maxRollback))
+	}
+
+	if c.set["cloud-provider"] {
+		provider, err := c.CloudProvider()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kubeflake.WithCloudProvider(provider))
+	}
+	if c.clusterIdFile != "" && c.clusterId >= 0 {
+		return nil, fmt.Errorf("%w: -cluster-id and -cluster-id-file are mutually exclusive", ErrInvalidConfig)
+	}
+	if c.clusterIdFile != "" {
+		opts = append(opts, kubeflake.WithClusterIdFile(c.clusterIdFile))
+	}
+	if c.clusterId >= 0 {
+		id := c.clusterId
+		opts = append(opts, kubeflake.WithClusterIdFn(func() (int, error) { return id, nil }))
+	}
+	if c.machineId >= 0 {
+		id := c.machineId
+		opts = append(opts, kubeflake.WithMachineIdFn(func() (int, error) { return id, nil }))
+	}
+
+	if c.lockFree {
+		opts = append(opts, kubeflake.WithLockFree())
+	}
+	if c.set["resolve-timeout"] {
+		opts = append(opts, kubeflake.WithResolveTimeout(c.resolveTimeout))
+	}
+	if c.set["resolve-retries"] || c.set["resolve-backoff"] {
+		opts = append(opts, kubeflake.WithResolveRetries(c.resolveRetries, c.resolveBackoff))
+	}
+	return opts, nil
+}
+
+func (c *Config) namedLayout() (*kubeflake.Layout, error) {
+	switch c.layout {
+	case "snowflake":
+		return kubeflake.SnowflakeLayout(), nil
+	case "sonyflake":
+		return kubeflake.SonyflakeLayout(), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown layout %q", ErrInvalidConfig, c.layout)
+	}
+}
+
+// obfuscationKeys parses the -obfuscation-keys flag, a comma separated
+// list of version:secret pairs.
+func (c *Config) obfuscationKeys() ([]kubeflake.ObfuscationKey, error) {
+	var keys []kubeflake.ObfuscationKey
+	for _, pair := range strings.Split(c.obfuscation, ",") {
+		version, secret, found := strings.Cut(pair, ":")
+		v, err := strconv.Atoi(version)
+		if !found || err != nil || secret == "" {
+			return nil, fmt.Errorf("%w: obfuscation key %q is not version:secret", ErrInvalidConfig, pair)
+		}
+		keys = append(keys, kubeflake.ObfuscationKey{Version: v, Secret: []byte(secret)})
+	}
+	return keys, nil
+}
+
+func (c *Config) rollbackPolicy() (kubeflake.ClockRollbackPolicy, error) {
+	switch c.clockRollback {
+	case "borrow":
+		return kubeflake.RollbackBorrow, nil
+	case "wait":
+		return kubeflake.RollbackWait, nil
+	case "error":
+		return kubeflake.RollbackError, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown clock rollback policy %q", ErrInvalidConfig, c.clockRollback)
+	}
+}
+
+func (c *Config) keyBase() (kubeflake.GeneratorOptions, error) {
+	if c.alphabet != "" {
+		base, err := kubeflake.NewAlphabetConverter(c.alphabet)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+		return kubeflake.WithBaseConverter(base), nil
+	}
+	if !c.set["base"] {
+		return nil, nil
+	}
+	switch c.base {
+	case "base62":
+		return kubeflake.WithBase62Keys(), nil
+	case "base64":
+		return kubeflake.WithBase64Keys(), nil
+	case "base64url":
+		return kubeflake.WithBase64URLKeys(), nil
+	case "raw-base64":
+		return kubeflake.WithRawStdBase64Keys(), nil
+	case "raw-base64url":
+		return kubeflake.WithRawURLBase64Keys(), nil
+	case "sortable":
+		return kubeflake.WithSortableKeys(), nil
+	case "base32":
+		return kubeflake.WithBase32Keys(), nil
+	case "hex":
+		return kubeflake.WithHexKeys(), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown base %q", ErrInvalidConfig, c.base)
+	}
+}
+
+// ClusterID returns the -cluster-id flag, negative if the cluster ID is
+// to be detected.
+func (c *Config) ClusterID() int {
+	return c.clusterId
+}
+
+// MachineID returns the -machine-id flag, negative if the machine ID is
+// to be resolved.
+func (c *Config) MachineID() int {
+	return c.machineId
+}
+
+// MachineLease reports whether the machine ID is to be claimed through a
+// Kubernetes Lease: -machine-lease without -machine-id. Options leaves the
+// Lease to the caller, which creates the allocator once, passes it to
+// kubeflake.WithMachineLease and releases it on shutdown.
+func (c *Config) MachineLease() bool {
+	return c.machineLease && c.machineId < 0
+}
+
+// CloudProvider returns the provider named by the -cloud-provider flag.
+func (c *Config) CloudProvider() (cloud.Provider, error) {
+	switch c.provider {
+	case "detect":
+		return cloud.DetectProvider, nil
+	case "gcp":
+		return cloud.GCPProvider, nil
+	case "aws":
+		return cloud.AWSProvider, nil
+	case "aws-zone":
+		return cloud.AWSZoneProvider, nil
+	case "azure":
+		return cloud.AzureProvider, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown cloud provider %q", ErrInvalidConfig, c.provider)
+	}
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kubeflake "github.com/FlorinBalint/kubeflake/v1"
+)
+
+func parse(t *testing.T, args ...string) *Config {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c := Register(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if err := c.LoadEnv(); err != nil {
+		t.Fatalf("LoadEnv error: %v", err)
+	}
+	return c
+}
+
+func TestOptions_FlagsAndEnv(t *testing.T) {
+	t.Setenv(EnvName("machine-bits"), "10")
+	t.Setenv(EnvName("sequence-bits"), "12")
+	t.Setenv(EnvName("base"), "hex")
+	c := parse(t, "-sequence-bits", "11", "-cluster-id", "2", "-machine-id", "7", "-checksum", "damm")
+
+	opts, err := c.Options()
+	if err != nil {
+		t.Fatalf("Options error: %v", err)
+	}
+	kf, err := kubeflake.New(opts...)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	layout := kf.Layout()
+	// Flags take precedence over the environment.
+	if layout.BitsSequence != 11 || layout.BitsMachine != 10 {
+		t.Fatalf("unexpected layout %+v", layout)
+	}
+	id, err := kf.Next()
+	if err != nil {
+		t.Fatalf("Next error: %v", err)
+	}
+	if id.MachineID() != 7 || id.ClusterID() != 2 {
+		t.Fatalf("unexpected id %v", id)
+	}
+	if key := id.String(); len(key) != 17 {
+		t.Fatalf("want a hex key with a check character, got %q", key)
+	}
+}
+
+func TestOptions_ObfuscationAndClockRollback(t *testing.T) {
+	t.Setenv(EnvName("obfuscation-keys"), "2:new-secret,1:old-secret")
+	c := parse(t, "-cluster-id", "1", "-machine-id", "3", "-clock-rollback", "wait", "-max-clock-rollback", "50ms")
+
+	opts, err := c.Options()
+	if err != nil {
+		t.Fatalf("Options error: %v", err)
+	}
+	kf, err := kubeflake.New(opts...)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	key, err := kf.ComposeKey(time.Now(), 0, 3, 1)
+	if err != nil {
+		t.Fatalf("ComposeKey error: %v", err)
+	}
+	// Keys start with the version of the current obfuscation key.
+	if key[0] != '2' {
+		t.Fatalf("want a key of version 2, got %q", key)
+	}
+	if parts, err := kf.DecomposeKey(key); err != nil || parts[kubeflake.MachineID] != 3 {
+		t.Fatalf("DecomposeKey(%q) = %v, %v", key, parts, err)
+	}
+}
+
+func TestMachineLease(t *testing.T) {
+	if !parse(t, "-machine-lease").MachineLease() {
+		t.Fatal("want a lease for -machine-lease")
+	}
+	// An explicit machine ID takes precedence over the lease.
+	c := parse(t, "-machine-lease", "-machine-id", "2")
+	if c.MachineLease() {
+		t.Fatal("want no lease with -machine-id")
+	}
+	if _, err := c.Options(); err != nil {
+		t.Fatalf("Options error: %v", err)
+	}
+}
+
+func TestOptions_ClusterIdFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clusters.yaml")
+	if err := os.WriteFile(path, []byte("prod-eu: 0\nprod-us: 12\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLUSTER_NAME", "prod-us")
+	t.Setenv(EnvName("cluster-id-file"), path)
+
+	opts, err := parse(t, "-machine-id", "1").Options()
+	if err != nil {
+		t.Fatalf("Options error: %v", err)
+	}
+	// The mapping is checked against the cluster bits of the layout.
+	if _, err := kubeflake.New(opts...); err == nil {
+		t.Fatal("expected an error for cluster id 12 with 3 cluster bits")
+	}
+
+	opts, err = parse(t, "-machine-id", "1", "-layout", "snowflake").Options()
+	if err != nil {
+		t.Fatalf("Options error: %v", err)
+	}
+	kf, err := kubeflake.New(opts...)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	if id, _ := kf.Next(); id.ClusterID() != 12 {
+		t.Fatalf("want cluster 12, got %v", id)
+	}
+
+	if _, err := parse(t, "-machine-id", "1", "-cluster-id", "3").Options(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("want ErrInvalidConfig for -cluster-id with a cluster id file, got %v", err)
+	}
+}
+
+func TestOptions_Errors(t *testing.T) {
+	for _, args := range [][]string{
+		{"-layout", "twitter"},
+		{"-base", "base7"},
+		{"-alphabet", "aa"},
+		{"-checksum", "crc"},
+		{"-cloud-provider", "oracle"},
+		{"-epoch", "yesterday"},
+		{"-obfuscation-keys", "secret"},
+		{"-obfuscation-keys", "x:secret"},
+		{"-obfuscation-keys", "1:"},
+		{"-clock-rollback", "rewind"},
+		{"-cluster-id", "2", "-cluster-id-file", "clusters.yaml"},
+	} {
+		if _, err := parse(t, args...).Options(); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("%v: expected ErrInvalidConfig, got %v", args, err)
+		}
+	}
+
+	t.Setenv(EnvName("machine-id"), "seven")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c := Register(fs)
+	if err := c.LoadEnv(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig for an invalid environment variable, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+// Command kubeflake-server serves Kubeflake IDs over HTTP/JSON, for
+// services that cannot use the Go library, and, with -grpc-addr, over
+// the gRPC service of pkg/rpc.
+//
+// Endpoints:
+//
+//	GET  /id               a new ID
+//	GET  /ids?n=N          N new IDs
+//	GET  /decompose/{key}  the time, sequence, machine ID and cluster ID of a key
+//	POST /compose          the ID of {"time", "sequence", "machine_id", "cluster_id"}
+//	GET  /healthz          fails if the cluster or machine ID lookup failed
+//	GET  /readyz           fails until the cluster and machine IDs are resolved
+//
+// IDs are returned as {"id": "<uint64 as a string>", "key": "<key>"}.
+// Every flag can be set through the environment as well, e.g.
+// KUBEFLAKE_MACHINE_BITS for -machine-bits.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/FlorinBalint/kubeflake/cmd/internal/config"
+	"github.com/FlorinBalint/kubeflake/pkg/kubernetes"
+	"github.com/FlorinBalint/kubeflake/pkg/rpc"
+	kubeflake "github.com/FlorinBalint/kubeflake/v1"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves until ctx is done, then shuts the server down gracefully and
+// releases the machine ID lease, if it holds one.
+func run(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("kubeflake-server", flag.ContinueOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	grpcAddr := fs.String("grpc-addr", "", "address to serve gRPC on, over unencrypted HTTP/2; disabled if empty")
+	maxIDs := fs.Int("max-ids", 10000, "most IDs returned by one /ids request")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 10*time.Second, "time to finish requests in flight on shutdown")
+	cfg := config.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := cfg.LoadEnv(); err != nil {
+		return err
+	}
+	opts, err := cfg.Options()
+	if err != nil {
+		return err
+	}
+	var lease *kubernetes.LeaseAllocator
+	if cfg.MachineLease() {
+		if lease, err = kubernetes.NewInClusterLeaseAllocator(); err != nil {
+			return err
+		}
+		opts = append(opts, kubeflake.WithMachineLease(lease))
+	}
+
+	s := newServer(*maxIDs)
+	go func() {
+		if err := s.resolve(ctx, opts...); err != nil {
+			log.Printf("resolving the generator failed: %v", err)
+		}
+	}()
+
+	servers := []*http.Server{{
+		Addr:              *addr,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}}
+	if *grpcAddr != "" {
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		servers = append(servers, &http.Server{
+			Addr:              *grpcAddr,
+			Handler:           &rpc.Server{Generator: s.generator, MaxIDs: *maxIDs},
+			Protocols:         protocols,
+			ReadHeaderTimeout: 10 * time.Second,
+		})
+	}
+	serveErr := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			serveErr <- srv.ListenAndServe()
+		}()
+		log.Printf("listening on %s", srv.Addr)
+	}
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	var errs []error
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errs = append(errs, err)
+		}
+	}
+	if lease != nil {
+		if err := lease.Release(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("releasing the machine id lease: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
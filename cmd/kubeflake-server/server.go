@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	kubeflake "github.com/FlorinBalint/kubeflake/v1"
+)
+
+var (
+	errPending  = errors.New("cluster and machine id resolution pending")
+	errBadCount = errors.New("n must be a positive integer")
+)
+
+// server serves the IDs of a Kubeflake over HTTP. Until the generator
+// is set by resolve, the ID endpoints and the readiness check fail.
+type server struct {
+	maxIDs int
+
+	mutex sync.RWMutex
+	kf    *kubeflake.Kubeflake
+	// err is the error that resolving the generator failed with
+	err error
+}
+
+func newServer(maxIDs int) *server {
+	return &server{maxIDs: maxIDs}
+}
+
+// resolve creates the generator, looking up its cluster and machine IDs.
+func (s *server) resolve(ctx context.Context, opts ...kubeflake.GeneratorOptions) error {
+	kf, err := kubeflake.NewWithContext(ctx, opts...)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.kf, s.err = kf, err
+	return err
+}
+
+// generator returns the generator, or why it is not available.
+func (s *server) generator() (*kubeflake.Kubeflake, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.kf == nil {
+		return nil, errPending
+	}
+	return s.kf, nil
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	mux.HandleFunc("GET /id", s.nextID)
+	mux.HandleFunc("GET /ids", s.nextIDs)
+	mux.HandleFunc("GET /decompose/{key}", s.decompose)
+	mux.HandleFunc("POST /compose", s.compose)
+	return mux
+}
+
+// idResponse holds an ID both as a number, encoded as a JSON string so
+// that clients without 64 bit integers do not round it, and as a key.
+type idResponse struct {
+	ID  uint64 `json:"id,string"`
+	Key string `json:"key"`
+}
+
+type idsResponse struct {
+	IDs []idResponse `json:"ids"`
+}
+
+type decomposition struct {
+	ID        uint64    `json:"id,string"`
+	Key       string    `json:"key"`
+	Time      time.Time `json:"time"`
+	Timestamp uint64    `json:"timestamp"`
+	Sequence  int       `json:"sequence"`
+	MachineID int       `json:"machine_id"`
+	ClusterID int       `json:"cluster_id"`
+	// Location is the zone or region of the cluster ID, if the server
+	// looked its own cluster ID up in the cloud
+	Location string `json:"location,omitempty"`
+}
+
+type composeRequest struct {
+	Time      time.Time `json:"time"`
+	Sequence  int       `json:"sequence"`
+	MachineID int       `json:"machine_id"`
+	ClusterID int       `json:"cluster_id"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// healthz fails once resolving the generator failed, so that the pod is
+// restarted, but not while it is pending.
+func (s *server) healthz(w http.ResponseWriter, _ *http.Request) {
+	if _, err := s.generator(); err != nil && !errors.Is(err, errPending) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz fails until the generator is resolved.
+func (s *server) readyz(w http.ResponseWriter, _ *http.Request) {
+	if _, err := s.generator(); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func (s *server) nextID(w http.ResponseWriter, _ *http.Request) {
+	kf, err := s.generator()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	id, err := kf.Next()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, idResponse{ID: id.Uint64(), Key: id.String()})
+}
+
+func (s *server) nextIDs(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 {
+		writeError(w, http.StatusBadRequest, errBadCount)
+		return
+	}
+	if n > s.maxIDs {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("n must be at most %d", s.maxIDs))
+		return
+	}
+	kf, err := s.generator()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	ids, err := kf.NextIDs(n)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	resp := idsResponse{IDs: make([]idResponse, len(ids))}
+	for i, id := range ids {
+		resp.IDs[i] = idResponse{ID: id, Key: kf.ID(id).String()}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) decompose(w http.ResponseWriter, r *http.Request) {
+	kf, err := s.generator()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	key := r.PathValue("key")
+	id, err := kf.ParseID(key)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	location, _ := kf.Location(id.Uint64())
+	writeJSON(w, http.StatusOK, decomposition{
+		ID:        id.Uint64(),
+		Key:       key,
+		Time:      id.Time(),
+		Timestamp: kf.Decompose(id.Uint64())[kubeflake.Timestamp],
+		Sequence:  id.Sequence(),
+		MachineID: id.MachineID(),
+		ClusterID: id.ClusterID(),
+		Location:  location,
+	})
+}
+
+func (s *server) compose(w http.ResponseWriter, r *http.Request) {
+	kf, err := s.generator()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	var req composeRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	id, err := kf.Compose(req.Time, req.Sequence, req.MachineID, req.ClusterID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, idResponse{ID: id, Key: kf.ID(id).String()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	kubeflake "github.com/FlorinBalint/kubeflake/v1"
+)
+
+func testOptions() []kubeflake.GeneratorOptions {
+	return []kubeflake.GeneratorOptions{
+		kubeflake.WithEpoch(time.Now().Add(-time.Hour)),
+		kubeflake.WithClusterIdFn(func() (int, error) { return 3, nil }),
+		kubeflake.WithMachineIdFn(func() (int, error) { return 9, nil }),
+	}
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	s := newServer(100)
+	if err := s.resolve(context.Background(), testOptions()...); err != nil {
+		t.Fatalf("resolve error: %v", err)
+	}
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func getJSON(t *testing.T, url string, wantStatus int, v any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s error: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("GET %s: want status %d, got %d", url, wantStatus, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: decoding the response: %v", url, err)
+	}
+}
+
+func TestServer_IDsAndDecompose(t *testing.T) {
+	ts := newTestServer(t)
+
+	var single idResponse
+	getJSON(t, ts.URL+"/id", http.StatusOK, &single)
+	if single.ID == 0 || single.Key == "" {
+		t.Fatalf("unexpected id %+v", single)
+	}
+
+	var batch idsResponse
+	getJSON(t, ts.URL+"/ids?n=25", http.StatusOK, &batch)
+	if len(batch.IDs) != 25 {
+		t.Fatalf("want 25 ids, got %d", len(batch.IDs))
+	}
+	last := single.ID
+	for _, id := range batch.IDs {
+		if id.ID <= last {
+			t.Fatalf("ids must increase: %d after %d", id.ID, last)
+		}
+		last = id.ID
+	}
+
+	var parts decomposition
+	getJSON(t, ts.URL+"/decompose/"+single.Key, http.StatusOK, &parts)
+	if parts.ID != single.ID || parts.MachineID != 9 || parts.ClusterID != 3 {
+		t.Fatalf("unexpected decomposition %+v", parts)
+	}
+	if time.Since(parts.Time) > time.Minute {
+		t.Fatalf("want a recent time, got %v", parts.Time)
+	}
+
+	var failed errorResponse
+	getJSON(t, ts.URL+"/decompose/abc!def", http.StatusBadRequest, &failed)
+	for _, n := range []string{"0", "-1", "x", "101"} {
+		getJSON(t, ts.URL+"/ids?n="+n, http.StatusBadRequest, &failed)
+	}
+}
+
+func TestServer_Compose(t *testing.T) {
+	ts := newTestServer(t)
+	at := time.Now().Add(-time.Minute).Truncate(10 * time.Millisecond).UTC()
+
+	body := `{"time":"` + at.Format(time.RFC3339Nano) + `","sequence":4,"machine_id":9,"cluster_id":3}`
+	resp, err := http.Post(ts.URL+"/compose", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST /compose error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want status 200, got %d", resp.StatusCode)
+	}
+	var composed idResponse
+	if err := json.NewDecoder(resp.Body).Decode(&composed); err != nil {
+		t.Fatalf("decoding the response: %v", err)
+	}
+
+	var parts decomposition
+	getJSON(t, ts.URL+"/decompose/"+composed.Key, http.StatusOK, &parts)
+	if !parts.Time.Equal(at) || parts.Sequence != 4 || parts.ID != composed.ID {
+		t.Fatalf("compose round trip mismatch: %+v", parts)
+	}
+
+	for _, body := range []string{`{"sequence":`, `{"sequence":100000}`, `{"unknown":1}`} {
+		resp, err := http.Post(ts.URL+"/compose", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST /compose error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("body %s: want status 400, got %d", body, resp.StatusCode)
+		}
+	}
+}
+
+func TestServer_HealthWhileResolving(t *testing.T) {
+	s := newServer(100)
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	var status map[string]string
+	getJSON(t, ts.URL+"/healthz", http.StatusOK, &status)
+	var failed errorResponse
+	getJSON(t, ts.URL+"/readyz", http.StatusServiceUnavailable, &failed)
+	getJSON(t, ts.URL+"/id", http.StatusServiceUnavailable, &failed)
+
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- s.resolve(context.Background(), append(testOptions(),
+			kubeflake.WithMachineIdFn(func() (int, error) {
+				<-release
+				return 1, nil
+			}))...)
+	}()
+	getJSON(t, ts.URL+"/readyz", http.StatusServiceUnavailable, &failed)
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("resolve error: %v", err)
+	}
+	getJSON(t, ts.URL+"/readyz", http.StatusOK, &status)
+	getJSON(t, ts.URL+"/healthz", http.StatusOK, &status)
+}
+
+func TestServer_ResolveFailure(t *testing.T) {
+	s := newServer(100)
+	errNoOrdinal := errors.New("no ordinal")
+	err := s.resolve(context.Background(), append(testOptions(),
+		kubeflake.WithMachineIdFn(func() (int, error) { return 0, errNoOrdinal }))...)
+	if !errors.Is(err, errNoOrdinal) {
+		t.Fatalf("expected the machine id error, got %v", err)
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	var failed errorResponse
+	getJSON(t, ts.URL+"/healthz", http.StatusServiceUnavailable, &failed)
+	getJSON(t, ts.URL+"/readyz", http.StatusServiceUnavailable, &failed)
+	if !strings.Contains(failed.Error, "no ordinal") {
+		t.Fatalf("want the resolve error in the response, got %q", failed.Error)
+	}
+}
+
+func TestRun_ShutsDownGracefully(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- run(ctx, []string{"-addr", "127.0.0.1:0", "-grpc-addr", "127.0.0.1:0", "-cluster-id", "1", "-machine-id", "2"})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after the context was cancelled")
+	}
+
+	if err := run(context.Background(), []string{"-base", "base7"}); err == nil {
+		t.Fatal("expected an error for an unknown base")
+	}
+}
+
+func TestServer_DecomposeNamesTheZone(t *testing.T) {
+	t.Setenv("CLOUD_PROVIDER", "gcp")
+	t.Setenv("GCP_ZONE", "us-central1-a")
+	s := newServer(100)
+	err := s.resolve(context.Background(),
+		kubeflake.WithEpoch(time.Now().Add(-time.Hour)),
+		kubeflake.WithMachineIdFn(func() (int, error) { return 9, nil }))
+	if err != nil {
+		t.Fatalf("resolve error: %v", err)
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	var single idResponse
+	getJSON(t, ts.URL+"/id", http.StatusOK, &single)
+	var parts decomposition
+	getJSON(t, ts.URL+"/decompose/"+single.Key, http.StatusOK, &parts)
+	if parts.Location != "us-central1-a" {
+		t.Fatalf("want the location us-central1-a, got %+v", parts)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/FlorinBalint/kubeflake/cmd/internal/config"
+	"github.com/FlorinBalint/kubeflake/pkg/cloud"
+	kubeflake "github.com/FlorinBalint/kubeflake/v1"
+)
+
+var errInvalidOutput = errors.New("unknown output format, want table or json")
+
+// command holds the flags that every command shares.
+type command struct {
+	fs     *flag.FlagSet
+	cfg    *config.Config
+	output string
+}
+
+func newCommand(name string) *command {
+	c := &command{fs: flag.NewFlagSet(name, flag.ContinueOnError)}
+	c.cfg = config.Register(c.fs)
+	c.fs.StringVar(&c.output, "o", "table", "output format: table or json")
+	return c
+}
+
+// parse parses the flags, falling back to their environment variables.
+func (c *command) parse(args []string) error {
+	if err := c.fs.Parse(args); err != nil {
+		return err
+	}
+	if c.output != "table" && c.output != "json" {
+		return fmt.Errorf("%w: %q", errInvalidOutput, c.output)
+	}
+	return c.cfg.LoadEnv()
+}
+
+// generator returns a Kubeflake for the flags, with the cluster and
+// machine IDs 0 unless they are given.
+func (c *command) generator() (*kubeflake.Kubeflake, error) {
+	opts, err := c.cfg.Options()
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]kubeflake.GeneratorOptions{
+		kubeflake.WithMachineIdFn(func() (int, error) { return 0, nil }),
+	}, opts...)
+	if c.cfg.ClusterID() < 0 {
+		// -cloud-provider only names locations here, it must not look up the zone.
+		opts = append(opts, kubeflake.WithClusterIdFn(func() (int, error) { return 0, nil }))
+	}
+	return kubeflake.New(opts...)
+}
+
+// write prints v as a JSON line, or as a table through table.
+func (c *command) write(w io.Writer, v any, table func(tw *tabwriter.Writer)) error {
+	if c.output == "json" {
+		return json.NewEncoder(w).Encode(v)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+type decoded struct {
+	ID        uint64    `json:"id,string"`
+	Key       string    `json:"key"`
+	Time      time.Time `json:"time"`
+	Timestamp uint64    `json:"timestamp"`
+	Sequence  int       `json:"sequence"`
+	MachineID int       `json:"machine_id"`
+	ClusterID int       `json:"cluster_id"`
+	// Location is the zone or region of the cluster ID, if -cloud-provider names the cloud
+	Location string `json:"location,omitempty"`
+}
+
+func (c *command) decodeID(kf *kubeflake.Kubeflake, id kubeflake.ID) (*decoded, error) {
+	d := &decoded{
+		ID:        id.Uint64(),
+		Key:       id.String(),
+		Time:      id.Time().UTC(),
+		Timestamp: kf.Decompose(id.Uint64())[kubeflake.Timestamp],
+		Sequence:  id.Sequence(),
+		MachineID: id.MachineID(),
+		ClusterID: id.ClusterID(),
+	}
+	provider, err := c.cfg.CloudProvider()
+	if err != nil {
+		return nil, err
+	}
+	d.Location, _ = cloud.AvailabilityZoneName(provider, d.ClusterID)
+	return d, nil
+}
+
+func (c *command) writeDecoded(w io.Writer, d *decoded) error {
+	return c.write(w, d, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "id\t%d\n", d.ID)
+		fmt.Fprintf(tw, "key\t%s\n", d.Key)
+		fmt.Fprintf(tw, "time\t%s\n", d.Time.Format(time.RFC3339Nano))
+		fmt.Fprintf(tw, "timestamp\t%d\n", d.Timestamp)
+		fmt.Fprintf(tw, "sequence\t%d\n", d.Sequence)
+		fmt.Fprintf(tw, "machine\t%d\n", d.MachineID)
+		fmt.Fprintf(tw, "cluster\t%d\n", d.ClusterID)
+		if d.Location != "" {
+			fmt.Fprintf(tw, "location\t%s\n", d.Location)
+		}
+	})
+}
+
+// decode prints the parts of every key or ID. An argument is read as a
+// key, or as a decimal ID if it is not a valid key or -id is set.
+func decode(args []string, stdout io.Writer) error {
+	c := newCommand("decode")
+	asID := c.fs.Bool("id", false, "read the arguments as decimal IDs rather than keys")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	if c.fs.NArg() == 0 {
+		return errors.New("usage: kubeflake decode [flags] <key|id>...")
+	}
+	kf, err := c.generator()
+	if err != nil {
+		return err
+	}
+	for i, arg := range c.fs.Args() {
+		id, err := parseID(kf, arg, *asID)
+		if err != nil {
+			return err
+		}
+		d, err := c.decodeID(kf, id)
+		if err != nil {
+			return err
+		}
+		if i > 0 && c.output == "table" {
+			fmt.Fprintln(stdout)
+		}
+		if err := c.writeDecoded(stdout, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseID(kf *kubeflake.Kubeflake, arg string, asID bool) (kubeflake.ID, error) {
+	var keyErr error
+	if !asID {
+		id, err := kf.ParseID(arg)
+		if err == nil {
+			return id, nil
+		}
+		keyErr = err
+	}
+	n, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		if keyErr != nil {
+			return kubeflake.ID{}, fmt.Errorf("%q is neither a key nor an ID: %w", arg, keyErr)
+		}
+		return kubeflake.ID{}, fmt.Errorf("%q is not an ID: %w", arg, err)
+	}
+	// Parsing the key of n rejects IDs out of range for the layout.
+	return kf.ParseID(kf.ID(n).String())
+}
+
+// compose prints the ID of the given parts.
+func compose(args []string, stdout io.Writer) error {
+	c := newCommand("compose")
+	at := c.fs.String("time", "", "time of the ID, in RFC 3339 format (default now)")
+	sequence := c.fs.Int("sequence", 0, "sequence number of the ID")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	t := time.Now()
+	if *at != "" {
+		var err error
+		if t, err = time.Parse(time.RFC3339Nano, *at); err != nil {
+			return fmt.Errorf("-time: %w", err)
+		}
+	}
+	kf, err := c.generator()
+	if err != nil {
+		return err
+	}
+	id, err := kf.Compose(t, *sequence, max(c.cfg.MachineID(), 0), max(c.cfg.ClusterID(), 0))
+	if err != nil {
+		return err
+	}
+	d, err := c.decodeID(kf, kf.ID(id))
+	if err != nil {
+		return err
+	}
+	return c.writeDecoded(stdout, d)
+}
+
+type generated struct {
+	ID  uint64 `json:"id,string"`
+	Key string `json:"key"`
+}
+
+// gen prints new IDs, from a generator with the cluster and machine IDs
+// of the flags.
+func gen(args []string, stdout io.Writer) error {
+	c := newCommand("gen")
+	n := c.fs.Int("n", 1, "number of IDs")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	if *n <= 0 {
+		return fmt.Errorf("-n must be positive, got %d", *n)
+	}
+	kf, err := c.generator()
+	if err != nil {
+		return err
+	}
+	ids, err := kf.NextIDs(*n)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		enc := json.NewEncoder(stdout)
+		for _, id := range ids {
+			if err := enc.Encode(generated{ID: id, Key: kf.ID(id).String()}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return c.write(stdout, nil, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "ID\tKEY")
+		for _, id := range ids {
+			fmt.Fprintf(tw, "%d\t%s\n", id, kf.ID(id).String())
+		}
+	})
+}
+
+type layoutField struct {
+	Name  kubeflake.IdParts `json:"name"`
+	Bits  int               `json:"bits"`
+	Shift int               `json:"shift"`
+}
+
+type layoutInfo struct {
+	// Fields lists the fields from the most to the least significant bits
+	Fields   []layoutField `json:"fields"`
+	TimeUnit string        `json:"time_unit"`
+	Epoch    time.Time     `json:"epoch"`
+	// End is the first time the timestamp field cannot hold
+	End time.Time `json:"end"`
+	// KeyLength is the length of the key of the largest ID
+	KeyLength int `json:"key_length"`
+}
+
+// layout prints the bit layout of the IDs.
+func layout(args []string, stdout io.Writer) error {
+	c := newCommand("layout")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	kf, err := c.generator()
+	if err != nil {
+		return err
+	}
+	l := kf.Layout()
+	info := layoutInfo{
+		TimeUnit: l.TimeUnit.String(),
+		Epoch:    l.Epoch.UTC(),
+		End:      timeLimit(l),
+	}
+	shift := 0
+	for _, part := range l.Order {
+		shift += partBits(l, part)
+	}
+	for _, part := range l.Order {
+		shift -= partBits(l, part)
+		info.Fields = append(info.Fields, layoutField{Name: part, Bits: partBits(l, part), Shift: shift})
+	}
+	used := info.Fields[0].Bits + info.Fields[0].Shift
+	info.KeyLength = len(l.ID(^uint64(0) >> (64 - used)).String())
+
+	return c.write(stdout, info, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "FIELD\tBITS\tSHIFT")
+		for _, f := range info.Fields {
+			fmt.Fprintf(tw, "%s\t%d\t%d\n", f.Name, f.Bits, f.Shift)
+		}
+		fmt.Fprintln(tw)
+		fmt.Fprintf(tw, "time unit\t%s\n", info.TimeUnit)
+		fmt.Fprintf(tw, "epoch\t%s\n", info.Epoch.Format(time.RFC3339Nano))
+		fmt.Fprintf(tw, "end\t%s\n", info.End.Format(time.RFC3339Nano))
+		fmt.Fprintf(tw, "key length\t%d\n", info.KeyLength)
+	})
+}
+
+func partBits(l *kubeflake.Layout, part kubeflake.IdParts) int {
+	switch part {
+	case kubeflake.Timestamp:
+		return l.BitsTime
+	case kubeflake.Sequence:
+		return l.BitsSequence
+	case kubeflake.ClusterID:
+		return l.BitsCluster
+	case kubeflake.MachineID:
+		return l.BitsMachine
+	}
+	return 0
+}
+
+// timeLimit returns the epoch plus 2^BitsTime time units, which may be
+// too long for a time.Duration.
+func timeLimit(l *kubeflake.Layout) time.Time {
+	hi, lo := bits.Mul64(1<<l.BitsTime, uint64(l.TimeUnit))
+	secs, nanos := bits.Div64(hi, lo, uint64(time.Second))
+	return time.Unix(l.Epoch.Unix()+int64(secs), int64(l.Epoch.Nanosecond())+int64(nanos)).UTC()
+}
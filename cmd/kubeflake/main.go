@@ -0,0 +1,49 @@
+// Command kubeflake mints and inspects Kubeflake IDs.
+//
+// Usage:
+//
+//	kubeflake decode [flags] <key|id>...  the time, sequence, machine, cluster and location of IDs
+//	kubeflake compose [flags]             the ID of -time, -sequence, -machine-id and -cluster-id
+//	kubeflake gen [flags] [-n N]          N new IDs
+//	kubeflake layout [flags]              the bit layout of the IDs
+//
+// Every command takes the generator flags of kubeflake-server, e.g.
+// -layout, -sequence-bits, -epoch, -time-unit or -base, which must match
+// those of the generator of the IDs, and -o table or -o json. JSON output
+// has one object per line. Unlike the server, the CLI never looks up its
+// cluster and machine IDs: they are 0 unless given with -cluster-id and
+// -machine-id, and -cloud-provider only names the location of cluster IDs.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var errUsage = errors.New("usage: kubeflake <decode|compose|gen|layout> [flags] [args]")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	commands := map[string]func([]string, io.Writer) error{
+		"decode":  decode,
+		"compose": compose,
+		"gen":     gen,
+		"layout":  layout,
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%w", args[0], errUsage)
+	}
+	return cmd(args[1:], stdout)
+}
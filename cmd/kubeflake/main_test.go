@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runJSON(t *testing.T, args ...string) []map[string]any {
+	t.Helper()
+	var out bytes.Buffer
+	// Flags must come before the arguments of decode.
+	if err := run(append([]string{args[0], "-o", "json"}, args[1:]...), &out); err != nil {
+		t.Fatalf("run(%q) error: %v", args, err)
+	}
+	var objects []map[string]any
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var v map[string]any
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("run(%q): decoding the output: %v", args, err)
+		}
+		objects = append(objects, v)
+	}
+	return objects
+}
+
+func TestComposeThenDecode(t *testing.T) {
+	at := time.Date(2025, 6, 1, 12, 30, 0, 0, time.UTC)
+	composed := runJSON(t, "compose", "-time", at.Format(time.RFC3339), "-sequence", "7",
+		"-machine-id", "42", "-cluster-id", "1", "-cloud-provider", "gcp")
+	if len(composed) != 1 {
+		t.Fatalf("want 1 object, got %d", len(composed))
+	}
+	key, id := composed[0]["key"].(string), composed[0]["id"].(string)
+
+	for _, arg := range []string{key, id} {
+		decoded := runJSON(t, "decode", "-cloud-provider", "gcp", arg)[0]
+		if decoded["time"] != at.Format(time.RFC3339) || decoded["sequence"] != 7.0 ||
+			decoded["machine_id"] != 42.0 || decoded["cluster_id"] != 1.0 || decoded["key"] != key {
+			t.Fatalf("decode %s: unexpected parts %v", arg, decoded)
+		}
+		if decoded["location"] == "" || decoded["location"] == nil {
+			t.Fatalf("decode %s: want the zone of cluster 1, got %v", arg, decoded)
+		}
+	}
+
+	var table bytes.Buffer
+	if err := run([]string{"decode", key}, &table); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if !strings.Contains(table.String(), "machine    42") || strings.Contains(table.String(), "location") {
+		t.Fatalf("unexpected table:\n%s", table.String())
+	}
+}
+
+func TestGen(t *testing.T) {
+	ids := runJSON(t, "gen", "-n", "5", "-machine-id", "3", "-base", "hex")
+	if len(ids) != 5 {
+		t.Fatalf("want 5 ids, got %d", len(ids))
+	}
+	for _, id := range ids {
+		if len(id["key"].(string)) != 16 {
+			t.Fatalf("want a hex key, got %v", id)
+		}
+		decoded := runJSON(t, "decode", "-base", "hex", id["key"].(string))[0]
+		if decoded["machine_id"] != 3.0 {
+			t.Fatalf("want machine 3, got %v", decoded)
+		}
+	}
+
+	var table bytes.Buffer
+	if err := run([]string{"gen", "-n", "3"}, &table); err != nil {
+		t.Fatalf("gen error: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(table.String()), "\n"); len(lines) != 4 {
+		t.Fatalf("want a header and 3 rows, got:\n%s", table.String())
+	}
+}
+
+func TestLayout(t *testing.T) {
+	info := runJSON(t, "layout", "-layout", "snowflake")[0]
+	fields := info["fields"].([]any)
+	want := []string{"timestamp", "cluster", "machine", "sequence"}
+	if len(fields) != len(want) {
+		t.Fatalf("want %d fields, got %v", len(want), fields)
+	}
+	shifts := []float64{22, 17, 12, 0}
+	for i, f := range fields {
+		field := f.(map[string]any)
+		if !strings.HasPrefix(field["name"].(string), want[i]) || field["shift"] != shifts[i] {
+			t.Fatalf("field %d: want %s at shift %v, got %v", i, want[i], shifts[i], field)
+		}
+	}
+	if info["end"] != "2080-07-10T17:30:30.209Z" {
+		t.Fatalf("unexpected end of the snowflake epoch: %v", info["end"])
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"encode"},
+		{"decode"},
+		{"decode", "not-a-key!"},
+		{"decode", "-id", "abc"},
+		{"gen", "-n", "0"},
+		{"gen", "-o", "yaml"},
+		{"compose", "-time", "yesterday"},
+		{"compose", "-sequence", "100000"},
+		{"layout", "-base", "base7"},
+	} {
+		if err := run(args, new(bytes.Buffer)); err == nil {
+			t.Fatalf("run(%q): expected an error", args)
+		}
+	}
+}
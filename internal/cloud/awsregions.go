@@ -1,29 +1,53 @@
 package cloud
 
-import (
-	"sort"
-)
+import "slices"
 
-// AWSRegions maps AWS region name -> increasing integer (stable order).
-// Indices are assigned deterministically. Regions listed in topAWSRegions
-// are guaranteed to take the first indices, in sorted(topAWSRegions) order.
-var AWSRegions = map[string]int{}
+// AWSTableVersion is the version of awsRegionTable and awsZoneTable.
+// awsgen.go bumps it whenever it appends to them.
+const AWSTableVersion = 1
 
-// topAWSRegions lists the top regions for global coverage.
-// They will take the first IDs to ensure a global presence
-// even when only 3 bits are used to encode the cluster IDs.
-var topAWSRegions = []string{
-	"ap-southeast-2",
-	"eu-west-2",
-	"us-west-1",
-	"ap-east-1",
-	"af-south-1",
-	"sa-east-1",
-	"me-central-1",
-	"ca-central-1",
+// awsRegionTable assigns the AWS region indices, which are the cluster IDs
+// of AWSProvider. It is append-only. The first regions give a global
+// presence even when only 3 bits are used to encode the cluster IDs.
+var awsRegionTable = []string{
+	0:  "af-south-1",
+	1:  "ap-east-1",
+	2:  "ap-southeast-2",
+	3:  "ca-central-1",
+	4:  "eu-west-2",
+	5:  "me-central-1",
+	6:  "sa-east-1",
+	7:  "us-west-1",
+	8:  "ap-east-2",
+	9:  "ap-northeast-1",
+	10: "ap-northeast-2",
+	11: "ap-northeast-3",
+	12: "ap-south-1",
+	13: "ap-south-2",
+	14: "ap-southeast-1",
+	15: "ap-southeast-3",
+	16: "ap-southeast-4",
+	17: "ap-southeast-5",
+	18: "ap-southeast-6",
+	19: "ap-southeast-7",
+	20: "ca-west-1",
+	21: "eu-central-1",
+	22: "eu-central-2",
+	23: "eu-north-1",
+	24: "eu-south-1",
+	25: "eu-south-2",
+	26: "eu-west-1",
+	27: "eu-west-3",
+	28: "il-central-1",
+	29: "me-south-1",
+	30: "mx-central-1",
+	31: "us-east-1",
+	32: "us-east-2",
+	33: "us-west-2",
 }
 
-// allAWSRegions contains all available AWS regions.
+// allAWSRegions contains the AWS regions that are currently available.
+// Every region in it has an index in awsRegionTable.
 var allAWSRegions = []string{
 	"af-south-1",
 	"ap-east-1",
@@ -61,10 +85,8 @@ var allAWSRegions = []string{
 	"us-west-2",
 }
 
-// init builds the index maps using the current data.
-func init() {
-	rebuildAWSIndices()
-}
+// AWSRegions maps the AWS region names to their indices.
+var AWSRegions = indexTable(awsRegionTable)
 
 // AWSRegionIndex returns the index for a region and whether it exists.
 func AWSRegionIndex(region string) (int, bool) {
@@ -72,52 +94,12 @@ func AWSRegionIndex(region string) (int, bool) {
 	return i, ok
 }
 
-// rebuildAWSIndices rebuilds AWSRegions ensuring topAWSRegions come first.
-func rebuildAWSIndices() {
-	AWSRegions = map[string]int{}
-
-	// Collect regions
-	allRegions := make([]string, len(allAWSRegions))
-	copy(allRegions, allAWSRegions)
-	sort.Strings(allRegions)
-
-	// Top regions (that exist in the dataset), sorted
-	topRegions := make([]string, 0, len(topAWSRegions))
-	for _, r := range topAWSRegions {
-		if hasRegion(allAWSRegions, r) {
-			topRegions = append(topRegions, r)
-		}
-	}
-	sort.Strings(topRegions)
-
-	// Regions: top first, then the rest
-	topSet := make(map[string]struct{}, len(topRegions))
-	for _, r := range topRegions {
-		topSet[r] = struct{}{}
-	}
-	restRegions := make([]string, 0, len(allRegions))
-	for _, r := range allRegions {
-		if _, ok := topSet[r]; !ok {
-			restRegions = append(restRegions, r)
-		}
-	}
-
-	rIdx := 0
-	for _, r := range topRegions {
-		AWSRegions[r] = rIdx
-		rIdx++
-	}
-	for _, r := range restRegions {
-		AWSRegions[r] = rIdx
-		rIdx++
-	}
+// AWSRegionForIndex returns the region with the given index and whether it exists.
+func AWSRegionForIndex(i int) (string, bool) {
+	return forIndex(awsRegionTable, i)
 }
 
-func hasRegion(regions []string, want string) bool {
-	for _, r := range regions {
-		if r == want {
-			return true
-		}
-	}
-	return false
+// AWSRegionTable returns the region names by index, for awsgen.go.
+func AWSRegionTable() []string {
+	return slices.Clone(awsRegionTable)
 }
@@ -0,0 +1,191 @@
+package cloud
+
+import "slices"
+
+// awsZoneTable assigns the AWS availability zone indices, which are the
+// cluster IDs of AWSZoneProvider. Zone IDs (e.g. "use1-az1") are used instead
+// of zone names (e.g. "us-east-1a") because names are shuffled per account
+// while IDs denote the same location everywhere. It is append-only, and
+// versioned by AWSTableVersion. The first zones give a global presence even
+// when only 3 bits are used to encode the cluster IDs.
+var awsZoneTable = []string{
+	0:   "afs1-az1",
+	1:   "ape1-az1",
+	2:   "apse2-az1",
+	3:   "cac1-az1",
+	4:   "euw2-az1",
+	5:   "mec1-az1",
+	6:   "sae1-az1",
+	7:   "usw1-az1",
+	8:   "afs1-az2",
+	9:   "afs1-az3",
+	10:  "ape1-az2",
+	11:  "ape1-az3",
+	12:  "ape2-az1",
+	13:  "ape2-az2",
+	14:  "ape2-az3",
+	15:  "apne1-az1",
+	16:  "apne1-az2",
+	17:  "apne1-az4",
+	18:  "apne2-az1",
+	19:  "apne2-az2",
+	20:  "apne2-az3",
+	21:  "apne2-az4",
+	22:  "apne3-az1",
+	23:  "apne3-az2",
+	24:  "apne3-az3",
+	25:  "aps1-az1",
+	26:  "aps1-az2",
+	27:  "aps1-az3",
+	28:  "aps2-az1",
+	29:  "aps2-az2",
+	30:  "aps2-az3",
+	31:  "apse1-az1",
+	32:  "apse1-az2",
+	33:  "apse1-az3",
+	34:  "apse2-az2",
+	35:  "apse2-az3",
+	36:  "apse3-az1",
+	37:  "apse3-az2",
+	38:  "apse3-az3",
+	39:  "apse4-az1",
+	40:  "apse4-az2",
+	41:  "apse4-az3",
+	42:  "apse5-az1",
+	43:  "apse5-az2",
+	44:  "apse5-az3",
+	45:  "apse6-az1",
+	46:  "apse6-az2",
+	47:  "apse6-az3",
+	48:  "apse7-az1",
+	49:  "apse7-az2",
+	50:  "apse7-az3",
+	51:  "cac1-az2",
+	52:  "cac1-az4",
+	53:  "caw1-az1",
+	54:  "caw1-az2",
+	55:  "caw1-az3",
+	56:  "euc1-az1",
+	57:  "euc1-az2",
+	58:  "euc1-az3",
+	59:  "euc2-az1",
+	60:  "euc2-az2",
+	61:  "euc2-az3",
+	62:  "eun1-az1",
+	63:  "eun1-az2",
+	64:  "eun1-az3",
+	65:  "eus1-az1",
+	66:  "eus1-az2",
+	67:  "eus1-az3",
+	68:  "eus2-az1",
+	69:  "eus2-az2",
+	70:  "eus2-az3",
+	71:  "euw1-az1",
+	72:  "euw1-az2",
+	73:  "euw1-az3",
+	74:  "euw2-az2",
+	75:  "euw2-az3",
+	76:  "euw3-az1",
+	77:  "euw3-az2",
+	78:  "euw3-az3",
+	79:  "ilc1-az1",
+	80:  "ilc1-az2",
+	81:  "ilc1-az3",
+	82:  "mec1-az2",
+	83:  "mec1-az3",
+	84:  "mes1-az1",
+	85:  "mes1-az2",
+	86:  "mes1-az3",
+	87:  "mxc1-az1",
+	88:  "mxc1-az2",
+	89:  "mxc1-az3",
+	90:  "sae1-az2",
+	91:  "sae1-az3",
+	92:  "use1-az1",
+	93:  "use1-az2",
+	94:  "use1-az3",
+	95:  "use1-az4",
+	96:  "use1-az5",
+	97:  "use1-az6",
+	98:  "use2-az1",
+	99:  "use2-az2",
+	100: "use2-az3",
+	101: "usw1-az3",
+	102: "usw2-az1",
+	103: "usw2-az2",
+	104: "usw2-az3",
+	105: "usw2-az4",
+}
+
+// allAWSRegionZones contains the availability zone IDs of every AWS region.
+// Every zone in it has an index in awsZoneTable.
+var allAWSRegionZones = map[string][]string{
+	"af-south-1":     {"afs1-az1", "afs1-az2", "afs1-az3"},
+	"ap-east-1":      {"ape1-az1", "ape1-az2", "ape1-az3"},
+	"ap-east-2":      {"ape2-az1", "ape2-az2", "ape2-az3"},
+	"ap-northeast-1": {"apne1-az1", "apne1-az2", "apne1-az4"},
+	"ap-northeast-2": {"apne2-az1", "apne2-az2", "apne2-az3", "apne2-az4"},
+	"ap-northeast-3": {"apne3-az1", "apne3-az2", "apne3-az3"},
+	"ap-south-1":     {"aps1-az1", "aps1-az2", "aps1-az3"},
+	"ap-south-2":     {"aps2-az1", "aps2-az2", "aps2-az3"},
+	"ap-southeast-1": {"apse1-az1", "apse1-az2", "apse1-az3"},
+	"ap-southeast-2": {"apse2-az1", "apse2-az2", "apse2-az3"},
+	"ap-southeast-3": {"apse3-az1", "apse3-az2", "apse3-az3"},
+	"ap-southeast-4": {"apse4-az1", "apse4-az2", "apse4-az3"},
+	"ap-southeast-5": {"apse5-az1", "apse5-az2", "apse5-az3"},
+	"ap-southeast-6": {"apse6-az1", "apse6-az2", "apse6-az3"},
+	"ap-southeast-7": {"apse7-az1", "apse7-az2", "apse7-az3"},
+	"ca-central-1":   {"cac1-az1", "cac1-az2", "cac1-az4"},
+	"ca-west-1":      {"caw1-az1", "caw1-az2", "caw1-az3"},
+	"eu-central-1":   {"euc1-az1", "euc1-az2", "euc1-az3"},
+	"eu-central-2":   {"euc2-az1", "euc2-az2", "euc2-az3"},
+	"eu-north-1":     {"eun1-az1", "eun1-az2", "eun1-az3"},
+	"eu-south-1":     {"eus1-az1", "eus1-az2", "eus1-az3"},
+	"eu-south-2":     {"eus2-az1", "eus2-az2", "eus2-az3"},
+	"eu-west-1":      {"euw1-az1", "euw1-az2", "euw1-az3"},
+	"eu-west-2":      {"euw2-az1", "euw2-az2", "euw2-az3"},
+	"eu-west-3":      {"euw3-az1", "euw3-az2", "euw3-az3"},
+	"il-central-1":   {"ilc1-az1", "ilc1-az2", "ilc1-az3"},
+	"me-central-1":   {"mec1-az1", "mec1-az2", "mec1-az3"},
+	"me-south-1":     {"mes1-az1", "mes1-az2", "mes1-az3"},
+	"mx-central-1":   {"mxc1-az1", "mxc1-az2", "mxc1-az3"},
+	"sa-east-1":      {"sae1-az1", "sae1-az2", "sae1-az3"},
+	"us-east-1":      {"use1-az1", "use1-az2", "use1-az3", "use1-az4", "use1-az5", "use1-az6"},
+	"us-east-2":      {"use2-az1", "use2-az2", "use2-az3"},
+	"us-west-1":      {"usw1-az1", "usw1-az3"},
+	"us-west-2":      {"usw2-az1", "usw2-az2", "usw2-az3", "usw2-az4"},
+}
+
+// awsZones maps the zone IDs to their indices, awsZoneRegion to their regions.
+var (
+	awsZones      = indexTable(awsZoneTable)
+	awsZoneRegion = zoneRegions(allAWSRegionZones)
+)
+
+// AWSZoneIndex returns the index for an availability zone ID and whether it exists.
+func AWSZoneIndex(zoneID string) (int, bool) {
+	i, ok := awsZones[zoneID]
+	return i, ok
+}
+
+// AWSZoneForIndex returns the availability zone ID with the given index,
+// its region, and whether it exists.
+func AWSZoneForIndex(i int) (zoneID, region string, ok bool) {
+	zoneID, ok = forIndex(awsZoneTable, i)
+	return zoneID, awsZoneRegion[zoneID], ok
+}
+
+// AWSZoneTable returns the availability zone IDs by index, for awsgen.go.
+func AWSZoneTable() []string {
+	return slices.Clone(awsZoneTable)
+}
+
+func zoneRegions(regionZones map[string][]string) map[string]string {
+	regions := map[string]string{}
+	for r, zones := range regionZones {
+		for _, z := range zones {
+			regions[z] = r
+		}
+	}
+	return regions
+}
@@ -0,0 +1,330 @@
+package cloud
+
+import "slices"
+
+// AzureTableVersion is the version of azureRegionTable and azureZoneTable.
+// azuregen.go bumps it whenever it appends to them.
+const AzureTableVersion = 1
+
+// azureRegionTable assigns the Azure region indices. It is append-only.
+var azureRegionTable = []string{
+	0:  "australiaeast",
+	1:  "brazilsouth",
+	2:  "centralindia",
+	3:  "eastus",
+	4:  "japaneast",
+	5:  "southafricanorth",
+	6:  "uaenorth",
+	7:  "westeurope",
+	8:  "australiacentral",
+	9:  "australiacentral2",
+	10: "australiasoutheast",
+	11: "austriaeast",
+	12: "brazilsoutheast",
+	13: "canadacentral",
+	14: "canadaeast",
+	15: "centralus",
+	16: "chilecentral",
+	17: "eastasia",
+	18: "eastus2",
+	19: "francecentral",
+	20: "francesouth",
+	21: "germanynorth",
+	22: "germanywestcentral",
+	23: "indonesiacentral",
+	24: "israelcentral",
+	25: "italynorth",
+	26: "japanwest",
+	27: "jioindiacentral",
+	28: "jioindiawest",
+	29: "koreacentral",
+	30: "koreasouth",
+	31: "malaysiawest",
+	32: "mexicocentral",
+	33: "newzealandnorth",
+	34: "northcentralus",
+	35: "northeurope",
+	36: "norwayeast",
+	37: "norwaywest",
+	38: "polandcentral",
+	39: "qatarcentral",
+	40: "southafricawest",
+	41: "southcentralus",
+	42: "southeastasia",
+	43: "southindia",
+	44: "spaincentral",
+	45: "swedencentral",
+	46: "switzerlandnorth",
+	47: "switzerlandwest",
+	48: "uaecentral",
+	49: "uksouth",
+	50: "ukwest",
+	51: "westcentralus",
+	52: "westindia",
+	53: "westus",
+	54: "westus2",
+	55: "westus3",
+}
+
+// azureZoneTable assigns the Azure zone indices, which are the cluster IDs
+// of AzureProvider. Zones are named "<region>-<zone>", or just "<region>" for
+// regions without availability zones. It is append-only. The first zones, one
+// per continent, give a global presence even when only 3 bits are used to
+// encode the cluster IDs.
+var azureZoneTable = []string{
+	0:   "australiaeast-1",
+	1:   "brazilsouth-1",
+	2:   "centralindia-1",
+	3:   "eastus-1",
+	4:   "japaneast-1",
+	5:   "southafricanorth-1",
+	6:   "uaenorth-1",
+	7:   "westeurope-1",
+	8:   "australiacentral",
+	9:   "australiacentral2",
+	10:  "australiaeast-2",
+	11:  "australiaeast-3",
+	12:  "australiasoutheast",
+	13:  "austriaeast-1",
+	14:  "austriaeast-2",
+	15:  "austriaeast-3",
+	16:  "brazilsouth-2",
+	17:  "brazilsouth-3",
+	18:  "brazilsoutheast",
+	19:  "canadacentral-1",
+	20:  "canadacentral-2",
+	21:  "canadacentral-3",
+	22:  "canadaeast",
+	23:  "centralindia-2",
+	24:  "centralindia-3",
+	25:  "centralus-1",
+	26:  "centralus-2",
+	27:  "centralus-3",
+	28:  "chilecentral-1",
+	29:  "chilecentral-2",
+	30:  "chilecentral-3",
+	31:  "eastasia-1",
+	32:  "eastasia-2",
+	33:  "eastasia-3",
+	34:  "eastus-2",
+	35:  "eastus-3",
+	36:  "eastus2-1",
+	37:  "eastus2-2",
+	38:  "eastus2-3",
+	39:  "francecentral-1",
+	40:  "francecentral-2",
+	41:  "francecentral-3",
+	42:  "francesouth",
+	43:  "germanynorth",
+	44:  "germanywestcentral-1",
+	45:  "germanywestcentral-2",
+	46:  "germanywestcentral-3",
+	47:  "indonesiacentral-1",
+	48:  "indonesiacentral-2",
+	49:  "indonesiacentral-3",
+	50:  "israelcentral-1",
+	51:  "israelcentral-2",
+	52:  "israelcentral-3",
+	53:  "italynorth-1",
+	54:  "italynorth-2",
+	55:  "italynorth-3",
+	56:  "japaneast-2",
+	57:  "japaneast-3",
+	58:  "japanwest-1",
+	59:  "japanwest-2",
+	60:  "japanwest-3",
+	61:  "jioindiacentral",
+	62:  "jioindiawest",
+	63:  "koreacentral-1",
+	64:  "koreacentral-2",
+	65:  "koreacentral-3",
+	66:  "koreasouth",
+	67:  "malaysiawest-1",
+	68:  "malaysiawest-2",
+	69:  "malaysiawest-3",
+	70:  "mexicocentral-1",
+	71:  "mexicocentral-2",
+	72:  "mexicocentral-3",
+	73:  "newzealandnorth-1",
+	74:  "newzealandnorth-2",
+	75:  "newzealandnorth-3",
+	76:  "northcentralus",
+	77:  "northeurope-1",
+	78:  "northeurope-2",
+	79:  "northeurope-3",
+	80:  "norwayeast-1",
+	81:  "norwayeast-2",
+	82:  "norwayeast-3",
+	83:  "norwaywest",
+	84:  "polandcentral-1",
+	85:  "polandcentral-2",
+	86:  "polandcentral-3",
+	87:  "qatarcentral-1",
+	88:  "qatarcentral-2",
+	89:  "qatarcentral-3",
+	90:  "southafricanorth-2",
+	91:  "southafricanorth-3",
+	92:  "southafricawest",
+	93:  "southcentralus-1",
+	94:  "southcentralus-2",
+	95:  "southcentralus-3",
+	96:  "southeastasia-1",
+	97:  "southeastasia-2",
+	98:  "southeastasia-3",
+	99:  "southindia",
+	100: "spaincentral-1",
+	101: "spaincentral-2",
+	102: "spaincentral-3",
+	103: "swedencentral-1",
+	104: "swedencentral-2",
+	105: "swedencentral-3",
+	106: "switzerlandnorth-1",
+	107: "switzerlandnorth-2",
+	108: "switzerlandnorth-3",
+	109: "switzerlandwest",
+	110: "uaecentral",
+	111: "uaenorth-2",
+	112: "uaenorth-3",
+	113: "uksouth-1",
+	114: "uksouth-2",
+	115: "uksouth-3",
+	116: "ukwest",
+	117: "westcentralus",
+	118: "westeurope-2",
+	119: "westeurope-3",
+	120: "westindia",
+	121: "westus",
+	122: "westus2-1",
+	123: "westus2-2",
+	124: "westus2-3",
+	125: "westus3-1",
+	126: "westus3-2",
+	127: "westus3-3",
+}
+
+// baseAzureRegionZones contains the current regions -> logical zones.
+// Every zone in it has an index in azureZoneTable.
+// Regions without availability zones have no zones listed.
+var baseAzureRegionZones = map[string][]string{
+	// Africa
+	"southafricanorth": {"1", "2", "3"},
+	"southafricawest":  {},
+
+	// Asia Pacific
+	"australiacentral":   {},
+	"australiacentral2":  {},
+	"australiaeast":      {"1", "2", "3"},
+	"australiasoutheast": {},
+	"centralindia":       {"1", "2", "3"},
+	"eastasia":           {"1", "2", "3"},
+	"indonesiacentral":   {"1", "2", "3"},
+	"japaneast":          {"1", "2", "3"},
+	"japanwest":          {"1", "2", "3"},
+	"jioindiacentral":    {},
+	"jioindiawest":       {},
+	"koreacentral":       {"1", "2", "3"},
+	"koreasouth":         {},
+	"malaysiawest":       {"1", "2", "3"},
+	"newzealandnorth":    {"1", "2", "3"},
+	"southeastasia":      {"1", "2", "3"},
+	"southindia":         {},
+	"westindia":          {},
+
+	// Canada
+	"canadacentral": {"1", "2", "3"},
+	"canadaeast":    {},
+
+	// Europe
+	"austriaeast":        {"1", "2", "3"},
+	"francecentral":      {"1", "2", "3"},
+	"francesouth":        {},
+	"germanynorth":       {},
+	"germanywestcentral": {"1", "2", "3"},
+	"italynorth":         {"1", "2", "3"},
+	"northeurope":        {"1", "2", "3"},
+	"norwayeast":         {"1", "2", "3"},
+	"norwaywest":         {},
+	"polandcentral":      {"1", "2", "3"},
+	"spaincentral":       {"1", "2", "3"},
+	"swedencentral":      {"1", "2", "3"},
+	"switzerlandnorth":   {"1", "2", "3"},
+	"switzerlandwest":    {},
+	"westeurope":         {"1", "2", "3"},
+
+	// Mexico
+	"mexicocentral": {"1", "2", "3"},
+
+	// Middle East
+	"israelcentral": {"1", "2", "3"},
+	"qatarcentral":  {"1", "2", "3"},
+	"uaecentral":    {},
+	"uaenorth":      {"1", "2", "3"},
+
+	// South America
+	"brazilsouth":     {"1", "2", "3"},
+	"brazilsoutheast": {},
+	"chilecentral":    {"1", "2", "3"},
+
+	// UK
+	"uksouth": {"1", "2", "3"},
+	"ukwest":  {},
+
+	// US
+	"centralus":      {"1", "2", "3"},
+	"eastus":         {"1", "2", "3"},
+	"eastus2":        {"1", "2", "3"},
+	"northcentralus": {},
+	"southcentralus": {"1", "2", "3"},
+	"westcentralus":  {},
+	"westus":         {},
+	"westus2":        {"1", "2", "3"},
+	"westus3":        {"1", "2", "3"},
+}
+
+// azureRegions and azureZones map the region and zone names to their indices.
+var (
+	azureRegions = indexTable(azureRegionTable)
+	azureZones   = indexTable(azureZoneTable)
+)
+
+// AzureRegionIndex returns the index for a region and whether it exists.
+func AzureRegionIndex(region string) (int, bool) {
+	i, ok := azureRegions[region]
+	return i, ok
+}
+
+// AzureZoneIndex returns the index for a zone and whether it exists.
+// Zones are named like the topology.kubernetes.io/zone label on AKS nodes,
+// e.g. "westeurope-1"; regions without zones are indexed by region name.
+func AzureZoneIndex(zone string) (int, bool) {
+	i, ok := azureZones[zone]
+	return i, ok
+}
+
+// AzureRegionForIndex returns the region with the given index and whether it exists.
+func AzureRegionForIndex(i int) (string, bool) {
+	return forIndex(azureRegionTable, i)
+}
+
+// AzureZoneForIndex returns the zone with the given index and whether it exists.
+func AzureZoneForIndex(i int) (string, bool) {
+	return forIndex(azureZoneTable, i)
+}
+
+// AzureZoneName returns the zone name for a region and logical zone.
+func AzureZoneName(region, zone string) string {
+	if zone == "" {
+		return region
+	}
+	return region + "-" + zone
+}
+
+// AzureRegionTable returns the region names by index, for azuregen.go.
+func AzureRegionTable() []string {
+	return slices.Clone(azureRegionTable)
+}
+
+// AzureZoneTable returns the zone names by index, for azuregen.go.
+func AzureZoneTable() []string {
+	return slices.Clone(azureZoneTable)
+}
@@ -1,33 +1,192 @@
 package cloud
 
-import (
-	"sort"
-)
+import "slices"
 
-// Regions maps GCP region name -> increasing integer (stable order).
-// Zones maps GCP zone name -> increasing integer (stable order).
-// Indices are assigned deterministically. Zones listed in topRegionZones
-// are guaranteed to take the first indices, in sorted(topRegionZones) order.
-var (
-	gcpRegions = map[string]int{}
-	gcpZones   = map[string]int{}
-)
+// GCPTableVersion is the version of gcpRegionTable and gcpZoneTable.
+// gcpgen.go bumps it whenever it appends to them.
+const GCPTableVersion = 1
 
-// topGcpRegionZones lists the top zones for each region.
-// They will take the first IDs to ensure a global presence
-// even when only 3 bits are used to encode the cluster IDs.
-var topGcpRegionZones = map[string][]string{
-	"africa-south1":        {"a"},
-	"asia-northeast1":      {"a"},
-	"asia-south2":          {"a"},
-	"australia-southeast2": {"a"},
-	"europe-north1":        {"a"},
-	"me-west1":             {"a"},
-	"southamerica-east1":   {"a"},
-	"us-central1":          {"a"},
+// gcpRegionTable assigns the GCP region indices. It is append-only.
+var gcpRegionTable = []string{
+	0:  "africa-south1",
+	1:  "asia-northeast1",
+	2:  "asia-south2",
+	3:  "australia-southeast2",
+	4:  "europe-north1",
+	5:  "me-west1",
+	6:  "southamerica-east1",
+	7:  "us-central1",
+	8:  "asia-east1",
+	9:  "asia-east2",
+	10: "asia-northeast2",
+	11: "asia-northeast3",
+	12: "asia-south1",
+	13: "asia-southeast1",
+	14: "asia-southeast2",
+	15: "australia-southeast1",
+	16: "europe-central2",
+	17: "europe-north2",
+	18: "europe-southwest1",
+	19: "europe-west1",
+	20: "europe-west10",
+	21: "europe-west12",
+	22: "europe-west2",
+	23: "europe-west3",
+	24: "europe-west4",
+	25: "europe-west6",
+	26: "europe-west8",
+	27: "europe-west9",
+	28: "me-central1",
+	29: "me-central2",
+	30: "northamerica-northeast1",
+	31: "northamerica-northeast2",
+	32: "northamerica-south1",
+	33: "southamerica-west1",
+	34: "us-east1",
+	35: "us-east4",
+	36: "us-east5",
+	37: "us-south1",
+	38: "us-west1",
+	39: "us-west2",
+	40: "us-west3",
+	41: "us-west4",
 }
 
-// baseRegionZones contains the baked-in regions -> zone letters.
+// gcpZoneTable assigns the GCP zone indices, which are the cluster IDs of
+// GCPProvider. It is append-only. The first zones, one per continent, give
+// a global presence even when only 3 bits are used to encode the cluster IDs.
+var gcpZoneTable = []string{
+	0:   "africa-south1-a",
+	1:   "asia-northeast1-a",
+	2:   "asia-south2-a",
+	3:   "australia-southeast2-a",
+	4:   "europe-north1-a",
+	5:   "me-west1-a",
+	6:   "southamerica-east1-a",
+	7:   "us-central1-a",
+	8:   "africa-south1-b",
+	9:   "africa-south1-c",
+	10:  "asia-east1-a",
+	11:  "asia-east1-b",
+	12:  "asia-east1-c",
+	13:  "asia-east2-a",
+	14:  "asia-east2-b",
+	15:  "asia-east2-c",
+	16:  "asia-northeast1-b",
+	17:  "asia-northeast1-c",
+	18:  "asia-northeast2-a",
+	19:  "asia-northeast2-b",
+	20:  "asia-northeast2-c",
+	21:  "asia-northeast3-a",
+	22:  "asia-northeast3-b",
+	23:  "asia-northeast3-c",
+	24:  "asia-south1-a",
+	25:  "asia-south1-b",
+	26:  "asia-south1-c",
+	27:  "asia-south2-b",
+	28:  "asia-south2-c",
+	29:  "asia-southeast1-a",
+	30:  "asia-southeast1-b",
+	31:  "asia-southeast1-c",
+	32:  "asia-southeast2-a",
+	33:  "asia-southeast2-b",
+	34:  "asia-southeast2-c",
+	35:  "australia-southeast1-a",
+	36:  "australia-southeast1-b",
+	37:  "australia-southeast1-c",
+	38:  "australia-southeast2-b",
+	39:  "australia-southeast2-c",
+	40:  "europe-central2-a",
+	41:  "europe-central2-b",
+	42:  "europe-central2-c",
+	43:  "europe-north1-b",
+	44:  "europe-north1-c",
+	45:  "europe-north2-a",
+	46:  "europe-north2-b",
+	47:  "europe-north2-c",
+	48:  "europe-southwest1-a",
+	49:  "europe-southwest1-b",
+	50:  "europe-southwest1-c",
+	51:  "europe-west1-b",
+	52:  "europe-west1-c",
+	53:  "europe-west1-d",
+	54:  "europe-west10-a",
+	55:  "europe-west10-b",
+	56:  "europe-west10-c",
+	57:  "europe-west12-a",
+	58:  "europe-west12-b",
+	59:  "europe-west12-c",
+	60:  "europe-west2-a",
+	61:  "europe-west2-b",
+	62:  "europe-west2-c",
+	63:  "europe-west3-a",
+	64:  "europe-west3-b",
+	65:  "europe-west3-c",
+	66:  "europe-west4-a",
+	67:  "europe-west4-b",
+	68:  "europe-west4-c",
+	69:  "europe-west6-a",
+	70:  "europe-west6-b",
+	71:  "europe-west6-c",
+	72:  "europe-west8-a",
+	73:  "europe-west8-b",
+	74:  "europe-west8-c",
+	75:  "europe-west9-a",
+	76:  "europe-west9-b",
+	77:  "europe-west9-c",
+	78:  "me-central1-a",
+	79:  "me-central1-b",
+	80:  "me-central1-c",
+	81:  "me-central2-a",
+	82:  "me-central2-b",
+	83:  "me-central2-c",
+	84:  "me-west1-b",
+	85:  "me-west1-c",
+	86:  "northamerica-northeast1-a",
+	87:  "northamerica-northeast1-b",
+	88:  "northamerica-northeast1-c",
+	89:  "northamerica-northeast2-a",
+	90:  "northamerica-northeast2-b",
+	91:  "northamerica-northeast2-c",
+	92:  "northamerica-south1-a",
+	93:  "northamerica-south1-b",
+	94:  "northamerica-south1-c",
+	95:  "southamerica-east1-b",
+	96:  "southamerica-east1-c",
+	97:  "southamerica-west1-a",
+	98:  "southamerica-west1-b",
+	99:  "southamerica-west1-c",
+	100: "us-central1-b",
+	101: "us-central1-c",
+	102: "us-central1-f",
+	103: "us-east1-b",
+	104: "us-east1-c",
+	105: "us-east1-d",
+	106: "us-east4-a",
+	107: "us-east4-b",
+	108: "us-east4-c",
+	109: "us-east5-a",
+	110: "us-east5-b",
+	111: "us-east5-c",
+	112: "us-south1-a",
+	113: "us-south1-b",
+	114: "us-south1-c",
+	115: "us-west1-a",
+	116: "us-west1-b",
+	117: "us-west1-c",
+	118: "us-west2-a",
+	119: "us-west2-b",
+	120: "us-west2-c",
+	121: "us-west3-a",
+	122: "us-west3-b",
+	123: "us-west3-c",
+	124: "us-west4-a",
+	125: "us-west4-b",
+	126: "us-west4-c",
+}
+
+// baseGcpRegionZones contains the regions -> zone letters that are currently up.
+// Every zone in it has an index in gcpZoneTable.
 var baseGcpRegionZones = map[string][]string{
 	// Africa
 	"africa-south1": {"a", "b", "c"},
@@ -86,10 +245,11 @@ var baseGcpRegionZones = map[string][]string{
 	"southamerica-west1": {"a", "b", "c"},
 }
 
-// init builds the index maps using the current data.
-func init() {
-	rebuildIndices()
-}
+// gcpRegions and gcpZones map the region and zone names to their indices.
+var (
+	gcpRegions = indexTable(gcpRegionTable)
+	gcpZones   = indexTable(gcpZoneTable)
+)
 
 // GCPRegionIndex returns the index for a region and whether it exists.
 func GCPRegionIndex(region string) (int, bool) {
@@ -103,91 +263,22 @@ func GCPZoneIndex(zone string) (int, bool) {
 	return i, ok
 }
 
-// rebuildIndices rebuilds Regions and Zones ensuring topRegionZones come first.
-func rebuildIndices() {
-	gcpRegions = map[string]int{}
-	gcpZones = map[string]int{}
-
-	// Collect regions
-	allRegions := make([]string, 0, len(baseGcpRegionZones))
-	for r := range baseGcpRegionZones {
-		allRegions = append(allRegions, r)
-	}
-	sort.Strings(allRegions)
-
-	// Top regions (that exist in the dataset), sorted
-	topRegions := make([]string, 0, len(topGcpRegionZones))
-	for r := range topGcpRegionZones {
-		if _, ok := baseGcpRegionZones[r]; ok {
-			topRegions = append(topRegions, r)
-		}
-	}
-	sort.Strings(topRegions)
-
-	// Regions: top first, then the rest
-	topSet := make(map[string]struct{}, len(topRegions))
-	for _, r := range topRegions {
-		topSet[r] = struct{}{}
-	}
-	restRegions := make([]string, 0, len(allRegions))
-	for _, r := range allRegions {
-		if _, ok := topSet[r]; !ok {
-			restRegions = append(restRegions, r)
-		}
-	}
-
-	rIdx := 0
-	for _, r := range topRegions {
-		gcpRegions[r] = rIdx
-		rIdx++
-	}
-	for _, r := range restRegions {
-		gcpRegions[r] = rIdx
-		rIdx++
-	}
-
-	// Zones: topGcpRegionZones first (only if present), then remaining zones by region asc, letter asc.
-	zIdx := 0
-	added := make(map[string]struct{}, 128)
-
-	for _, r := range topRegions {
-		letters := append([]string(nil), topGcpRegionZones[r]...)
-		sort.Strings(letters)
-		for _, l := range letters {
-			// Add only if this zone exists in baseGcpRegionZones
-			if !hasLetter(baseGcpRegionZones[r], l) {
-				continue
-			}
-			zone := r + "-" + l
-			if _, ok := added[zone]; ok {
-				continue
-			}
-			gcpZones[zone] = zIdx
-			added[zone] = struct{}{}
-			zIdx++
-		}
-	}
-
-	for _, r := range allRegions {
-		letters := append([]string(nil), baseGcpRegionZones[r]...)
-		sort.Strings(letters)
-		for _, l := range letters {
-			zone := r + "-" + l
-			if _, ok := added[zone]; ok {
-				continue
-			}
-			gcpZones[zone] = zIdx
-			added[zone] = struct{}{}
-			zIdx++
-		}
-	}
+// GCPRegionForIndex returns the region with the given index and whether it exists.
+func GCPRegionForIndex(i int) (string, bool) {
+	return forIndex(gcpRegionTable, i)
+}
+
+// GCPZoneForIndex returns the zone with the given index and whether it exists.
+func GCPZoneForIndex(i int) (string, bool) {
+	return forIndex(gcpZoneTable, i)
+}
+
+// GCPRegionTable returns the region names by index, for gcpgen.go.
+func GCPRegionTable() []string {
+	return slices.Clone(gcpRegionTable)
 }
 
-func hasLetter(letters []string, want string) bool {
-	for _, l := range letters {
-		if l == want {
-			return true
-		}
-	}
-	return false
+// GCPZoneTable returns the zone names by index, for gcpgen.go.
+func GCPZoneTable() []string {
+	return slices.Clone(gcpZoneTable)
 }
@@ -1,3 +1,5 @@
+//go:build ignore
+
 package main
 
 import (
@@ -11,6 +13,8 @@ import (
 	"sort"
 	"strings"
 	"text/template"
+
+	cloud "github.com/FlorinBalint/kubeflake/internal/cloud"
 )
 
 // AWSRegion represents an AWS region from the AWS CLI output
@@ -25,52 +29,77 @@ type AWSRegionsOutput struct {
 	Regions []AWSRegion `json:"Regions"`
 }
 
+// AWSAvailabilityZone represents an availability zone from the AWS CLI output
+type AWSAvailabilityZone struct {
+	ZoneName   string `json:"ZoneName"`
+	ZoneId     string `json:"ZoneId"`
+	ZoneType   string `json:"ZoneType"`
+	RegionName string `json:"RegionName"`
+}
+
+// AWSAvailabilityZonesOutput represents the output from describe-availability-zones
+type AWSAvailabilityZonesOutput struct {
+	AvailabilityZones []AWSAvailabilityZone `json:"AvailabilityZones"`
+}
+
 // Config represents the template configuration
 type Config struct {
 	AllRegions []string
-	TopRegions []string
+	// Version and Table are the versioned, append-only region index table
+	Version int
+	Table   []string
+}
+
+// ZonesConfig represents the availability zones template configuration
+type ZonesConfig struct {
+	RegionZones map[string][]string
+	// Table is the append-only zone index table, versioned with the regions
+	Table []string
 }
 
 // TemplateData represents the data passed to the template
 type TemplateData struct {
-	Config Config
+	Config any
 }
 
-// parseTopRegions parses the top regions override flag format: "region1,region2,region3"
-func parseTopRegions(topRegionsFlag string) []string {
-	if topRegionsFlag == "" {
-		return []string{}
+// GenerateAWSFiles runs AWS CLI commands and generates the awsregions.go and
+// awszones.go files. New regions and zones are appended to the index tables,
+// existing ones keep their indices even if they are gone.
+func GenerateAWSFiles() error {
+	// Get AWS regions, then the availability zones of each of them
+	regions, err := getAWSRegions()
+	if err != nil {
+		return fmt.Errorf("failed to get AWS regions: %w", err)
 	}
+	config := processRegionsIntoConfig(regions)
 
-	regions := strings.Split(topRegionsFlag, ",")
-	result := make([]string, 0, len(regions))
-	for _, region := range regions {
-		trimmed := strings.TrimSpace(region)
-		if trimmed != "" {
-			result = append(result, trimmed)
+	regionZones := make(map[string][]string, len(config.AllRegions))
+	for _, region := range config.AllRegions {
+		zones, err := getAWSAvailabilityZones(region)
+		if err != nil {
+			return fmt.Errorf("failed to get AWS availability zones for %s: %w", region, err)
 		}
+		regionZones[region] = zones
 	}
-	return result
-}
+	zonesConfig := ZonesConfig{RegionZones: regionZones}
 
-// GenerateAWSRegionsFile runs AWS CLI commands and generates awsregions.go file
-func GenerateAWSRegionsFile(customTopRegions []string) error {
-	// Get AWS regions
-	regions, err := getAWSRegions()
-	if err != nil {
-		return fmt.Errorf("failed to get AWS regions: %w", err)
+	// Both tables share AWSTableVersion, so extend them before generating either file
+	if err := extendTables(&config, &zonesConfig); err != nil {
+		return fmt.Errorf("failed to extend the index tables: %w", err)
 	}
 
-	// Process regions into config
-	config := processRegionsIntoConfig(regions, customTopRegions)
-
-	// Generate the file from template
-	err = generateFileFromTemplate(config)
+	// Generate the files from templates
+	err = generateFileFromTemplate("awsregions", config)
 	if err != nil {
 		return fmt.Errorf("failed to generate file from template: %w", err)
 	}
-
 	fmt.Println("Successfully generated awsregions.go")
+
+	err = generateFileFromTemplate("awszones", zonesConfig)
+	if err != nil {
+		return fmt.Errorf("failed to generate file from template: %w", err)
+	}
+	fmt.Println("Successfully generated awszones.go")
 	return nil
 }
 
@@ -91,8 +120,33 @@ func getAWSRegions() ([]AWSRegion, error) {
 	return regionsOutput.Regions, nil
 }
 
+// getAWSAvailabilityZones runs AWS CLI to get the availability zone IDs of a region.
+// Local and Wavelength zones are skipped, they are extensions of a parent zone.
+func getAWSAvailabilityZones(region string) ([]string, error) {
+	cmd := exec.Command("aws", "ec2", "describe-availability-zones", "--all-availability-zones", "--region", region, "--output=json")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run aws ec2 describe-availability-zones command: %w", err)
+	}
+
+	var zonesOutput AWSAvailabilityZonesOutput
+	err = json.Unmarshal(output, &zonesOutput)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON output: %w", err)
+	}
+
+	zones := make([]string, 0, len(zonesOutput.AvailabilityZones))
+	for _, zone := range zonesOutput.AvailabilityZones {
+		if zone.ZoneType == "availability-zone" {
+			zones = append(zones, zone.ZoneId)
+		}
+	}
+	sort.Strings(zones)
+	return zones, nil
+}
+
 // processRegionsIntoConfig converts regions into the config structure expected by the template
-func processRegionsIntoConfig(regions []AWSRegion, customTopRegions []string) Config {
+func processRegionsIntoConfig(regions []AWSRegion) Config {
 	allRegions := make([]string, 0, len(regions))
 
 	// Collect all opted-in regions
@@ -105,31 +159,58 @@ func processRegionsIntoConfig(regions []AWSRegion, customTopRegions []string) Co
 
 	sort.Strings(allRegions)
 
-	// Validate custom top regions
-	validTopRegions := make([]string, 0, len(customTopRegions))
-	regionSet := make(map[string]bool)
-	for _, r := range allRegions {
-		regionSet[r] = true
+	return Config{
+		AllRegions: allRegions,
+	}
+}
+
+// extendTables appends the new regions and zones to the index tables, the
+// zones by region then zone ID, bumps the table version if any were added
+// and records their indices in the golden files of the tests.
+func extendTables(config *Config, zonesConfig *ZonesConfig) error {
+	var zones []string
+	for _, region := range config.AllRegions {
+		zones = append(zones, zonesConfig.RegionZones[region]...)
 	}
 
-	for _, region := range customTopRegions {
-		if regionSet[region] {
-			validTopRegions = append(validTopRegions, region)
-		} else {
-			fmt.Printf("Warning: Region '%s' not found in available regions\n", region)
-		}
+	regionTable, addedRegions := cloud.ExtendTable(cloud.AWSRegionTable(), config.AllRegions)
+	zoneTable, addedZones := cloud.ExtendTable(cloud.AWSZoneTable(), zones)
+	config.Version = cloud.AWSTableVersion
+	config.Table = regionTable
+	zonesConfig.Table = zoneTable
+	if len(addedRegions) == 0 && len(addedZones) == 0 {
+		return nil
 	}
 
-	return Config{
-		AllRegions: allRegions,
-		TopRegions: validTopRegions,
+	config.Version++
+	fmt.Printf("Table version %d adds regions %v and zones %v\n", config.Version, addedRegions, addedZones)
+	if err := recordAssignments("aws_regions", config.Version, regionTable, addedRegions); err != nil {
+		return err
 	}
+	return recordAssignments("aws_zones", config.Version, zoneTable, addedZones)
 }
 
-// generateFileFromTemplate generates the awsregions.go file using the template
-func generateFileFromTemplate(config Config) error {
+// recordAssignments appends the indices of the added table entries to
+// testdata/<name>.golden, which the tests check the tables against.
+func recordAssignments(name string, version int, table, added []string) error {
+	if len(added) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join("..", "testdata", name+".golden"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	first := len(table) - len(added)
+	for i, entry := range added {
+		fmt.Fprintf(f, "%d %d %s\n", version, first+i, entry)
+	}
+	return f.Close()
+}
+
+// generateFileFromTemplate generates the <name>.go file using the <name>.go.template template
+func generateFileFromTemplate(name string, config any) error {
 	// Read the template file (relative to current directory)
-	templatePath := "templates/awsregions.go.template"
+	templatePath := "templates/" + name + ".go.template"
 	templateContent, err := os.ReadFile(templatePath)
 	if err != nil {
 		return fmt.Errorf("failed to read template file: %w", err)
@@ -148,7 +229,7 @@ func generateFileFromTemplate(config Config) error {
 	}
 
 	// Parse the template
-	tmpl, err := template.New("awsregions").Funcs(funcMap).Parse(string(templateContent))
+	tmpl, err := template.New(name).Funcs(funcMap).Parse(string(templateContent))
 	if err != nil {
 		return fmt.Errorf("failed to parse template: %w", err)
 	}
@@ -161,7 +242,7 @@ func generateFileFromTemplate(config Config) error {
 	}
 
 	// Create output file
-	outputPath := filepath.Join(outputDir, "awsregions.go")
+	outputPath := filepath.Join(outputDir, name+".go")
 	outputFile, err := os.Create(outputPath)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
@@ -179,43 +260,9 @@ func generateFileFromTemplate(config Config) error {
 }
 
 func main() {
-	var (
-		topRegionsFlag string
-	)
-
-	flag.StringVar(&topRegionsFlag, "top-regions", "", "Override top regions in format 'region1,region2,region3' (exactly 2, 4 or 8 regions, e.g., 'us-east-1,eu-west-1,ap-southeast-1,us-west-2'). If not provided, uses default regions.")
 	flag.Parse()
-
-	var customTopRegions []string
-
-	if topRegionsFlag == "" {
-		// Use default hardcoded regions (8 regions for global coverage)
-		customTopRegions = []string{
-			"ap-southeast-2", // Sydney
-			"eu-west-2",      // London
-			"us-west-1",      // North California
-			"ap-east-1",      // Hong Kong
-			"af-south-1",     // South Africa, Cape Town
-			"sa-east-1",      // São Paulo
-			"me-central-1",   // Bahrain
-			"ca-central-1",   // Canada Central
-		}
-		fmt.Printf("Using default 8 top regions: %v\n", customTopRegions)
-		fmt.Println("Note: Ensure you have AWS credentials configured and the AWS CLI is accessible.")
-	} else {
-		// Parse the custom top regions
-		customTopRegions = parseTopRegions(topRegionsFlag)
-
-		// Validate that exactly 2, 4 or 8 top regions are provided
-		if len(customTopRegions) != 2 && len(customTopRegions) != 4 && len(customTopRegions) != 8 {
-			log.Fatalf("Error: You must provide exactly 2, 4 or 8 top regions, but you provided %d regions.\nProvided regions: %v", len(customTopRegions), customTopRegions)
-		}
-
-		fmt.Printf("Using %d custom top regions: %v\n", len(customTopRegions), customTopRegions)
-	}
-
-	err := GenerateAWSRegionsFile(customTopRegions)
+	err := GenerateAWSFiles()
 	if err != nil {
-		log.Fatalf("Error generating AWS regions file: %v", err)
+		log.Fatalf("Error generating AWS regions and zones files: %v", err)
 	}
 }
@@ -0,0 +1,250 @@
+//go:build ignore
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	cloud "github.com/FlorinBalint/kubeflake/internal/cloud"
+)
+
+// AzureLocation represents an Azure location from the az CLI output
+type AzureLocation struct {
+	Name     string `json:"name"`
+	Metadata struct {
+		RegionType     string `json:"regionType"`
+		GeographyGroup string `json:"geographyGroup"`
+	} `json:"metadata"`
+	AvailabilityZoneMappings []struct {
+		LogicalZone string `json:"logicalZone"`
+	} `json:"availabilityZoneMappings"`
+}
+
+// RegionInfo represents a region with its logical zones
+type RegionInfo struct {
+	Name  string
+	Zones []string
+}
+
+// Config represents the template configuration
+type Config struct {
+	AllRegions map[string][]RegionInfo
+	// Version, RegionTable and ZoneTable are the versioned, append-only index tables
+	Version     int
+	RegionTable []string
+	ZoneTable   []string
+}
+
+// TemplateData represents the data passed to the template
+type TemplateData struct {
+	Config Config
+}
+
+// GenerateAzureRegionsFile runs the az CLI and generates azureregions.go file.
+// New regions and zones are appended to the index tables, existing ones
+// keep their indices even if they are gone.
+func GenerateAzureRegionsFile() error {
+	// Run az account list-locations command
+	locations, err := getAzureLocations()
+	if err != nil {
+		return fmt.Errorf("failed to get Azure locations: %w", err)
+	}
+
+	// Process locations into regions, and extend the index tables with the new ones
+	config := processLocationsIntoConfig(locations)
+	if err := extendTables(&config); err != nil {
+		return fmt.Errorf("failed to extend the index tables: %w", err)
+	}
+
+	// Generate the file from template
+	err = generateFileFromTemplate(config)
+	if err != nil {
+		return fmt.Errorf("failed to generate file from template: %w", err)
+	}
+
+	fmt.Println("Successfully generated azureregions.go")
+	return nil
+}
+
+// getAzureLocations runs the az CLI and parses the JSON output
+func getAzureLocations() ([]AzureLocation, error) {
+	cmd := exec.Command("az", "account", "list-locations", "--output=json")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run az account list-locations command: %w", err)
+	}
+
+	var locations []AzureLocation
+	err = json.Unmarshal(output, &locations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON output: %w", err)
+	}
+
+	return locations, nil
+}
+
+// processLocationsIntoConfig converts locations into the config structure expected by the template
+func processLocationsIntoConfig(locations []AzureLocation) Config {
+	geographyMap := make(map[string][]RegionInfo)
+
+	for _, location := range locations {
+		// Logical regions (e.g. "europe", "global") cannot host a cluster
+		if location.Metadata.RegionType != "Physical" {
+			continue
+		}
+
+		zones := make([]string, 0, len(location.AvailabilityZoneMappings))
+		for _, mapping := range location.AvailabilityZoneMappings {
+			zones = append(zones, mapping.LogicalZone)
+		}
+		sort.Strings(zones)
+
+		geography := location.Metadata.GeographyGroup
+		if geography == "" {
+			geography = "Other"
+		}
+		geographyMap[geography] = append(geographyMap[geography], RegionInfo{
+			Name:  location.Name,
+			Zones: zones,
+		})
+	}
+
+	// Sort regions within each geography
+	for geography := range geographyMap {
+		sort.Slice(geographyMap[geography], func(i, j int) bool {
+			return geographyMap[geography][i].Name < geographyMap[geography][j].Name
+		})
+	}
+
+	return Config{
+		AllRegions: geographyMap,
+	}
+}
+
+// extendTables appends the new regions and zones of config to the index
+// tables, by region name then logical zone, bumps the table version if any
+// were added and records their indices in the golden files of the tests.
+// A region without availability zones is a zone of its own.
+func extendTables(config *Config) error {
+	var regions []RegionInfo
+	for _, geography := range config.AllRegions {
+		regions = append(regions, geography...)
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].Name < regions[j].Name
+	})
+
+	var regionNames, zones []string
+	for _, region := range regions {
+		regionNames = append(regionNames, region.Name)
+		if len(region.Zones) == 0 {
+			zones = append(zones, cloud.AzureZoneName(region.Name, ""))
+		}
+		for _, zone := range region.Zones {
+			zones = append(zones, cloud.AzureZoneName(region.Name, zone))
+		}
+	}
+
+	regionTable, addedRegions := cloud.ExtendTable(cloud.AzureRegionTable(), regionNames)
+	zoneTable, addedZones := cloud.ExtendTable(cloud.AzureZoneTable(), zones)
+	config.Version = cloud.AzureTableVersion
+	config.RegionTable = regionTable
+	config.ZoneTable = zoneTable
+	if len(addedRegions) == 0 && len(addedZones) == 0 {
+		return nil
+	}
+
+	config.Version++
+	fmt.Printf("Table version %d adds regions %v and zones %v\n", config.Version, addedRegions, addedZones)
+	if err := recordAssignments("azure_regions", config.Version, regionTable, addedRegions); err != nil {
+		return err
+	}
+	return recordAssignments("azure_zones", config.Version, zoneTable, addedZones)
+}
+
+// recordAssignments appends the indices of the added table entries to
+// testdata/<name>.golden, which the tests check the tables against.
+func recordAssignments(name string, version int, table, added []string) error {
+	if len(added) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join("..", "testdata", name+".golden"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	first := len(table) - len(added)
+	for i, entry := range added {
+		fmt.Fprintf(f, "%d %d %s\n", version, first+i, entry)
+	}
+	return f.Close()
+}
+
+// generateFileFromTemplate generates the azureregions.go file using the template
+func generateFileFromTemplate(config Config) error {
+	// Read the template file (relative to current directory)
+	templatePath := "templates/azureregions.go.template"
+	templateContent, err := os.ReadFile(templatePath)
+	if err != nil {
+		return fmt.Errorf("failed to read template file: %w", err)
+	}
+
+	// Create custom template functions
+	funcMap := template.FuncMap{
+		"join": func(zones []string, sep string) string {
+			// Quote each zone and join with separator
+			quoted := make([]string, len(zones))
+			for i, zone := range zones {
+				quoted[i] = fmt.Sprintf(`"%s"`, zone)
+			}
+			return strings.Join(quoted, sep)
+		},
+	}
+
+	// Parse the template
+	tmpl, err := template.New("azureregions").Funcs(funcMap).Parse(string(templateContent))
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	// Create output directory if it doesn't exist (relative to project root)
+	outputDir := "../"
+	err = os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Create output file
+	outputPath := filepath.Join(outputDir, "azureregions.go")
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outputFile.Close()
+
+	// Execute template
+	data := TemplateData{Config: config}
+	err = tmpl.Execute(outputFile, data)
+	if err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	fmt.Println("Note: Ensure you are logged in with 'az login' and the az CLI is accessible.")
+	err := GenerateAzureRegionsFile()
+	if err != nil {
+		log.Fatalf("Error generating Azure regions file: %v", err)
+	}
+}
@@ -1,3 +1,5 @@
+//go:build ignore
+
 package main
 
 import (
@@ -11,10 +13,8 @@ import (
 	"sort"
 	"strings"
 	"text/template"
-)
 
-var (
-	topZonesFlag = flag.String("top-zones", "", "Override top zones in format 'region1:zone1,region2:zone2' (exactly 4 or 8 regions, e.g., 'us-central1:b,europe-west1:c,asia-east1:a,australia-southeast1:a'). If not provided, uses default regions.")
+	cloud "github.com/FlorinBalint/kubeflake/internal/cloud"
 )
 
 // GCPZone represents a GCP zone from the gcloud output
@@ -30,15 +30,13 @@ type RegionInfo struct {
 	Zones []string
 }
 
-// ZoneConfig represents the top zone for a region
-type ZoneConfig struct {
-	Id string
-}
-
 // Config represents the template configuration
 type Config struct {
 	AllRegions map[string][]RegionInfo
-	TopZones   map[string]ZoneConfig
+	// Version, RegionTable and ZoneTable are the versioned, append-only index tables
+	Version     int
+	RegionTable []string
+	ZoneTable   []string
 }
 
 // TemplateData represents the data passed to the template
@@ -46,35 +44,21 @@ type TemplateData struct {
 	Config Config
 }
 
-// parseTopZones parses the top zones override flag format: "region1:zone1,region2:zone2"
-func parseTopZones(topZonesFlag string) map[string]string {
-	topZones := make(map[string]string)
-	if topZonesFlag == "" {
-		return topZones
-	}
-
-	pairs := strings.Split(topZonesFlag, ",")
-	for _, pair := range pairs {
-		parts := strings.Split(strings.TrimSpace(pair), ":")
-		if len(parts) == 2 {
-			region := strings.TrimSpace(parts[0])
-			zone := strings.TrimSpace(parts[1])
-			topZones[region] = zone
-		}
-	}
-	return topZones
-}
-
-// GenerateGCPZonesFile runs gcloud command and generates gcpzones.go file
-func GenerateGCPZonesFile(customTopZones map[string]string) error {
+// GenerateGCPZonesFile runs gcloud command and generates gcpzones.go file.
+// New regions and zones are appended to the index tables, existing ones
+// keep their indices even if they are gone.
+func GenerateGCPZonesFile() error {
 	// Run gcloud compute zones list command
 	zones, err := getGCPZones()
 	if err != nil {
 		return fmt.Errorf("failed to get GCP zones: %w", err)
 	}
 
-	// Process zones into regions
-	config := processZonesIntoConfig(zones, customTopZones)
+	// Process zones into regions, and extend the index tables with the new ones
+	config := processZonesIntoConfig(zones)
+	if err := extendTables(&config); err != nil {
+		return fmt.Errorf("failed to extend the index tables: %w", err)
+	}
 
 	// Generate the file from template
 	err = generateFileFromTemplate(config)
@@ -104,7 +88,7 @@ func getGCPZones() ([]GCPZone, error) {
 }
 
 // processZonesIntoConfig converts zones into the config structure expected by the template
-func processZonesIntoConfig(zones []GCPZone, customTopZones map[string]string) Config {
+func processZonesIntoConfig(zones []GCPZone) Config {
 	regionMap := make(map[string][]string)
 	continentMap := make(map[string][]RegionInfo)
 
@@ -151,15 +135,61 @@ func processZonesIntoConfig(zones []GCPZone, customTopZones map[string]string) C
 		})
 	}
 
-	// Select top zones (first available zone in major regions or use custom overrides)
-	topZones := selectTopZones(regionMap, customTopZones)
-
 	return Config{
 		AllRegions: continentMap,
-		TopZones:   topZones,
 	}
 }
 
+// extendTables appends the new regions and zones of config to the index
+// tables, by region name then zone letter, bumps the table version if any
+// were added and records their indices in the golden files of the tests.
+func extendTables(config *Config) error {
+	var regions, zones []string
+	for _, continent := range config.AllRegions {
+		for _, region := range continent {
+			regions = append(regions, region.Name)
+			for _, letter := range region.Zones {
+				zones = append(zones, region.Name+"-"+letter)
+			}
+		}
+	}
+	sort.Strings(regions)
+	sort.Strings(zones)
+
+	regionTable, addedRegions := cloud.ExtendTable(cloud.GCPRegionTable(), regions)
+	zoneTable, addedZones := cloud.ExtendTable(cloud.GCPZoneTable(), zones)
+	config.Version = cloud.GCPTableVersion
+	config.RegionTable = regionTable
+	config.ZoneTable = zoneTable
+	if len(addedRegions) == 0 && len(addedZones) == 0 {
+		return nil
+	}
+
+	config.Version++
+	fmt.Printf("Table version %d adds regions %v and zones %v\n", config.Version, addedRegions, addedZones)
+	if err := recordAssignments("gcp_regions", config.Version, regionTable, addedRegions); err != nil {
+		return err
+	}
+	return recordAssignments("gcp_zones", config.Version, zoneTable, addedZones)
+}
+
+// recordAssignments appends the indices of the added table entries to
+// testdata/<name>.golden, which the tests check the tables against.
+func recordAssignments(name string, version int, table, added []string) error {
+	if len(added) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join("..", "testdata", name+".golden"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	first := len(table) - len(added)
+	for i, entry := range added {
+		fmt.Fprintf(f, "%d %d %s\n", version, first+i, entry)
+	}
+	return f.Close()
+}
+
 // classifyRegionByContinent provides a rough continent classification
 func classifyRegionByContinent(region string) string {
 	switch {
@@ -182,34 +212,6 @@ func classifyRegionByContinent(region string) string {
 	}
 }
 
-// selectTopZones validates and applies the custom top zones (no defaults since zones are required)
-func selectTopZones(regionMap map[string][]string, customTopZones map[string]string) map[string]ZoneConfig {
-	topZones := make(map[string]ZoneConfig)
-
-	// Apply and validate custom top zones
-	for region, zone := range customTopZones {
-		if zones, exists := regionMap[region]; exists {
-			// Validate that the specified zone exists for this region
-			found := false
-			for _, z := range zones {
-				if z == zone {
-					found = true
-					break
-				}
-			}
-			if found {
-				topZones[region] = ZoneConfig{Id: zone}
-			} else {
-				fmt.Printf("Warning: Zone '%s' not found for region '%s', available zones: %v\n", zone, region, zones)
-			}
-		} else {
-			fmt.Printf("Warning: Region '%s' not found in available regions\n", region)
-		}
-	}
-
-	return topZones
-}
-
 // generateFileFromTemplate generates the gcpzones.go file using the template
 func generateFileFromTemplate(config Config) error {
 	// Read the template file (relative to current directory)
@@ -264,34 +266,7 @@ func generateFileFromTemplate(config Config) error {
 
 func main() {
 	flag.Parse()
-	var customTopZones map[string]string
-
-	if *topZonesFlag == "" {
-		// Use default hardcoded regions (8 regions for global coverage)
-		customTopZones = map[string]string{
-			"us-central1":          "a",
-			"europe-north1":        "a",
-			"asia-northeast1":      "a",
-			"australia-southeast2": "a",
-			"southamerica-east1":   "a",
-			"africa-south1":        "a",
-			"me-west1":             "a",
-			"asia-south2":          "a",
-		}
-		fmt.Printf("Using default 8 top zones: %v\n", customTopZones)
-	} else {
-		// Parse the custom top zones
-		customTopZones = parseTopZones(*topZonesFlag)
-
-		// Validate that exactly 2, 4 or 8 top regions are provided
-		if len(customTopZones) != 2 && len(customTopZones) != 4 && len(customTopZones) != 8 {
-			log.Fatalf("Error: You must provide exactly 2, 4 or 8 top zones, but you provided %d zones.\nProvided zones: %v", len(customTopZones), customTopZones)
-		}
-
-		fmt.Printf("Using %d custom top zones: %v\n", len(customTopZones), customTopZones)
-	}
-
-	err := GenerateGCPZonesFile(customTopZones)
+	err := GenerateGCPZonesFile()
 	if err != nil {
 		log.Fatalf("Error generating GCP zones file: %v", err)
 	}
@@ -0,0 +1,49 @@
+package cloud
+
+import "slices"
+
+// The region and zone indices, which become cluster IDs, are assigned by
+// append-only tables instead of being derived from the sorted names: a new
+// region must never shift the index of another one, or two versions of the
+// library would give the same cluster ID to different zones. The generators
+// only append to the tables, bump the table version of the cloud when they
+// do, and record the new assignments in testdata/*.golden, which the tests
+// check the tables against.
+
+// indexTable maps the names in table to their indices. It panics on a
+// duplicate name, which would give two cluster IDs to one location.
+func indexTable(table []string) map[string]int {
+	indices := make(map[string]int, len(table))
+	for i, name := range table {
+		if _, ok := indices[name]; ok {
+			panic("cloud: duplicate table entry " + name)
+		}
+		indices[name] = i
+	}
+	return indices
+}
+
+// forIndex returns the name with index i in table and whether it exists.
+func forIndex(table []string, i int) (string, bool) {
+	if i < 0 || i >= len(table) {
+		return "", false
+	}
+	return table[i], true
+}
+
+// ExtendTable returns table with the names it lacks appended, in the order
+// of names, and the appended names. The names in table keep their indices,
+// including those missing from names, e.g. retired zones.
+func ExtendTable(table, names []string) (extended, added []string) {
+	extended = slices.Clone(table)
+	indices := indexTable(table)
+	for _, name := range names {
+		if _, ok := indices[name]; ok {
+			continue
+		}
+		indices[name] = len(extended)
+		extended = append(extended, name)
+		added = append(added, name)
+	}
+	return extended, added
+}
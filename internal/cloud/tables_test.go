@@ -0,0 +1,146 @@
+package cloud
+
+import (
+	"bufio"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type goldenEntry struct {
+	version int
+	index   int
+	name    string
+}
+
+func readGolden(t *testing.T, name string) []goldenEntry {
+	t.Helper()
+	f, err := os.Open("testdata/" + name + ".golden")
+	if err != nil {
+		t.Fatalf("opening the golden file: %v", err)
+	}
+	defer f.Close()
+
+	var entries []goldenEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			t.Fatalf("%s: malformed line %q", name, line)
+		}
+		version, err1 := strconv.Atoi(fields[0])
+		index, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			t.Fatalf("%s: malformed line %q", name, line)
+		}
+		entries = append(entries, goldenEntry{version: version, index: index, name: fields[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("reading the golden file: %v", err)
+	}
+	return entries
+}
+
+// TestTables_AssignmentsNeverChange checks the tables against every
+// assignment recorded so far: a generator that reorders or drops entries
+// would remap the cluster IDs of existing clusters.
+func TestTables_AssignmentsNeverChange(t *testing.T) {
+	tables := []struct {
+		golden  string
+		table   []string
+		version int
+	}{
+		{"gcp_regions", gcpRegionTable, GCPTableVersion},
+		{"gcp_zones", gcpZoneTable, GCPTableVersion},
+		{"aws_regions", awsRegionTable, AWSTableVersion},
+		{"aws_zones", awsZoneTable, AWSTableVersion},
+		{"azure_regions", azureRegionTable, AzureTableVersion},
+		{"azure_zones", azureZoneTable, AzureTableVersion},
+	}
+	for _, tt := range tables {
+		t.Run(tt.golden, func(t *testing.T) {
+			entries := readGolden(t, tt.golden)
+			if len(entries) != len(tt.table) {
+				t.Fatalf("%d entries recorded for a table of %d, the generator must record every new entry", len(entries), len(tt.table))
+			}
+			version := 0
+			for i, e := range entries {
+				if e.index != i {
+					t.Fatalf("entry %d is recorded with index %d", i, e.index)
+				}
+				if e.version < version || e.version > tt.version {
+					t.Fatalf("%s: version %d out of order or ahead of the table version %d", e.name, e.version, tt.version)
+				}
+				version = e.version
+				if tt.table[i] != e.name {
+					t.Fatalf("index %d was assigned to %s in version %d, now it is %s", i, e.name, e.version, tt.table[i])
+				}
+			}
+			if version != tt.version {
+				t.Fatalf("table version %d has no entries recorded, the last recorded version is %d", tt.version, version)
+			}
+		})
+	}
+}
+
+func TestTables_CoverTheCurrentLocations(t *testing.T) {
+	for region, letters := range baseGcpRegionZones {
+		if _, ok := GCPRegionIndex(region); !ok {
+			t.Errorf("gcp region %s has no index", region)
+		}
+		for _, l := range letters {
+			if _, ok := GCPZoneIndex(region + "-" + l); !ok {
+				t.Errorf("gcp zone %s-%s has no index", region, l)
+			}
+		}
+	}
+	for _, region := range allAWSRegions {
+		if _, ok := AWSRegionIndex(region); !ok {
+			t.Errorf("aws region %s has no index", region)
+		}
+	}
+	for region, zones := range allAWSRegionZones {
+		for _, z := range zones {
+			if _, ok := AWSZoneIndex(z); !ok {
+				t.Errorf("aws zone %s of %s has no index", z, region)
+			}
+		}
+	}
+	for region, zones := range baseAzureRegionZones {
+		if _, ok := AzureRegionIndex(region); !ok {
+			t.Errorf("azure region %s has no index", region)
+		}
+		if len(zones) == 0 {
+			zones = []string{""}
+		}
+		for _, z := range zones {
+			if _, ok := AzureZoneIndex(AzureZoneName(region, z)); !ok {
+				t.Errorf("azure zone %s has no index", AzureZoneName(region, z))
+			}
+		}
+	}
+}
+
+func TestExtendTable_OnlyAppends(t *testing.T) {
+	table := []string{"us-central1-a", "europe-west1-b", "retired-zone"}
+	extended, added := ExtendTable(table, []string{"asia-east1-a", "europe-west1-b", "europe-west5-a", "us-central1-a"})
+	if want := []string{"us-central1-a", "europe-west1-b", "retired-zone", "asia-east1-a", "europe-west5-a"}; !slices.Equal(extended, want) {
+		t.Fatalf("want %v, got %v", want, extended)
+	}
+	if want := []string{"asia-east1-a", "europe-west5-a"}; !slices.Equal(added, want) {
+		t.Fatalf("want %v added, got %v", want, added)
+	}
+	if table[0] != "us-central1-a" || len(table) != 3 {
+		t.Fatalf("ExtendTable must not modify its input, got %v", table)
+	}
+
+	if _, added := ExtendTable(extended, extended); len(added) != 0 {
+		t.Fatalf("want nothing added, got %v", added)
+	}
+}
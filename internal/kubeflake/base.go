@@ -1,19 +1,49 @@
 package kubeflake
 
 import (
-	"bytes"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"math/bits"
+	"unicode/utf8"
 )
 
 const (
 	base62Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
 	base64Chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	// base64URLChars is the RFC 4648 URL and filename safe alphabet
+	base64URLChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	// crockford32Chars is the Crockford base32 alphabet, without I, L, O and U
+	crockford32Chars = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	hexChars         = "0123456789abcdef"
+
+	// Widths of the fixed-width encodings, enough digits for any uint64
+	SortableBase62Width = 11
+	Base32Width         = 13
+	HexWidth            = 16
+	// RawBase64Width is the length of 8 bytes encoded in unpadded base64
+	RawBase64Width = 11
 )
 
 var (
-	base62Bytes    = []byte(base62Chars)
-	base64Bytes    = []byte(base64Chars)
-	ErrInvalidBase = errors.New("invalid base")
+	base62Digits    = newDigits(base62Chars)
+	base64Digits    = newDigits(base64Chars)
+	base64URLDigits = newDigits(base64URLChars)
+	base32Digits    = newDigits(crockford32Chars)
+	hexDigits       = newDigits(hexChars)
+
+	// Strict encodings reject keys whose unused trailing bits are set,
+	// so that every ID has exactly one key.
+	rawURLEncoding = base64.RawURLEncoding.Strict()
+	rawStdEncoding = base64.RawStdEncoding.Strict()
+
+	ErrInvalidBase      = errors.New("invalid base")
+	ErrInvalidKeyLength = errors.New("invalid key length")
+	ErrEmptyKey         = errors.New("empty key")
+	ErrKeyOverflow      = errors.New("key overflows uint64")
+	ErrNonCanonicalKey  = errors.New("key has leading zero digits")
+	ErrInvalidAlphabet  = errors.New("invalid alphabet")
 )
 
 type Base62Converter struct{}
@@ -21,31 +51,21 @@ type Base62Converter struct{}
 var _ BaseConverter = (*Base62Converter)(nil)
 
 // EncodeBase62 converts an uint64 to a base62-encoded string.
-func (Base62Converter) Encode(n uint64) string {
-	if n == 0 {
-		return "0"
-	}
-	result := make([]byte, 0)
-	for n > 0 {
-		remainder := n % 62
-		result = append([]byte{base62Chars[remainder]}, result...)
-		n = n / 62
-	}
-	return string(result)
+func (c Base62Converter) Encode(n uint64) string {
+	var buf [maxDigits]byte
+	return string(c.AppendEncode(buf[:0], n))
+}
+
+// AppendEncode appends the base62 encoding of n to dst.
+func (Base62Converter) AppendEncode(dst []byte, n uint64) []byte {
+	return base62Digits.appendVariable(dst, n)
 }
 
-// DecodeBase62 converts a base62-encoded string to an uint64
+// DecodeBase62 converts a base62-encoded string to an uint64.
+// Empty keys, keys with leading zero digits and keys that overflow an
+// uint64 are rejected, so every ID has exactly one key.
 func (Base62Converter) Decode(s string) (uint64, error) {
-	var result uint64
-	for i := 0; i < len(s); i++ {
-		char := s[i]
-		index := bytes.IndexByte(base62Bytes, char)
-		if index == -1 {
-			return 0, ErrInvalidBase
-		}
-		result = result*62 + uint64(index)
-	}
-	return result, nil
+	return base62Digits.decodeCanonical(s)
 }
 
 type Base64Converter struct{}
@@ -53,29 +73,334 @@ type Base64Converter struct{}
 var _ BaseConverter = (*Base64Converter)(nil)
 
 // EncodeBase64 converts an uint64 to a base64-encoded string.
-func (Base64Converter) Encode(n uint64) string {
-	if n == 0 {
-		return "0"
+// Zero is encoded as "A", the zero digit of the alphabet.
+func (c Base64Converter) Encode(n uint64) string {
+	var buf [maxDigits]byte
+	return string(c.AppendEncode(buf[:0], n))
+}
+
+// AppendEncode appends the base64 encoding of n to dst.
+func (Base64Converter) AppendEncode(dst []byte, n uint64) []byte {
+	return base64Digits.appendVariable(dst, n)
+}
+
+// DecodeBase64 converts a base64-encoded string to an uint64.
+// Like the base62 decoder, it only accepts the keys Encode returns.
+func (Base64Converter) Decode(s string) (uint64, error) {
+	return base64Digits.decodeCanonical(s)
+}
+
+// Base64URLConverter encodes IDs as numbers in base64 like Base64Converter,
+// but with the RFC 4648 URL-safe digits "-" and "_" instead of "+" and "/".
+type Base64URLConverter struct{}
+
+var _ BaseConverter = (*Base64URLConverter)(nil)
+
+// Encode converts an uint64 to a URL-safe base64-encoded string.
+func (c Base64URLConverter) Encode(n uint64) string {
+	var buf [maxDigits]byte
+	return string(c.AppendEncode(buf[:0], n))
+}
+
+// AppendEncode appends the URL-safe base64 encoding of n to dst.
+func (Base64URLConverter) AppendEncode(dst []byte, n uint64) []byte {
+	return base64URLDigits.appendVariable(dst, n)
+}
+
+// Decode converts a URL-safe base64-encoded string to an uint64.
+func (Base64URLConverter) Decode(s string) (uint64, error) {
+	return base64URLDigits.decodeCanonical(s)
+}
+
+// RawURLBase64Converter encodes IDs as the 8 byte big-endian representation
+// of the uint64, in unpadded URL-safe base64. The keys are exactly what
+// base64.RawURLEncoding produces, so other languages can decode them with
+// their standard library.
+type RawURLBase64Converter struct{}
+
+var _ BaseConverter = (*RawURLBase64Converter)(nil)
+
+// Encode converts an uint64 to 11 characters of unpadded URL-safe base64.
+func (c RawURLBase64Converter) Encode(n uint64) string {
+	var buf [RawBase64Width]byte
+	return string(c.AppendEncode(buf[:0], n))
+}
+
+// AppendEncode appends the unpadded URL-safe base64 encoding of n to dst.
+func (RawURLBase64Converter) AppendEncode(dst []byte, n uint64) []byte {
+	return appendBytes(rawURLEncoding, dst, n)
+}
+
+// Decode converts 11 characters of unpadded URL-safe base64 to an uint64.
+func (RawURLBase64Converter) Decode(s string) (uint64, error) {
+	return decodeBytes(rawURLEncoding, s)
+}
+
+// RawStdBase64Converter is RawURLBase64Converter with the standard "+/"
+// alphabet, matching base64.RawStdEncoding.
+type RawStdBase64Converter struct{}
+
+var _ BaseConverter = (*RawStdBase64Converter)(nil)
+
+// Encode converts an uint64 to 11 characters of unpadded standard base64.
+func (c RawStdBase64Converter) Encode(n uint64) string {
+	var buf [RawBase64Width]byte
+	return string(c.AppendEncode(buf[:0], n))
+}
+
+// AppendEncode appends the unpadded standard base64 encoding of n to dst.
+func (RawStdBase64Converter) AppendEncode(dst []byte, n uint64) []byte {
+	return appendBytes(rawStdEncoding, dst, n)
+}
+
+// Decode converts 11 characters of unpadded standard base64 to an uint64.
+func (RawStdBase64Converter) Decode(s string) (uint64, error) {
+	return decodeBytes(rawStdEncoding, s)
+}
+
+func appendBytes(enc *base64.Encoding, dst []byte, n uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	return enc.AppendEncode(dst, buf[:])
+}
+
+func decodeBytes(enc *base64.Encoding, s string) (uint64, error) {
+	if len(s) != RawBase64Width {
+		return 0, ErrInvalidKeyLength
 	}
-	result := make([]byte, 0)
-	for n > 0 {
-		remainder := n % 64
-		result = append([]byte{base64Chars[remainder]}, result...)
-		n = n / 64
+	var src [RawBase64Width]byte
+	var buf [8]byte
+	copy(src[:], s)
+	if _, err := enc.Decode(buf[:], src[:]); err != nil {
+		return 0, ErrInvalidBase
 	}
-	return string(result)
+	return binary.BigEndian.Uint64(buf[:]), nil
 }
 
-// DecodeBase64 converts a base64-encoded string to an uint64
-func (Base64Converter) Decode(s string) (uint64, error) {
+// SortableBase62Converter encodes IDs in base62 like Base62Converter, but
+// zero-pads them to 11 digits so that keys sort like the IDs they encode.
+type SortableBase62Converter struct{}
+
+var _ BaseConverter = (*SortableBase62Converter)(nil)
+
+// Encode converts an uint64 to an 11 digit base62-encoded string.
+func (c SortableBase62Converter) Encode(n uint64) string {
+	var buf [SortableBase62Width]byte
+	return string(c.AppendEncode(buf[:0], n))
+}
+
+// AppendEncode appends the 11 digit base62 encoding of n to dst.
+func (SortableBase62Converter) AppendEncode(dst []byte, n uint64) []byte {
+	return base62Digits.appendFixed(dst, SortableBase62Width, n)
+}
+
+// Decode converts an 11 digit base62-encoded string to an uint64.
+func (SortableBase62Converter) Decode(s string) (uint64, error) {
+	return base62Digits.decodeFixed(SortableBase62Width, s)
+}
+
+// Base32Converter encodes IDs as 13 digit Crockford base32 strings,
+// which sort like the IDs they encode.
+type Base32Converter struct{}
+
+var _ BaseConverter = (*Base32Converter)(nil)
+
+// Encode converts an uint64 to a 13 digit Crockford base32 string.
+func (c Base32Converter) Encode(n uint64) string {
+	var buf [Base32Width]byte
+	return string(c.AppendEncode(buf[:0], n))
+}
+
+// AppendEncode appends the 13 digit Crockford base32 encoding of n to dst.
+func (Base32Converter) AppendEncode(dst []byte, n uint64) []byte {
+	return base32Digits.appendFixed(dst, Base32Width, n)
+}
+
+// Decode converts a 13 digit Crockford base32 string to an uint64.
+// Like the other converters, it only accepts the keys Encode returns, so
+// lower case letters and the I, L and O Crockford reads as 1 and 0 fail
+// with ErrInvalidBase; normalize typed keys before decoding them.
+func (Base32Converter) Decode(s string) (uint64, error) {
+	return base32Digits.decodeFixed(Base32Width, s)
+}
+
+// HexConverter encodes IDs as 16 digit lower case hexadecimal strings,
+// which sort like the IDs they encode.
+type HexConverter struct{}
+
+var _ BaseConverter = (*HexConverter)(nil)
+
+// Encode converts an uint64 to a 16 digit hexadecimal string.
+func (c HexConverter) Encode(n uint64) string {
+	var buf [HexWidth]byte
+	return string(c.AppendEncode(buf[:0], n))
+}
+
+// AppendEncode appends the 16 digit hexadecimal encoding of n to dst.
+func (HexConverter) AppendEncode(dst []byte, n uint64) []byte {
+	return hexDigits.appendFixed(dst, HexWidth, n)
+}
+
+// Decode converts a 16 digit hexadecimal string to an uint64.
+func (HexConverter) Decode(s string) (uint64, error) {
+	return hexDigits.decodeFixed(HexWidth, s)
+}
+
+// AlphabetConverter encodes IDs as numbers in the base of a custom alphabet,
+// e.g. one without look-alike characters for keys typed by hand.
+type AlphabetConverter struct {
+	digits *digits
+}
+
+var _ BaseConverter = (*AlphabetConverter)(nil)
+
+// NewAlphabetConverter returns a converter whose digits are the bytes of
+// alphabet, in increasing order of value. The alphabet must have at least
+// 2 distinct ASCII characters and no duplicates.
+func NewAlphabetConverter(alphabet string) (*AlphabetConverter, error) {
+	if len(alphabet) < 2 {
+		return nil, fmt.Errorf("%w: %q has fewer than 2 characters", ErrInvalidAlphabet, alphabet)
+	}
+	seen := map[byte]bool{}
+	for i := 0; i < len(alphabet); i++ {
+		c := alphabet[i]
+		if c >= utf8.RuneSelf {
+			return nil, fmt.Errorf("%w: %q is not ASCII", ErrInvalidAlphabet, alphabet)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("%w: %q repeats %q", ErrInvalidAlphabet, alphabet, c)
+		}
+		seen[c] = true
+	}
+	return &AlphabetConverter{digits: newDigits(alphabet)}, nil
+}
+
+// Alphabet returns the digits of the converter.
+func (c *AlphabetConverter) Alphabet() string {
+	return c.digits.alphabet
+}
+
+// Encode converts an uint64 to a string of digits of the alphabet.
+func (c *AlphabetConverter) Encode(n uint64) string {
+	var buf [maxDigits]byte
+	return string(c.AppendEncode(buf[:0], n))
+}
+
+// AppendEncode appends the encoding of n to dst.
+func (c *AlphabetConverter) AppendEncode(dst []byte, n uint64) []byte {
+	return c.digits.appendVariable(dst, n)
+}
+
+// Decode converts a string of digits of the alphabet to an uint64,
+// rejecting non-canonical keys like the base62 decoder.
+func (c *AlphabetConverter) Decode(s string) (uint64, error) {
+	return c.digits.decodeCanonical(s)
+}
+
+// digitConverter is a converter whose keys are made of the digits of an
+// alphabet, which checksums are computed over.
+type digitConverter interface {
+	BaseConverter
+	digitSet() *digits
+}
+
+func (Base62Converter) digitSet() *digits         { return base62Digits }
+func (Base64Converter) digitSet() *digits         { return base64Digits }
+func (Base64URLConverter) digitSet() *digits      { return base64URLDigits }
+func (RawURLBase64Converter) digitSet() *digits   { return base64URLDigits }
+func (RawStdBase64Converter) digitSet() *digits   { return base64Digits }
+func (SortableBase62Converter) digitSet() *digits { return base62Digits }
+func (Base32Converter) digitSet() *digits         { return base32Digits }
+func (HexConverter) digitSet() *digits            { return hexDigits }
+func (c *AlphabetConverter) digitSet() *digits    { return c.digits }
+
+// maxDigits is the length of the longest key of any alphabet, a uint64 in base 2.
+const maxDigits = 64
+
+// invalidDigit marks the bytes that are not digits in digits.values.
+const invalidDigit = 0xff
+
+// digits converts between digit values and the bytes of an alphabet,
+// decoding through a lookup table instead of searching the alphabet.
+type digits struct {
+	alphabet string
+	base     uint64
+	values   [256]byte
+}
+
+func newDigits(alphabet string) *digits {
+	d := &digits{alphabet: alphabet, base: uint64(len(alphabet))}
+	for i := range d.values {
+		d.values[i] = invalidDigit
+	}
+	for i := 0; i < len(alphabet); i++ {
+		d.values[alphabet[i]] = byte(i)
+	}
+	return d
+}
+
+// appendVariable appends n to dst without leading zero digits.
+func (d *digits) appendVariable(dst []byte, n uint64) []byte {
+	var buf [maxDigits]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = d.alphabet[n%d.base]
+		n /= d.base
+		if n == 0 {
+			break
+		}
+	}
+	return append(dst, buf[i:]...)
+}
+
+// appendFixed appends n to dst, zero-padded to width digits. Since the
+// alphabets of the fixed-width encodings are in ASCII order, so are the keys.
+func (d *digits) appendFixed(dst []byte, width int, n uint64) []byte {
+	dst = append(dst, make([]byte, width)...)
+	out := dst[len(dst)-width:]
+	for i := width - 1; i >= 0; i-- {
+		out[i] = d.alphabet[n%d.base]
+		n /= d.base
+	}
+	return dst
+}
+
+// decodeFixed reads a string of exactly width digits.
+func (d *digits) decodeFixed(width int, s string) (uint64, error) {
+	if len(s) != width {
+		return 0, ErrInvalidKeyLength
+	}
+	return d.decode(s)
+}
+
+// decodeCanonical reads a string of digits as written by the
+// variable-width encoders: not empty and without leading zero digits.
+func (d *digits) decodeCanonical(s string) (uint64, error) {
+	if len(s) == 0 {
+		return 0, ErrEmptyKey
+	}
+	if len(s) > 1 && d.values[s[0]] == 0 {
+		return 0, ErrNonCanonicalKey
+	}
+	return d.decode(s)
+}
+
+// decode reads s as a number, most significant digit first, failing
+// instead of wrapping around if it overflows.
+func (d *digits) decode(s string) (uint64, error) {
 	var result uint64
 	for i := 0; i < len(s); i++ {
-		char := s[i]
-		index := bytes.IndexByte(base64Bytes, char)
-		if index == -1 {
+		value := d.values[s[i]]
+		if value == invalidDigit {
 			return 0, ErrInvalidBase
 		}
-		result = result*64 + uint64(index)
+		hi, lo := bits.Mul64(result, d.base)
+		lo, carry := bits.Add64(lo, uint64(value), 0)
+		if hi != 0 || carry != 0 {
+			return 0, ErrKeyOverflow
+		}
+		result = lo
 	}
 	return result, nil
 }
@@ -84,3 +409,18 @@ type BaseConverter interface {
 	Encode(n uint64) string
 	Decode(s string) (uint64, error)
 }
+
+// AppendEncoder is implemented by the BaseConverters that can append the
+// encoding of n to dst, like Encode without allocating a string.
+type AppendEncoder interface {
+	AppendEncode(dst []byte, n uint64) []byte
+}
+
+// AppendEncode appends the encoding of n by b to dst, without allocating
+// if b is an AppendEncoder.
+func AppendEncode(b BaseConverter, dst []byte, n uint64) []byte {
+	if a, ok := b.(AppendEncoder); ok {
+		return a.AppendEncode(dst, n)
+	}
+	return append(dst, b.Encode(n)...)
+}
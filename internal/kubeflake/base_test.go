@@ -0,0 +1,290 @@
+package kubeflake
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestSortableConverters_PreserveOrder(t *testing.T) {
+	converters := []struct {
+		name  string
+		base  BaseConverter
+		width int
+	}{
+		{"base62", SortableBase62Converter{}, SortableBase62Width},
+		{"base32", Base32Converter{}, Base32Width},
+		{"hex", HexConverter{}, HexWidth},
+	}
+
+	for _, c := range converters {
+		t.Run(c.name, func(t *testing.T) {
+			for _, v := range []uint64{0, 1, 61, 62, 1<<32 - 1, 1<<63 - 1, math.MaxUint64} {
+				key := c.base.Encode(v)
+				if len(key) != c.width {
+					t.Fatalf("Encode(%d) = %q, want %d digits", v, key, c.width)
+				}
+				if got, err := c.base.Decode(key); err != nil || got != v {
+					t.Fatalf("Decode(%q) = %d, %v, want %d", key, got, err, v)
+				}
+			}
+
+			// a < b ⇔ Encode(a) < Encode(b), for small and large values alike
+			sameOrder := func(a, b uint64, shift uint8) bool {
+				a, b = a>>(shift%64), b>>(shift%64)
+				return (a < b) == (c.base.Encode(a) < c.base.Encode(b))
+			}
+			if err := quick.Check(sameOrder, &quick.Config{MaxCount: 5000}); err != nil {
+				t.Fatal(err)
+			}
+			roundTrip := func(v uint64) bool {
+				got, err := c.base.Decode(c.base.Encode(v))
+				return err == nil && got == v
+			}
+			if err := quick.Check(roundTrip, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := c.base.Decode(c.base.Encode(42)[1:]); !errors.Is(err, ErrInvalidKeyLength) {
+				t.Fatalf("expected ErrInvalidKeyLength for a short key, got %v", err)
+			}
+		})
+	}
+}
+
+func TestBase32_DecodeIsCanonical(t *testing.T) {
+	b := Base32Converter{}
+	key := b.Encode(1<<40 + 0x1f)
+	if got, err := b.Decode(key); err != nil || got != 1<<40+0x1f {
+		t.Fatalf("Decode(%q) = %d, %v", key, got, err)
+	}
+	for _, key := range []string{strings.ToLower(key), "OOOOOOOOOOOOL", "000000000000I", "000000000000U"} {
+		if _, err := b.Decode(key); !errors.Is(err, ErrInvalidBase) {
+			t.Fatalf("Decode(%q): expected ErrInvalidBase, got %v", key, err)
+		}
+	}
+}
+
+func TestBase64_Zero(t *testing.T) {
+	for _, b := range []BaseConverter{Base64Converter{}, Base64URLConverter{}} {
+		key := b.Encode(0)
+		if key != "A" {
+			t.Fatalf("%T: want zero encoded as %q, got %q", b, "A", key)
+		}
+		if got, err := b.Decode(key); err != nil || got != 0 {
+			t.Fatalf("%T: Decode(%q) = %d, %v, want 0", b, key, got, err)
+		}
+	}
+}
+
+func TestBase64URL_EncodeDecode(t *testing.T) {
+	b := Base64URLConverter{}
+	std := Base64Converter{}
+	for _, v := range []uint64{1, 62, 63, 64, 4095, 1<<40 + 123, math.MaxUint64} {
+		key := b.Encode(v)
+		if strings.ContainsAny(key, "+/") {
+			t.Fatalf("Encode(%d) = %q is not URL-safe", v, key)
+		}
+		if want := strings.NewReplacer("+", "-", "/", "_").Replace(std.Encode(v)); key != want {
+			t.Fatalf("Encode(%d) = %q, want the digits of Base64Converter %q", v, key, want)
+		}
+		if got, err := b.Decode(key); err != nil || got != v {
+			t.Fatalf("Decode(%q) = %d, %v, want %d", key, got, err, v)
+		}
+	}
+	if _, err := b.Decode("ab+c"); !errors.Is(err, ErrInvalidBase) {
+		t.Fatalf("expected ErrInvalidBase, got %v", err)
+	}
+}
+
+func TestRawBase64_MatchesEncodingBase64(t *testing.T) {
+	converters := []struct {
+		base BaseConverter
+		enc  *base64.Encoding
+	}{
+		{RawURLBase64Converter{}, base64.RawURLEncoding},
+		{RawStdBase64Converter{}, base64.RawStdEncoding},
+	}
+	for _, c := range converters {
+		for _, v := range []uint64{0, 1, 0xfbff, 1<<40 + 123, math.MaxUint64} {
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], v)
+			want := c.enc.EncodeToString(buf[:])
+
+			key := c.base.Encode(v)
+			if key != want {
+				t.Fatalf("%T: Encode(%d) = %q, want %q", c.base, v, key, want)
+			}
+			if got, err := c.base.Decode(want); err != nil || got != v {
+				t.Fatalf("%T: Decode(%q) = %d, %v, want %d", c.base, want, got, err, v)
+			}
+		}
+	}
+
+	b := RawURLBase64Converter{}
+	if _, err := b.Decode("AAAAAAAAAA"); !errors.Is(err, ErrInvalidKeyLength) {
+		t.Fatalf("expected ErrInvalidKeyLength, got %v", err)
+	}
+	if _, err := b.Decode("AAAAAAAAAA+"); !errors.Is(err, ErrInvalidBase) {
+		t.Fatalf("expected ErrInvalidBase for a standard digit, got %v", err)
+	}
+	// The last digit carries 2 unused bits, which must be zero.
+	if _, err := b.Decode("AAAAAAAAAAB"); !errors.Is(err, ErrInvalidBase) {
+		t.Fatalf("expected ErrInvalidBase for non-zero trailing bits, got %v", err)
+	}
+}
+
+func TestDecode_Strict(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    BaseConverter
+		key     string
+		wantErr error
+	}{
+		{"base62 empty", Base62Converter{}, "", ErrEmptyKey},
+		{"base62 leading zero", Base62Converter{}, "0A", ErrNonCanonicalKey},
+		{"base62 overflow", Base62Converter{}, "LygHa16AHYG", ErrKeyOverflow},
+		{"base62 over-long", Base62Converter{}, "zzzzzzzzzzzzzzzzzzzzzz", ErrKeyOverflow},
+		{"base64 empty", Base64Converter{}, "", ErrEmptyKey},
+		{"base64 leading zero", Base64Converter{}, "AB", ErrNonCanonicalKey},
+		{"base64 overflow", Base64Converter{}, "QAAAAAAAAAA", ErrKeyOverflow},
+		{"sortable base62 overflow", SortableBase62Converter{}, "zzzzzzzzzzz", ErrKeyOverflow},
+		{"base32 overflow", Base32Converter{}, "G000000000000", ErrKeyOverflow},
+	}
+	for _, tt := range tests {
+		if _, err := tt.base.Decode(tt.key); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: Decode(%q) expected %v, got %v", tt.name, tt.key, tt.wantErr, err)
+		}
+	}
+
+	// The largest keys still decode.
+	if got, err := (Base62Converter{}).Decode("LygHa16AHYF"); err != nil || got != math.MaxUint64 {
+		t.Fatalf("Decode(max) = %d, %v", got, err)
+	}
+	if got, err := (Base64Converter{}).Decode("P//////////"); err != nil || got != math.MaxUint64 {
+		t.Fatalf("Decode(max) = %d, %v", got, err)
+	}
+}
+
+// fuzzDecode checks that base only decodes the keys it encodes, and that
+// other keys fail with one of the key errors instead of wrapping around.
+func fuzzDecode(f *testing.F, base BaseConverter) {
+	for _, v := range []uint64{0, 1, 61, 62, 63, 64, 1<<40 + 123, math.MaxUint64} {
+		f.Add(base.Encode(v))
+	}
+	f.Add("")
+	f.Add("00")
+	f.Add("zzzzzzzzzzzz")
+	f.Add("abc!def")
+	f.Fuzz(func(t *testing.T, key string) {
+		id, err := base.Decode(key)
+		if err != nil {
+			for _, want := range []error{ErrInvalidBase, ErrEmptyKey, ErrKeyOverflow, ErrNonCanonicalKey} {
+				if errors.Is(err, want) {
+					return
+				}
+			}
+			t.Fatalf("Decode(%q) failed with an unexpected error: %v", key, err)
+		}
+		if got := base.Encode(id); got != key {
+			t.Fatalf("Decode(%q) = %d, which encodes as %q", key, id, got)
+		}
+	})
+}
+
+func FuzzBase62Decode(f *testing.F) {
+	fuzzDecode(f, Base62Converter{})
+}
+
+func FuzzBase64Decode(f *testing.F) {
+	fuzzDecode(f, Base64Converter{})
+}
+
+func TestAppendEncode_MatchesEncode(t *testing.T) {
+	converters := []BaseConverter{
+		Base62Converter{}, Base64Converter{}, Base64URLConverter{},
+		RawURLBase64Converter{}, RawStdBase64Converter{},
+		SortableBase62Converter{}, Base32Converter{}, HexConverter{},
+	}
+	for _, b := range converters {
+		appender, ok := b.(AppendEncoder)
+		if !ok {
+			t.Fatalf("%T does not implement AppendEncoder", b)
+		}
+		prefix := []byte("key:")
+		for _, v := range []uint64{0, 1, 62, 1<<40 + 123, math.MaxUint64} {
+			got := appender.AppendEncode(prefix, v)
+			if want := "key:" + b.Encode(v); string(got) != want {
+				t.Fatalf("%T: AppendEncode(%d) = %q, want %q", b, v, got, want)
+			}
+		}
+	}
+}
+
+func TestNewAlphabetConverter(t *testing.T) {
+	for _, alphabet := range []string{"", "a", "abca", "abcé"} {
+		if _, err := NewAlphabetConverter(alphabet); !errors.Is(err, ErrInvalidAlphabet) {
+			t.Fatalf("NewAlphabetConverter(%q): expected ErrInvalidAlphabet, got %v", alphabet, err)
+		}
+	}
+
+	b, err := NewAlphabetConverter("23456789abcdefghjkmnpqrstuvwxyz")
+	if err != nil {
+		t.Fatalf("NewAlphabetConverter error: %v", err)
+	}
+	if got := b.Encode(0); got != "2" {
+		t.Fatalf("want zero encoded as the first digit, got %q", got)
+	}
+	roundTrip := func(v uint64) bool {
+		key := b.Encode(v)
+		got, err := b.Decode(key)
+		return err == nil && got == v && !strings.ContainsAny(key, "01ilo")
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Decode("2a"); !errors.Is(err, ErrNonCanonicalKey) {
+		t.Fatalf("expected ErrNonCanonicalKey, got %v", err)
+	}
+	if _, err := b.Decode("a1"); !errors.Is(err, ErrInvalidBase) {
+		t.Fatalf("expected ErrInvalidBase, got %v", err)
+	}
+}
+
+func benchmarkConverter(b *testing.B, base BaseConverter) {
+	const id = 1<<62 + 12345
+	key := base.Encode(id)
+	b.Run("Encode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = base.Encode(id)
+		}
+	})
+	b.Run("AppendEncode", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, 64)
+		for i := 0; i < b.N; i++ {
+			buf = AppendEncode(base, buf[:0], id)
+		}
+	})
+	b.Run("Decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := base.Decode(key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkBase62(b *testing.B) {
+	benchmarkConverter(b, Base62Converter{})
+}
+
+func BenchmarkBase64(b *testing.B) {
+	benchmarkConverter(b, Base64Converter{})
+}
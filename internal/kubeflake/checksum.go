@@ -0,0 +1,180 @@
+package kubeflake
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Checksum is an algorithm computing a check character that is appended
+// to keys, so that mistyped keys are rejected before they are looked up.
+type Checksum int
+
+const (
+	// ChecksumNone appends no check character.
+	ChecksumNone Checksum = iota
+	// ChecksumLuhn is the Luhn mod N algorithm, which works with any
+	// alphabet. It catches every single mistyped character and most
+	// transpositions of adjacent characters.
+	ChecksumLuhn
+	// ChecksumDamm is the Damm algorithm, which catches every single
+	// mistyped character and every transposition of adjacent characters
+	// before the check character.
+	// It needs an alphabet of 10 characters, an odd number of characters
+	// or a power of two of them, e.g. hex, Crockford base32 or base64.
+	ChecksumDamm
+)
+
+var (
+	ErrInvalidChecksum     = errors.New("invalid key checksum")
+	ErrUnsupportedChecksum = errors.New("unsupported checksum")
+)
+
+// dammTable10 is the quasigroup of order 10 Damm published with the algorithm.
+var dammTable10 = [10][10]byte{
+	{0, 3, 1, 7, 5, 9, 8, 6, 4, 2},
+	{7, 0, 9, 2, 1, 5, 4, 8, 6, 3},
+	{4, 2, 0, 6, 8, 7, 1, 3, 5, 9},
+	{1, 7, 5, 0, 9, 8, 3, 4, 2, 6},
+	{6, 1, 2, 3, 0, 4, 5, 9, 7, 8},
+	{3, 6, 7, 4, 2, 0, 9, 5, 8, 1},
+	{5, 8, 6, 9, 7, 2, 0, 1, 3, 4},
+	{8, 9, 4, 5, 3, 6, 2, 0, 1, 7},
+	{9, 4, 3, 8, 6, 1, 7, 2, 0, 5},
+	{2, 5, 8, 1, 4, 3, 6, 7, 9, 0},
+}
+
+// gfPolys are irreducible polynomials of GF(2^k), indexed by k.
+var gfPolys = [...]uint{2: 0x7, 3: 0xb, 4: 0x13, 5: 0x25, 6: 0x43, 7: 0x89}
+
+// ChecksumConverter appends a check character to the keys of another
+// converter and verifies it when decoding. The check character is a digit
+// of the same alphabet, so fixed-width keys get one character longer and
+// sortable keys no longer sort like their IDs.
+type ChecksumConverter struct {
+	base     BaseConverter
+	digits   *digits
+	checksum Checksum
+	// dammOp is the quasigroup operation of the Damm algorithm
+	dammOp func(interim, digit uint) uint
+}
+
+var _ BaseConverter = (*ChecksumConverter)(nil)
+
+// NewChecksumConverter wraps base so that its keys carry a check character
+// computed with checksum. It fails with ErrUnsupportedChecksum if base is
+// not one of the converters of this package, or if checksum does not
+// support the size of its alphabet.
+func NewChecksumConverter(base BaseConverter, checksum Checksum) (*ChecksumConverter, error) {
+	dc, ok := base.(digitConverter)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T has no alphabet", ErrUnsupportedChecksum, base)
+	}
+	c := &ChecksumConverter{base: base, digits: dc.digitSet(), checksum: checksum}
+	switch checksum {
+	case ChecksumLuhn:
+	case ChecksumDamm:
+		if c.dammOp = dammOp(uint(c.digits.base)); c.dammOp == nil {
+			return nil, fmt.Errorf("%w: no Damm quasigroup of order %d", ErrUnsupportedChecksum, c.digits.base)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedChecksum, checksum)
+	}
+	return c, nil
+}
+
+// dammOp returns a totally anti-symmetric quasigroup of order n, or nil if
+// none is known. Besides the one of order 10, it uses x∘y = 2x + y in the
+// integers modulo an odd n, or in the field GF(2^k) for n = 2^k.
+func dammOp(n uint) func(x, y uint) uint {
+	switch {
+	case n == 10:
+		return func(x, y uint) uint { return uint(dammTable10[x][y]) }
+	case n%2 == 1 && n >= 3:
+		return func(x, y uint) uint { return (2*x + y) % n }
+	}
+	for k := 2; k < len(gfPolys); k++ {
+		if n == 1<<k {
+			poly := gfPolys[k]
+			return func(x, y uint) uint {
+				x <<= 1
+				if x >= n {
+					x ^= poly
+				}
+				return x ^ y
+			}
+		}
+	}
+	return nil
+}
+
+// Encode converts an uint64 to a key of the wrapped converter, followed
+// by the check character.
+func (c *ChecksumConverter) Encode(n uint64) string {
+	var buf [maxDigits + 1]byte
+	return string(c.AppendEncode(buf[:0], n))
+}
+
+// AppendEncode appends the key of n and its check character to dst.
+func (c *ChecksumConverter) AppendEncode(dst []byte, n uint64) []byte {
+	start := len(dst)
+	dst = AppendEncode(c.base, dst, n)
+	check, _ := checkDigit(c, dst[start:])
+	return append(dst, c.digits.alphabet[check])
+}
+
+// Decode verifies the check character of s and decodes the rest of it
+// with the wrapped converter. A wrong check character fails with
+// ErrInvalidChecksum.
+func (c *ChecksumConverter) Decode(s string) (uint64, error) {
+	if len(s) == 0 {
+		return 0, ErrEmptyKey
+	}
+	key, last := s[:len(s)-1], s[len(s)-1]
+	got := c.digits.values[last]
+	if got == invalidDigit {
+		return 0, ErrInvalidBase
+	}
+	want, err := checkDigit(c, key)
+	if err != nil {
+		return 0, err
+	}
+	if uint(got) != want {
+		return 0, ErrInvalidChecksum
+	}
+	return c.base.Decode(key)
+}
+
+// checkDigit returns the value of the check character for key.
+func checkDigit[K string | []byte](c *ChecksumConverter, key K) (uint, error) {
+	n := uint(c.digits.base)
+	if c.checksum == ChecksumDamm {
+		interim := uint(0)
+		for i := 0; i < len(key); i++ {
+			v := c.digits.values[key[i]]
+			if v == invalidDigit {
+				return 0, ErrInvalidBase
+			}
+			interim = c.dammOp(interim, uint(v))
+		}
+		// The check digit brings the interim digit back to zero.
+		for d := uint(0); d < n; d++ {
+			if c.dammOp(interim, d) == 0 {
+				return d, nil
+			}
+		}
+		return 0, ErrUnsupportedChecksum
+	}
+
+	// Luhn mod N doubles every other digit, starting from the rightmost one.
+	factor, sum := uint(2), uint(0)
+	for i := len(key) - 1; i >= 0; i-- {
+		v := c.digits.values[key[i]]
+		if v == invalidDigit {
+			return 0, ErrInvalidBase
+		}
+		addend := factor * uint(v)
+		sum += addend/n + addend%n
+		factor = 3 - factor
+	}
+	return (n - sum%n) % n, nil
+}
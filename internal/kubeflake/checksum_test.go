@@ -0,0 +1,74 @@
+package kubeflake
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChecksum_CatchesTypos(t *testing.T) {
+	decimal, err := NewAlphabetConverter("0123456789")
+	if err != nil {
+		t.Fatalf("NewAlphabetConverter error: %v", err)
+	}
+	tests := []struct {
+		name     string
+		base     BaseConverter
+		checksum Checksum
+		// transpositions reports whether every adjacent transposition is caught
+		transpositions bool
+	}{
+		{"luhn base62", Base62Converter{}, ChecksumLuhn, false},
+		{"luhn base32", Base32Converter{}, ChecksumLuhn, false},
+		{"damm decimal", decimal, ChecksumDamm, true},
+		{"damm hex", HexConverter{}, ChecksumDamm, true},
+		{"damm base32", Base32Converter{}, ChecksumDamm, true},
+		{"damm base64", Base64URLConverter{}, ChecksumDamm, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := NewChecksumConverter(tt.base, tt.checksum)
+			if err != nil {
+				t.Fatalf("NewChecksumConverter error: %v", err)
+			}
+			// alphabet collects the digits of the base, to type them in
+			alphabet := ""
+			for _, c := range "0123456789ABCDEFGHJKMNPQRSTVWXYZabcdefghijklmnopqrstuvwxyz-_" {
+				if _, err := tt.base.Decode(string(c)); err == nil {
+					alphabet += string(c)
+				}
+			}
+
+			for _, v := range []uint64{0, 7, 1<<40 + 123, 1<<63 + 99} {
+				key := b.Encode(v)
+				if got, err := b.Decode(key); err != nil || got != v {
+					t.Fatalf("Decode(%q) = %d, %v, want %d", key, got, err, v)
+				}
+				for i := 0; i < len(key); i++ {
+					for _, c := range []byte(alphabet) {
+						typo := key[:i] + string(c) + key[i+1:]
+						if typo == key || strings.EqualFold(typo, key) {
+							continue
+						}
+						if got, err := b.Decode(typo); err == nil && got != v {
+							t.Fatalf("typo %q of %q decoded as %d", typo, key, got)
+						}
+					}
+				}
+				for i := 0; tt.transpositions && i+2 < len(key); i++ {
+					swapped := key[:i] + key[i+1:i+2] + key[i:i+1] + key[i+2:]
+					if swapped == key {
+						continue
+					}
+					if _, err := b.Decode(swapped); !errors.Is(err, ErrInvalidChecksum) {
+						t.Fatalf("transposition %q of %q: expected ErrInvalidChecksum, got %v", swapped, key, err)
+					}
+				}
+			}
+		})
+	}
+
+	if _, err := NewChecksumConverter(Base62Converter{}, ChecksumDamm); !errors.Is(err, ErrUnsupportedChecksum) {
+		t.Fatalf("expected ErrUnsupportedChecksum for Damm in base62, got %v", err)
+	}
+}
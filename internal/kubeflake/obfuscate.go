@@ -0,0 +1,157 @@
+package kubeflake
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// feistelRounds is the number of rounds of the Feistel network, one per
+// 64 bit word of a SHA-512 sum.
+const feistelRounds = 8
+
+var (
+	ErrInvalidObfuscationKey = errors.New("invalid obfuscation key")
+	ErrUnknownKeyVersion     = errors.New("unknown obfuscation key version")
+)
+
+// ObfuscationKey is a secret that IDs are permuted with before they are
+// encoded, see ObfuscatingConverter. Version tells keys apart during a
+// rotation and must be less than the size of the alphabet of the keys.
+type ObfuscationKey struct {
+	Version int
+	Secret  []byte
+}
+
+// ObfuscatingConverter permutes IDs with a keyed 64 bit Feistel network
+// before encoding them with another converter, so that keys look random
+// and neither reveal their time, machine and cluster nor can be enumerated.
+// Keys start with a digit holding the version of the obfuscation key, so
+// that keys obfuscated with older versions can still be decoded.
+//
+// The permutation hides IDs from casual inspection, it is not encryption
+// and must not be relied upon to authorize access.
+type ObfuscatingConverter struct {
+	base    BaseConverter
+	digits  *digits
+	current int
+	// versions holds the network of every key, indexed by version
+	versions []*feistel
+}
+
+var _ BaseConverter = (*ObfuscatingConverter)(nil)
+
+// NewObfuscatingConverter wraps base so that IDs are permuted with keys[0]
+// before they are encoded, while keys obfuscated with any of keys can be
+// decoded. It fails with ErrInvalidObfuscationKey if no keys are given, a
+// secret is empty or a version is repeated or does not fit one digit, and
+// with ErrInvalidBase if base is not one of the converters of this package.
+func NewObfuscatingConverter(base BaseConverter, keys ...ObfuscationKey) (*ObfuscatingConverter, error) {
+	dc, ok := base.(digitConverter)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T has no alphabet for the key version", ErrInvalidBase, base)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no keys", ErrInvalidObfuscationKey)
+	}
+	c := &ObfuscatingConverter{
+		base:     base,
+		digits:   dc.digitSet(),
+		versions: make([]*feistel, len(dc.digitSet().alphabet)),
+	}
+	for _, key := range keys {
+		if key.Version < 0 || key.Version >= len(c.versions) {
+			return nil, fmt.Errorf("%w: version %d is not in [0, %d)", ErrInvalidObfuscationKey, key.Version, len(c.versions))
+		}
+		if len(key.Secret) == 0 {
+			return nil, fmt.Errorf("%w: version %d has an empty secret", ErrInvalidObfuscationKey, key.Version)
+		}
+		if c.versions[key.Version] != nil {
+			return nil, fmt.Errorf("%w: version %d is repeated", ErrInvalidObfuscationKey, key.Version)
+		}
+		c.versions[key.Version] = newFeistel(key.Secret)
+	}
+	c.current = keys[0].Version
+	return c, nil
+}
+
+// Encode converts an uint64 to the version digit of the current key,
+// followed by the key of the permuted ID.
+func (c *ObfuscatingConverter) Encode(n uint64) string {
+	var buf [maxDigits + 1]byte
+	return string(c.AppendEncode(buf[:0], n))
+}
+
+// AppendEncode appends the obfuscated key of n to dst.
+func (c *ObfuscatingConverter) AppendEncode(dst []byte, n uint64) []byte {
+	dst = append(dst, c.digits.alphabet[c.current])
+	return AppendEncode(c.base, dst, c.versions[c.current].encrypt(n))
+}
+
+// Decode reads the key version from s, decodes the rest of it with the
+// wrapped converter and undoes the permutation. Versions that are not
+// among the keys fail with ErrUnknownKeyVersion.
+func (c *ObfuscatingConverter) Decode(s string) (uint64, error) {
+	if len(s) == 0 {
+		return 0, ErrEmptyKey
+	}
+	version := c.digits.values[s[0]]
+	if version == invalidDigit {
+		return 0, ErrInvalidBase
+	}
+	f := c.versions[version]
+	if f == nil {
+		return 0, fmt.Errorf("%w: %d", ErrUnknownKeyVersion, version)
+	}
+	n, err := c.base.Decode(s[1:])
+	if err != nil {
+		return 0, err
+	}
+	return f.decrypt(n), nil
+}
+
+func (c *ObfuscatingConverter) digitSet() *digits {
+	return c.digits
+}
+
+// feistel is a balanced Feistel network on 64 bit blocks, holding its
+// round keys.
+type feistel [feistelRounds]uint64
+
+func newFeistel(secret []byte) *feistel {
+	sum := sha512.Sum512(secret)
+	f := new(feistel)
+	for i := range f {
+		f[i] = binary.BigEndian.Uint64(sum[8*i:])
+	}
+	return f
+}
+
+func (f *feistel) encrypt(n uint64) uint64 {
+	left, right := uint32(n>>32), uint32(n)
+	for _, key := range f {
+		left, right = right, left^roundFunc(right, key)
+	}
+	return uint64(left)<<32 | uint64(right)
+}
+
+func (f *feistel) decrypt(n uint64) uint64 {
+	left, right := uint32(n>>32), uint32(n)
+	for i := len(f) - 1; i >= 0; i-- {
+		left, right = right^roundFunc(left, f[i]), left
+	}
+	return uint64(left)<<32 | uint64(right)
+}
+
+// roundFunc mixes half a block with a round key, with the finalizer of
+// SplitMix64.
+func roundFunc(half uint32, key uint64) uint32 {
+	x := uint64(half) ^ key
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return uint32(x >> 32)
+}
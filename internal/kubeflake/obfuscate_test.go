@@ -0,0 +1,24 @@
+package kubeflake
+
+import (
+	"testing"
+	"testing/quick"
+)
+
+func TestObfuscation_IsAPermutation(t *testing.T) {
+	b, err := NewObfuscatingConverter(Base62Converter{}, ObfuscationKey{Secret: []byte("s")})
+	if err != nil {
+		t.Fatalf("NewObfuscatingConverter error: %v", err)
+	}
+	roundTrip := func(v uint64) bool {
+		got, err := b.Decode(b.Encode(v))
+		return err == nil && got == v
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Consecutive ids share no obvious structure.
+	if a, c := b.Encode(1000), b.Encode(1001); a[:len(a)-2] == c[:len(c)-2] {
+		t.Fatalf("consecutive ids have similar keys %q and %q", a, c)
+	}
+}
@@ -1,6 +1,7 @@
 package kubeflake
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -13,6 +14,10 @@ const (
 	DefaultBitsCluster  = 3
 	DefaultBitsMachine  = 13
 	DefaultBitsSequence = 9
+	// DefaultResolveBackoff is the wait before the first cluster / machine ID retry
+	DefaultResolveBackoff = 100 * time.Millisecond
+	// DefaultMaxClockRollback is how far ahead of the clock IDs may be borrowed
+	DefaultMaxClockRollback = time.Second
 	// Bit lengths constraints
 	MinTimeBits     = 30
 	MinSequenceBits = 8
@@ -36,8 +41,50 @@ var (
 	ErrInvalidClusterID     = errors.New("invalid cluster id")
 	ErrStartTimeAhead       = errors.New("start time is ahead")
 	ErrOverTimeLimit        = errors.New("over the time limit")
+	ErrInvalidResolve       = errors.New("invalid id resolution timeout, retries or backoff")
+	ErrInvalidClockRollback = errors.New("invalid clock rollback policy or bound")
+	ErrClockMovedBackwards  = errors.New("clock moved backwards")
+	ErrInvalidOrder         = errors.New("invalid id field order")
 )
 
+// IdPart names a field of an ID.
+type IdPart string
+
+const (
+	PartTimestamp IdPart = "timestamp"
+	PartSequence  IdPart = "sequence"
+	PartMachineID IdPart = "machine_id"
+	PartClusterID IdPart = "cluster_id"
+)
+
+// DefaultOrder is the default order of the ID fields, most significant first.
+var DefaultOrder = []IdPart{PartTimestamp, PartSequence, PartClusterID, PartMachineID}
+
+// ClockRollbackPolicy decides what NextID does when the clock reads earlier
+// than the time of the last generated ID, e.g. after an NTP step.
+type ClockRollbackPolicy int
+
+const (
+	// RollbackBorrow keeps generating IDs on the last timestamp and moves it
+	// ahead of the clock when the sequence runs out, as long as the IDs are
+	// at most MaxClockRollback ahead of the clock.
+	RollbackBorrow ClockRollbackPolicy = iota
+	// RollbackWait blocks until the clock catches up again, if it moved back
+	// by at most MaxClockRollback.
+	RollbackWait
+	// RollbackError fails with ErrClockMovedBackwards.
+	RollbackError
+)
+
+// MachineLease hands out machine IDs that stay reserved only while a lease
+// is held, see kubernetes.LeaseAllocator.
+type MachineLease interface {
+	// Acquire claims a machine ID in [0, n).
+	Acquire(ctx context.Context, n int) (int, error)
+	// Err returns a non-nil error once the claimed ID must no longer be used.
+	Err() error
+}
+
 // Settings configures Kubeflake:
 //
 // BitsSequence is the bit length of a sequence number.
@@ -46,17 +93,41 @@ var (
 //
 // BitsCluster is the bit length of a cluster ID.
 // A BitsCluster of 9 or more (more than 256 clusters) is considered invalid.
+// Without a cluster ID in Order, BitsCluster must be 0.
 // ClusterID returns the unique ID of a cluster.
 // The ClusterID function returns the unique ID of a cluster.
 // ClusterID must return a value between 0 and 2^BitsCluster - 1.
+// If ClusterID is nil, the availability zone of Provider is looked up through Cloud,
+// and ClusterIdStrategy maps it to a cluster ID, cloud.ZoneIndexStrategy if nil.
+// If ClusterIdFile is set instead, the cluster ID is read from that mapping
+// file, see kubernetes.ReadClusterId, which needs the zone only when the
+// CLUSTER_NAME environment variable is unset.
 //
 // BitsMachine is the bit length of a machine ID.
 // A BitsMachine of 17 or more is considered invalid.
 // The MachineID function returns the unique ID of a Kubeflake instance within a cluster.
 // MachineID must return a value between 0 and 2^BitsMachine - 1.
+// If MachineLease is set, it claims the machine ID instead of MachineID,
+// and no IDs are generated once its lease is lost.
+//
+// ResolveTimeout bounds every call to ClusterID and MachineID, 0 means no bound.
+// A failed call is retried up to ResolveRetries times, waiting ResolveBackoff
+// before the first retry and twice as long before every next one.
+//
+// ClockRollback is the policy applied when the clock moves backwards, see
+// ClockRollbackPolicy. Borrowing or waiting for more than MaxClockRollback
+// fails with ErrClockMovedBackwards instead.
+//
+// Order lists the ID fields from the most to the least significant bits.
+// The timestamp must come first and the sequence and machine ID are required,
+// the cluster ID is optional. If Order is nil, DefaultOrder is used.
+//
+// If LockFree is set, IDs are generated with compare-and-swap instead of a mutex.
 //
 // Base is the base encoder used to generate the unique ID from the internal int64.
 // By default Base62 will be used.
+// If ObfuscationKeys are set, IDs are permuted with them before they are encoded.
+// If Checksum is set, keys carry a check character computed with it, see KeyBase.
 //
 // StartTime is the time since which the Kubeflake time is defined as the elapsed time.
 // StartTime must be before the current time.
@@ -64,19 +135,43 @@ var (
 // TimeUnit is the time unit of Kubeflake.
 // TimeUnit must be 1 msec or longer.
 //
-// The bit length of time is calculated by 64 - BitsCluster - BitsMachine - BitsSequence.
-// If it is less than 30, an error is returned (12.4 years with 1 msec time unit).
+// BitsTime is the bit length of time. If BitsTime is 0, the time takes the
+// remaining 64 - BitsCluster - BitsMachine - BitsSequence bits. Otherwise the
+// bits above the time field stay zero, e.g. the sign bit of 63 bit IDs.
+// If it is less than 30, an error is returned (12.4 years with 1 msec time unit),
+// as it is if the fields take more than 64 bits.
 // TODO: Consider allowing fewer bits if timeunit is 10 msec or more.
 type Settings struct {
+	BitsTime     int
 	BitsSequence int
 	BitsCluster  int
 	BitsMachine  int
 
-	TimeUnit  time.Duration
-	Base      BaseConverter
-	EpochTime time.Time
-	ClusterId func() (int, error)
-	MachineId func() (int, error)
+	Order    []IdPart
+	TimeUnit time.Duration
+	Base     BaseConverter
+	Checksum Checksum
+	// ObfuscationKeys are the keys of an ObfuscatingConverter, the first one is current
+	ObfuscationKeys []ObfuscationKey
+	EpochTime       time.Time
+	ClusterId       func(context.Context) (int, error)
+	MachineId       func(context.Context) (int, error)
+
+	MachineLease MachineLease
+
+	Cloud             *cloud.Resolver
+	Provider          cloud.Provider
+	ClusterIdStrategy cloud.ClusterIdStrategy
+	ClusterIdFile     string
+
+	ResolveTimeout time.Duration
+	ResolveRetries int
+	ResolveBackoff time.Duration
+
+	ClockRollback    ClockRollbackPolicy
+	MaxClockRollback time.Duration
+
+	LockFree bool
 }
 
 func (s Settings) Validate() error {
@@ -87,7 +182,14 @@ func (s Settings) Validate() error {
 	if s.BitsMachine < MinMachineBits || s.BitsMachine > MaxMachineBits {
 		return ErrInvalidBitsMachineID
 	}
-	if s.BitsCluster < MinClusterBits || s.BitsCluster > MaxClusterBits {
+	if err := s.validateOrder(); err != nil {
+		return err
+	}
+	if !s.HasPart(PartClusterID) {
+		if s.BitsCluster != 0 {
+			return ErrInvalidBitsClusterID
+		}
+	} else if s.BitsCluster < MinClusterBits || s.BitsCluster > MaxClusterBits {
 		return ErrInvalidBitsClusterID
 	}
 	if s.TimeUnit < 0 || (s.TimeUnit > 0 && s.TimeUnit < time.Millisecond) {
@@ -96,26 +198,205 @@ func (s Settings) Validate() error {
 	if s.EpochTime.After(time.Now()) {
 		return ErrStartTimeAhead
 	}
-	bitsTime := 64 - s.BitsCluster - s.BitsMachine - s.BitsSequence
-	if bitsTime < MinTimeBits {
+	if s.ResolveTimeout < 0 || s.ResolveRetries < 0 || s.ResolveBackoff < 0 {
+		return ErrInvalidResolve
+	}
+	if s.ClockRollback < RollbackBorrow || s.ClockRollback > RollbackError || s.MaxClockRollback < 0 {
+		return ErrInvalidClockRollback
+	}
+	if _, err := s.KeyBase(); err != nil {
+		return err
+	}
+	bitsTime := s.TimeBits()
+	if bitsTime < MinTimeBits || bitsTime+s.BitsCluster+s.BitsMachine+s.BitsSequence > 64 {
 		return ErrInvalidBitsTime
 	}
 	return nil
 }
 
-func detectAZId() (int, error) {
-	return cloud.AvailabilityZoneId(cloud.DetectProvider)
+// TimeBits returns BitsTime, or the bits left by the other fields if it is 0.
+func (s Settings) TimeBits() int {
+	if s.BitsTime == 0 {
+		return 64 - s.BitsCluster - s.BitsMachine - s.BitsSequence
+	}
+	return s.BitsTime
+}
+
+func (s Settings) validateOrder() error {
+	order := s.FieldOrder()
+	if len(order) == 0 || order[0] != PartTimestamp {
+		return ErrInvalidOrder
+	}
+	seen := map[IdPart]bool{}
+	for _, part := range order {
+		switch part {
+		case PartTimestamp, PartSequence, PartMachineID, PartClusterID:
+		default:
+			return ErrInvalidOrder
+		}
+		if seen[part] {
+			return ErrInvalidOrder
+		}
+		seen[part] = true
+	}
+	if !seen[PartSequence] || !seen[PartMachineID] {
+		return ErrInvalidOrder
+	}
+	return nil
+}
+
+// KeyBase returns the converter of the keys: Base, wrapped in an
+// ObfuscatingConverter if ObfuscationKeys are set and then in a
+// ChecksumConverter if Checksum is set, so the check character covers
+// the key version too.
+func (s Settings) KeyBase() (BaseConverter, error) {
+	base := s.Base
+	if len(s.ObfuscationKeys) > 0 {
+		obfuscating, err := NewObfuscatingConverter(base, s.ObfuscationKeys...)
+		if err != nil {
+			return nil, err
+		}
+		base = obfuscating
+	}
+	if s.Checksum == ChecksumNone {
+		return base, nil
+	}
+	return NewChecksumConverter(base, s.Checksum)
+}
+
+// FieldOrder returns Order, or DefaultOrder if Order is nil.
+func (s Settings) FieldOrder() []IdPart {
+	if s.Order == nil {
+		return DefaultOrder
+	}
+	return s.Order
+}
+
+// HasPart reports whether the IDs contain the given field.
+func (s Settings) HasPart(part IdPart) bool {
+	for _, p := range s.FieldOrder() {
+		if p == part {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveClusterId returns the cluster ID from ClusterId or ClusterIdFile,
+// or the one that ClusterIdStrategy gives the availability zone of Provider
+// when both are unset, along with the provider whose zone the ID stands for,
+// DetectProvider if it is not a zone.
+// IDs without a cluster ID field always have cluster ID 0.
+func (s Settings) ResolveClusterId(ctx context.Context) (int, cloud.Provider, error) {
+	if !s.HasPart(PartClusterID) {
+		return 0, cloud.DetectProvider, nil
+	}
+	if s.ClusterId != nil {
+		id, err := s.resolve(ctx, s.ClusterId)
+		return id, cloud.DetectProvider, err
+	}
+	provider := s.Provider
+	if s.ClusterIdFile != "" {
+		id, err := s.resolve(ctx, func(ctx context.Context) (int, error) {
+			return kubernetes.ReadClusterId(s.ClusterIdFile, s.BitsCluster, func() (string, error) {
+				return s.zone(ctx, &provider)
+			})
+		})
+		return id, cloud.DetectProvider, err
+	}
+	var zone string
+	_, err := s.resolve(ctx, func(ctx context.Context) (int, error) {
+		var err error
+		zone, err = s.zone(ctx, &provider)
+		return 0, err
+	})
+	if err != nil {
+		return -1, provider, err
+	}
+	// Mapping the zone is not retried, it would fail the same way again.
+	id, err := s.ZoneStrategy().ClusterId(provider, zone, s.BitsCluster)
+	return id, provider, err
+}
+
+// zone looks up the availability zone of *provider through Cloud, detecting
+// the provider first if it is DetectProvider.
+func (s Settings) zone(ctx context.Context, provider *cloud.Provider) (string, error) {
+	resolver := s.Cloud
+	if resolver == nil {
+		resolver = new(cloud.Resolver)
+	}
+	if *provider == cloud.DetectProvider {
+		detected, err := resolver.DetectProvider(ctx)
+		if err != nil {
+			return "", err
+		}
+		*provider = detected
+	}
+	return resolver.AvailabilityZone(ctx, *provider)
+}
+
+// ZoneStrategy returns ClusterIdStrategy, or cloud.ZoneIndexStrategy if it is nil.
+func (s Settings) ZoneStrategy() cloud.ClusterIdStrategy {
+	if s.ClusterIdStrategy == nil {
+		return cloud.ZoneIndexStrategy()
+	}
+	return s.ClusterIdStrategy
+}
+
+// ResolveMachineId returns the machine ID claimed by MachineLease, or the one from MachineId.
+func (s Settings) ResolveMachineId(ctx context.Context) (int, error) {
+	if s.MachineLease != nil {
+		return s.resolve(ctx, func(ctx context.Context) (int, error) {
+			return s.MachineLease.Acquire(ctx, 1<<s.BitsMachine)
+		})
+	}
+	return s.resolve(ctx, s.MachineId)
+}
+
+// resolve calls fn until it succeeds, the retries are used up or ctx is done.
+func (s Settings) resolve(ctx context.Context, fn func(context.Context) (int, error)) (int, error) {
+	backoff := s.ResolveBackoff
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if s.ResolveTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, s.ResolveTimeout)
+		}
+		id, err := fn(attemptCtx)
+		cancel()
+		if err == nil || attempt >= s.ResolveRetries {
+			return id, err
+		}
+		if ctx.Err() != nil {
+			return 0, errors.Join(ctx.Err(), err)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, errors.Join(ctx.Err(), err)
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func statefulSetPodId(context.Context) (int, error) {
+	return kubernetes.StatefulSetPodId()
 }
 
 func DefaultSettings() Settings {
 	return Settings{
-		BitsSequence: DefaultBitsSequence,
-		BitsCluster:  DefaultBitsCluster,
-		BitsMachine:  DefaultBitsMachine,
-		TimeUnit:     DefaultTimeUnit,
-		Base:         Base62Converter{},
-		EpochTime:    defaultEpochTime,
-		MachineId:    kubernetes.StatefulSetPodId,
-		ClusterId:    detectAZId,
+		BitsSequence:     DefaultBitsSequence,
+		BitsCluster:      DefaultBitsCluster,
+		BitsMachine:      DefaultBitsMachine,
+		TimeUnit:         DefaultTimeUnit,
+		Base:             Base62Converter{},
+		EpochTime:        defaultEpochTime,
+		MachineId:        statefulSetPodId,
+		Provider:         cloud.DetectProvider,
+		ResolveBackoff:   DefaultResolveBackoff,
+		ClockRollback:    RollbackBorrow,
+		MaxClockRollback: DefaultMaxClockRollback,
 	}
 }
@@ -2,13 +2,13 @@ package cloud
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strings"
-	"time"
 
 	internal "github.com/FlorinBalint/kubeflake/internal/cloud"
 )
@@ -20,6 +20,10 @@ const (
 	AWSProvider
 	AzureProvider
 	DetectProvider
+	// AWSZoneProvider is AWSProvider with one cluster ID per availability
+	// zone instead of one per region. Detection never returns it, so it
+	// has to be requested explicitly.
+	AWSZoneProvider
 )
 
 // Additional errors for zone discovery.
@@ -31,7 +35,12 @@ var (
 
 	// Additional errors for AWS region discovery.
 	ErrAWSRegionNotFound      = errors.New("aws region not found")
+	ErrAWSZoneNotFound        = errors.New("aws availability zone not found")
 	ErrAWSMetadataUnavailable = errors.New("aws metadata server unavailable")
+
+	// Additional errors for Azure zone discovery.
+	ErrAzureZoneNotFound        = errors.New("azure zone not found")
+	ErrAzureMetadataUnavailable = errors.New("azure metadata server unavailable")
 )
 
 // gcpZone returns the GCP zone for the current pod's node.
@@ -40,7 +49,7 @@ var (
 //	http://metadata.google.internal/computeMetadata/v1/instance/zone
 //
 // Requires header: Metadata-Flavor: Google
-func gcpZone(ctx context.Context) (string, error) {
+func (r *Resolver) gcpZone(ctx context.Context) (string, error) {
 	// Env overrides (useful in tests or non-GCP environments)
 	if z := strings.TrimSpace(os.Getenv("GCP_ZONE")); z != "" {
 		return z, nil
@@ -49,23 +58,14 @@ func gcpZone(ctx context.Context) (string, error) {
 		return z, nil
 	}
 
-	// Metadata host override per GCE conventions
-	base := "http://metadata.google.internal"
-	if h := strings.TrimSpace(os.Getenv("GCE_METADATA_HOST")); h != "" {
-		if strings.HasPrefix(h, "http://") || strings.HasPrefix(h, "https://") {
-			base = h
-		} else {
-			base = "http://" + h
-		}
-	}
-
-	url := base + "/computeMetadata/v1/instance/zone"
+	url := r.gcpMetadataBase() + "/computeMetadata/v1/instance/zone"
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
+		return "", err
 	}
 	req.Header.Set("Metadata-Flavor", "Google")
 
-	client := &http.Client{Timeout: 2 * time.Second}
+	client := r.client()
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", ErrGCPMetadataUnavailable
@@ -94,15 +94,20 @@ func gcpZone(ctx context.Context) (string, error) {
 	return s, nil
 }
 
-func gcpZoneId(ctx context.Context) (int, error) {
-	zone, err := gcpZone(ctx)
-	if err != nil {
-		return -1, err
+// gcpMetadataBase returns the GCP metadata server URL, honoring
+// Resolver.GCPMetadataURL and then the GCE_METADATA_HOST override per GCE conventions.
+func (r *Resolver) gcpMetadataBase() string {
+	if r.GCPMetadataURL != "" {
+		return r.GCPMetadataURL
 	}
-	if i, ok := internal.GCPZoneIndex(zone); ok {
-		return i, nil
+	h := strings.TrimSpace(os.Getenv("GCE_METADATA_HOST"))
+	if h == "" {
+		return defaultGCPMetadataURL
 	}
-	return -1, ErrGCPZoneNotFound
+	if strings.HasPrefix(h, "http://") || strings.HasPrefix(h, "https://") {
+		return h
+	}
+	return "http://" + h
 }
 
 // awsRegion returns the AWS region for the current EC2 instance.
@@ -111,7 +116,7 @@ func gcpZoneId(ctx context.Context) (int, error) {
 //	http://169.254.169.254/latest/meta-data/placement/region
 //
 // Uses IMDSv2 with token-based authentication for security.
-func awsRegion(ctx context.Context) (string, error) {
+func (r *Resolver) awsRegion(ctx context.Context) (string, error) {
 	// Env overrides (useful in tests or non-AWS environments)
 	if r := strings.TrimSpace(os.Getenv("AWS_REGION")); r != "" {
 		return r, nil
@@ -120,10 +125,42 @@ func awsRegion(ctx context.Context) (string, error) {
 		return r, nil
 	}
 
-	// AWS EC2 Instance Metadata Service v2 (IMDSv2)
-	base := "http://169.254.169.254"
-	tokenURL := base + "/latest/api/token"
-	regionURL := base + "/latest/meta-data/placement/region"
+	region, err := r.awsMetadata(ctx, "placement/region")
+	if err != nil {
+		return "", err
+	}
+	if region == "" {
+		return "", ErrAWSRegionNotFound
+	}
+	return region, nil
+}
+
+// awsZoneID returns the availability zone ID (e.g. "use1-az1") for the current EC2 instance.
+// Zone IDs are used rather than zone names, which AWS maps differently in every account.
+// It checks the env override (AWS_ZONE_ID), then queries the metadata server:
+//
+//	http://169.254.169.254/latest/meta-data/placement/availability-zone-id
+func (r *Resolver) awsZoneID(ctx context.Context) (string, error) {
+	// Env override (useful in tests or non-AWS environments)
+	if z := strings.TrimSpace(os.Getenv("AWS_ZONE_ID")); z != "" {
+		return z, nil
+	}
+
+	zone, err := r.awsMetadata(ctx, "placement/availability-zone-id")
+	if err != nil {
+		return "", err
+	}
+	if zone == "" {
+		return "", ErrAWSZoneNotFound
+	}
+	return zone, nil
+}
+
+// awsMetadata reads a meta-data path from the AWS EC2 Instance Metadata Service,
+// using IMDSv2 with token-based authentication for security.
+func (r *Resolver) awsMetadata(ctx context.Context, path string) (string, error) {
+	tokenURL := r.awsMetadataBase() + "/latest/api/token"
+	metadataURL := r.awsMetadataBase() + "/latest/meta-data/" + path
 
 	// First, get a session token (IMDSv2 requirement)
 	tokenReq, err := http.NewRequestWithContext(ctx, http.MethodPut, tokenURL, nil)
@@ -132,7 +169,7 @@ func awsRegion(ctx context.Context) (string, error) {
 	}
 	tokenReq.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600") // 6 hours
 
-	client := &http.Client{Timeout: 2 * time.Second}
+	client := r.client()
 	tokenResp, err := client.Do(tokenReq)
 	if err != nil {
 		return "", ErrAWSMetadataUnavailable
@@ -148,67 +185,198 @@ func awsRegion(ctx context.Context) (string, error) {
 		return "", ErrAWSMetadataUnavailable
 	}
 
-	// Now get the region using the token
-	regionReq, err := http.NewRequestWithContext(ctx, http.MethodGet, regionURL, nil)
+	// Now get the metadata using the token
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
 	if err != nil {
 		return "", err
 	}
-	regionReq.Header.Set("X-aws-ec2-metadata-token", string(token))
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
 
-	regionResp, err := client.Do(regionReq)
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", ErrAWSMetadataUnavailable
 	}
-	defer regionResp.Body.Close()
+	defer resp.Body.Close()
 
-	if regionResp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK {
 		return "", ErrAWSMetadataUnavailable
 	}
 
-	body, err := io.ReadAll(regionResp.Body)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", ErrAWSMetadataUnavailable
 	}
+	return strings.TrimSpace(string(body)), nil
+}
 
-	region := strings.TrimSpace(string(body))
-	if region == "" {
-		return "", ErrAWSRegionNotFound
+// azureZone returns the Azure zone for the current VM, named like the
+// topology.kubernetes.io/zone label on AKS nodes ("westeurope-1"), or just the
+// region for regions without availability zones.
+// It checks the env override (AZURE_ZONE), then queries the instance metadata service:
+//
+//	http://169.254.169.254/metadata/instance/compute?api-version=2021-02-01
+//
+// Requires header: Metadata: true
+func (r *Resolver) azureZone(ctx context.Context) (string, error) {
+	// Env override (useful in tests or non-Azure environments)
+	if z := strings.TrimSpace(os.Getenv("AZURE_ZONE")); z != "" {
+		return z, nil
 	}
-	return region, nil
-}
 
-func awsRegionId(ctx context.Context) (int, error) {
-	region, err := awsRegion(ctx)
+	url := r.azureMetadataBase() + "/metadata/instance/compute?api-version=2021-02-01&format=json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return -1, err
+		return "", err
 	}
-	if i, ok := internal.AWSRegionIndex(region); ok {
-		return i, nil
+	req.Header.Set("Metadata", "true")
+
+	client := r.client()
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", ErrAzureMetadataUnavailable
 	}
-	return -1, ErrAWSRegionNotFound
-}
+	defer resp.Body.Close()
 
-func detectProvider(ctx context.Context) (Provider, error) {
-	// TODO: implement platform detection
-	return GCPProvider, nil
+	if resp.StatusCode != http.StatusOK {
+		return "", ErrAzureMetadataUnavailable
+	}
+
+	var compute struct {
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&compute); err != nil {
+		return "", ErrAzureMetadataUnavailable
+	}
+	location := strings.ToLower(strings.TrimSpace(compute.Location))
+	if location == "" {
+		return "", ErrAzureZoneNotFound
+	}
+	return internal.AzureZoneName(location, strings.TrimSpace(compute.Zone)), nil
 }
 
 // AvailabilityZoneId returns the availability zone ID for the given provider.
-// For GCP, this returns the zone index. For AWS, this returns the region index.
+// For GCP and Azure, this returns the zone index. For AWS, this returns the region index,
+// or the availability zone index for AWSZoneProvider.
+// It queries the metadata servers with the default Resolver.
 func AvailabilityZoneId(provider Provider) (int, error) {
+	return AvailabilityZoneIdContext(context.Background(), provider)
+}
+
+// AvailabilityZoneIdContext is like AvailabilityZoneId but cancels the
+// metadata queries when ctx is done.
+func AvailabilityZoneIdContext(ctx context.Context, provider Provider) (int, error) {
+	return new(Resolver).AvailabilityZoneId(ctx, provider)
+}
+
+// zoneIndex returns the index of zone, the region for AWSProvider, and
+// whether it exists.
+func zoneIndex(provider Provider, zone string) (int, bool) {
 	switch provider {
 	case GCPProvider:
-		return gcpZoneId(context.Background())
+		return internal.GCPZoneIndex(zone)
 	case AWSProvider:
-		return awsRegionId(context.Background())
-	case DetectProvider:
-		detected, err := detectProvider(context.Background())
-		if err != nil {
-			return -1, err
+		return internal.AWSRegionIndex(zone)
+	case AWSZoneProvider:
+		return internal.AWSZoneIndex(zone)
+	case AzureProvider:
+		return internal.AzureZoneIndex(zone)
+	default:
+		return -1, false
+	}
+}
+
+// regionIndex returns the index of region and whether it exists.
+func regionIndex(provider Provider, region string) (int, bool) {
+	switch provider {
+	case GCPProvider:
+		return internal.GCPRegionIndex(region)
+	case AWSProvider, AWSZoneProvider:
+		return internal.AWSRegionIndex(region)
+	case AzureProvider:
+		return internal.AzureRegionIndex(region)
+	default:
+		return -1, false
+	}
+}
+
+// zoneRegion returns the region of zone, and whether it is known.
+func zoneRegion(provider Provider, zone string) (string, bool) {
+	switch provider {
+	case GCPProvider:
+		// e.g. "europe-west1-b"
+		if i := strings.LastIndexByte(zone, '-'); i > 0 {
+			return zone[:i], true
 		}
-		return AvailabilityZoneId(detected)
+	case AWSProvider:
+		return zone, true
+	case AWSZoneProvider:
+		if i, ok := internal.AWSZoneIndex(zone); ok {
+			_, region, _ := internal.AWSZoneForIndex(i)
+			return region, true
+		}
+	case AzureProvider:
+		// e.g. "westeurope-1", or just the region without zones
+		region, _, _ := strings.Cut(zone, "-")
+		return region, region != ""
+	}
+	return "", false
+}
+
+// zoneNotFound returns the error for a zone that has no index.
+func zoneNotFound(provider Provider) error {
+	switch provider {
+	case GCPProvider:
+		return ErrGCPZoneNotFound
+	case AWSProvider:
+		return ErrAWSRegionNotFound
+	case AWSZoneProvider:
+		return ErrAWSZoneNotFound
+	case AzureProvider:
+		return ErrAzureZoneNotFound
+	default:
+		return fmt.Errorf("function not implemented for provider: %v", provider)
+	}
+}
+
+// ZoneForIndex returns the availability zone with the given index, as
+// AvailabilityZoneId numbers them, and whether it exists: a GCP or Azure zone
+// name, an AWS region for AWSProvider, or an AWS zone ID, e.g. "use1-az1",
+// for AWSZoneProvider.
+func ZoneForIndex(provider Provider, index int) (string, bool) {
+	switch provider {
+	case GCPProvider:
+		return internal.GCPZoneForIndex(index)
+	case AWSProvider:
+		return internal.AWSRegionForIndex(index)
+	case AWSZoneProvider:
+		zoneID, _, ok := internal.AWSZoneForIndex(index)
+		return zoneID, ok
+	case AzureProvider:
+		return internal.AzureZoneForIndex(index)
 	default:
-		// TODO: implement for Azure
-		return -1, fmt.Errorf("function not implemented for provider: %v", provider)
+		return "", false
 	}
 }
+
+// RegionForIndex returns the region with the given region index, and
+// whether it exists.
+func RegionForIndex(provider Provider, index int) (string, bool) {
+	switch provider {
+	case GCPProvider:
+		return internal.GCPRegionForIndex(index)
+	case AWSProvider, AWSZoneProvider:
+		return internal.AWSRegionForIndex(index)
+	case AzureProvider:
+		return internal.AzureRegionForIndex(index)
+	default:
+		return "", false
+	}
+}
+
+// AvailabilityZoneName is the inverse of AvailabilityZoneId: it returns the
+// zone, or for AWSProvider the region, that the provider assigned id to,
+// and whether there is one. It is the same as ZoneForIndex.
+func AvailabilityZoneName(provider Provider, id int) (string, bool) {
+	return ZoneForIndex(provider, id)
+}
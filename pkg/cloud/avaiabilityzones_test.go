@@ -0,0 +1,221 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	internal "github.com/FlorinBalint/kubeflake/internal/cloud"
+)
+
+func azureIMDS(t *testing.T, r *Resolver, body string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" || r.URL.Path != "/metadata/instance/compute" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	r.AzureMetadataURL = srv.URL
+}
+
+func TestAvailabilityZoneId_Azure(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantZone string
+		wantErr  error
+	}{
+		{name: "zonal region", body: `{"location":"westeurope","zone":"2"}`, wantZone: "westeurope-2"},
+		{name: "region without zones", body: `{"location":"westcentralus","zone":""}`, wantZone: "westcentralus"},
+		{name: "unknown region", body: `{"location":"marscentral","zone":"1"}`, wantErr: ErrAzureZoneNotFound},
+		{name: "missing location", body: `{"zone":"1"}`, wantErr: ErrAzureZoneNotFound},
+		{name: "malformed response", body: `not json`, wantErr: ErrAzureMetadataUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := isolateDetection(t)
+			t.Setenv("AZURE_ZONE", "")
+			azureIMDS(t, r, tt.body)
+
+			got, err := r.AvailabilityZoneId(context.Background(), AzureProvider)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want, ok := internal.AzureZoneIndex(tt.wantZone)
+			if !ok {
+				t.Fatalf("zone %q missing from the Azure table", tt.wantZone)
+			}
+			if got != want {
+				t.Fatalf("want index %d, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestAvailabilityZoneId_AzureTopZonesComeFirst(t *testing.T) {
+	r := isolateDetection(t)
+	t.Setenv("AZURE_ZONE", "australiaeast-1")
+
+	got, err := r.AvailabilityZoneId(context.Background(), AzureProvider)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != 0 {
+		t.Fatalf("want the first top zone to take index 0, got %d", got)
+	}
+}
+
+func awsIMDS(t *testing.T, r *Resolver, metadata map[string]string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Path == "/latest/api/token" {
+			w.Write([]byte("token"))
+			return
+		}
+		value, ok := metadata[r.URL.Path]
+		if r.Header.Get("X-aws-ec2-metadata-token") != "token" || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(value))
+	}))
+	t.Cleanup(srv.Close)
+	r.AWSMetadataURL = srv.URL
+}
+
+func TestAvailabilityZoneId_AWSRegionAndZone(t *testing.T) {
+	r := isolateDetection(t)
+	t.Setenv("AWS_ZONE_ID", "")
+	awsIMDS(t, r, map[string]string{
+		"/latest/meta-data/placement/region":               "us-east-1",
+		"/latest/meta-data/placement/availability-zone-id": "use1-az4",
+	})
+
+	region, err := r.AvailabilityZoneId(context.Background(), AWSProvider)
+	if err != nil {
+		t.Fatalf("region mode: unexpected error: %v", err)
+	}
+	if want, _ := internal.AWSRegionIndex("us-east-1"); region != want {
+		t.Fatalf("region mode: want index %d, got %d", want, region)
+	}
+
+	zone, err := r.AvailabilityZoneId(context.Background(), AWSZoneProvider)
+	if err != nil {
+		t.Fatalf("zone mode: unexpected error: %v", err)
+	}
+	if want, _ := internal.AWSZoneIndex("use1-az4"); zone != want {
+		t.Fatalf("zone mode: want index %d, got %d", want, zone)
+	}
+}
+
+func TestAvailabilityZoneId_AWSZonesOfOneRegionDiffer(t *testing.T) {
+	r := isolateDetection(t)
+	seen := map[int]string{}
+	for _, z := range []string{"use1-az1", "use1-az2", "use1-az6"} {
+		t.Setenv("AWS_ZONE_ID", z)
+		got, err := r.AvailabilityZoneId(context.Background(), AWSZoneProvider)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", z, err)
+		}
+		if other, ok := seen[got]; ok {
+			t.Fatalf("%s and %s share index %d", z, other, got)
+		}
+		seen[got] = z
+	}
+}
+
+func TestAvailabilityZoneId_AWSZoneErrors(t *testing.T) {
+	r := isolateDetection(t)
+	t.Setenv("AWS_ZONE_ID", "")
+	awsIMDS(t, r, map[string]string{
+		"/latest/meta-data/placement/availability-zone-id": "xyz9-az1",
+	})
+
+	if _, err := r.AvailabilityZoneId(context.Background(), AWSZoneProvider); !errors.Is(err, ErrAWSZoneNotFound) {
+		t.Fatalf("unknown zone: expected ErrAWSZoneNotFound, got %v", err)
+	}
+
+	awsIMDS(t, r, map[string]string{})
+	if _, err := r.AvailabilityZoneId(context.Background(), AWSZoneProvider); !errors.Is(err, ErrAWSMetadataUnavailable) {
+		t.Fatalf("missing metadata: expected ErrAWSMetadataUnavailable, got %v", err)
+	}
+}
+
+func TestAvailabilityZoneId_AWSTopZonesComeFirst(t *testing.T) {
+	r := isolateDetection(t)
+	t.Setenv("AWS_ZONE_ID", "afs1-az1")
+
+	got, err := r.AvailabilityZoneId(context.Background(), AWSZoneProvider)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != 0 {
+		t.Fatalf("want the first top zone to take index 0, got %d", got)
+	}
+}
+
+func TestAvailabilityZoneName_InvertsTheIndices(t *testing.T) {
+	indices := map[Provider]func(string) (int, bool){
+		GCPProvider:     internal.GCPZoneIndex,
+		AWSProvider:     internal.AWSRegionIndex,
+		AWSZoneProvider: internal.AWSZoneIndex,
+		AzureProvider:   internal.AzureZoneIndex,
+	}
+	for provider, index := range indices {
+		id := 0
+		for ; ; id++ {
+			name, ok := AvailabilityZoneName(provider, id)
+			if !ok {
+				break
+			}
+			if got, ok := index(name); !ok || got != id {
+				t.Fatalf("provider %v: %q has index %d, want %d", provider, name, got, id)
+			}
+		}
+		if id < 8 {
+			t.Fatalf("provider %v: want at least 8 locations, got %d", provider, id)
+		}
+	}
+
+	regions := map[Provider]func(string) (int, bool){
+		GCPProvider:   internal.GCPRegionIndex,
+		AWSProvider:   internal.AWSRegionIndex,
+		AzureProvider: internal.AzureRegionIndex,
+	}
+	for provider, index := range regions {
+		name, ok := RegionForIndex(provider, 0)
+		if got, found := index(name); !ok || !found || got != 0 {
+			t.Fatalf("provider %v: region 0 is %q, which has index %d", provider, name, got)
+		}
+	}
+
+	for _, provider := range []Provider{GCPProvider, DetectProvider} {
+		if name, ok := AvailabilityZoneName(provider, 1<<20); ok {
+			t.Fatalf("provider %v: unexpected location %q", provider, name)
+		}
+	}
+	if zone, ok := ZoneForIndex(GCPProvider, 0); !ok || zone != "africa-south1-a" {
+		t.Fatalf("want africa-south1-a first, got %q", zone)
+	}
+
+	// AWSProvider numbers regions, and only AWSZoneProvider the zone IDs.
+	region, _ := RegionForIndex(AWSProvider, 0)
+	if zone, ok := ZoneForIndex(AWSProvider, 0); !ok || zone != region {
+		t.Fatalf("want region %q for AWSProvider, got %q", region, zone)
+	}
+	if zone, ok := ZoneForIndex(AWSZoneProvider, 0); !ok || zone == region {
+		t.Fatalf("want a zone ID for AWSZoneProvider, got %q", zone)
+	}
+}
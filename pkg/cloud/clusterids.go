@@ -0,0 +1,252 @@
+package cloud
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/bits"
+	"slices"
+)
+
+var (
+	// ErrClusterIdOverflow is returned when the cluster ID of a zone does not
+	// fit the cluster bits of the IDs.
+	ErrClusterIdOverflow = errors.New("cluster id does not fit the cluster bits")
+	// ErrZoneNotMapped is returned when a strategy has no cluster ID for a zone.
+	ErrZoneNotMapped = errors.New("zone has no cluster id")
+	// ErrInvalidClusterId is returned when a zone is mapped to a negative cluster ID.
+	ErrInvalidClusterId = errors.New("invalid cluster id")
+	// ErrClusterIdCollision is returned when a zone gets the cluster ID
+	// another zone is overridden with.
+	ErrClusterIdCollision = errors.New("zones share a cluster id")
+)
+
+// ClusterIdStrategy maps the availability zone of the current node to a
+// cluster ID that fits the cluster bits of the IDs. The zone is named like
+// Resolver.AvailabilityZone names it, e.g. the region for AWSProvider.
+//
+// The zone tables hold far more zones than the default 3 cluster bits can
+// tell apart, so only the first 8 zones of a provider fit their index. The
+// other strategies trade that for a mapping of their own, which every
+// cluster generating IDs of the same kind must share.
+type ClusterIdStrategy interface {
+	// ClusterId returns the cluster ID of zone, below 1<<clusterBits.
+	ClusterId(provider Provider, zone string, clusterBits int) (int, error)
+	// Zone returns the zone, or region, with the given cluster ID, and
+	// whether the strategy can tell which one it is.
+	Zone(provider Provider, id int) (string, bool)
+}
+
+// overflow returns an ErrClusterIdOverflow naming the zone and the cluster
+// bits its ID needs.
+func overflow(zone string, id, clusterBits int) error {
+	return fmt.Errorf("%w: %s has cluster id %d, which needs %d cluster bits, %d are available",
+		ErrClusterIdOverflow, zone, id, bits.Len(uint(id)), clusterBits)
+}
+
+type zoneIndexStrategy struct{}
+
+// ZoneIndexStrategy uses the index of the zone in the zone tables as cluster
+// ID, see AvailabilityZoneId. It is the default strategy and fails with
+// ErrClusterIdOverflow for the zones beyond the first 1<<clusterBits.
+func ZoneIndexStrategy() ClusterIdStrategy {
+	return zoneIndexStrategy{}
+}
+
+func (zoneIndexStrategy) ClusterId(provider Provider, zone string, clusterBits int) (int, error) {
+	id, ok := zoneIndex(provider, zone)
+	if !ok {
+		return -1, fmt.Errorf("%w: %s", zoneNotFound(provider), zone)
+	}
+	if id >= 1<<clusterBits {
+		return -1, overflow(zone, id, clusterBits)
+	}
+	return id, nil
+}
+
+func (zoneIndexStrategy) Zone(provider Provider, id int) (string, bool) {
+	return AvailabilityZoneName(provider, id)
+}
+
+type regionStrategy struct{}
+
+// RegionStrategy uses the index of the region of the zone as cluster ID,
+// which needs fewer bits than the zone index, but gives every zone of a
+// region the same cluster ID: the machine IDs must then be unique across
+// the region rather than the cluster.
+func RegionStrategy() ClusterIdStrategy {
+	return regionStrategy{}
+}
+
+func (regionStrategy) ClusterId(provider Provider, zone string, clusterBits int) (int, error) {
+	region, ok := zoneRegion(provider, zone)
+	if !ok {
+		return -1, fmt.Errorf("%w: %s", zoneNotFound(provider), zone)
+	}
+	id, ok := regionIndex(provider, region)
+	if !ok {
+		return -1, fmt.Errorf("%w: region %s of %s", zoneNotFound(provider), region, zone)
+	}
+	if id >= 1<<clusterBits {
+		return -1, overflow(zone+" (region "+region+")", id, clusterBits)
+	}
+	return id, nil
+}
+
+func (regionStrategy) Zone(provider Provider, id int) (string, bool) {
+	return RegionForIndex(provider, id)
+}
+
+type topZonesStrategy []string
+
+// TopZonesStrategy gives the zones the cluster IDs 0, 1, 2... in the order
+// they are listed, e.g. the zones the clusters run in. Other zones fail
+// with ErrZoneNotMapped.
+func TopZonesStrategy(zones ...string) ClusterIdStrategy {
+	return topZonesStrategy(slices.Clone(zones))
+}
+
+func (s topZonesStrategy) ClusterId(_ Provider, zone string, clusterBits int) (int, error) {
+	id := slices.Index(s, zone)
+	if id < 0 {
+		return -1, fmt.Errorf("%w: %s is not one of the top zones %v", ErrZoneNotMapped, zone, []string(s))
+	}
+	if id >= 1<<clusterBits {
+		return -1, overflow(zone, id, clusterBits)
+	}
+	return id, nil
+}
+
+func (s topZonesStrategy) Zone(_ Provider, id int) (string, bool) {
+	if id < 0 || id >= len(s) {
+		return "", false
+	}
+	return s[id], true
+}
+
+type hashStrategy struct {
+	warn  func(string)
+	peers []string
+}
+
+// HashStrategy hashes the zone into the available cluster IDs, so every
+// zone fits, but two zones may share a cluster ID and then generate the
+// same IDs. warn is called when the zone shares its cluster ID with one of
+// peers, the zones of the other clusters, or without peers, with another
+// zone of its region. If warn is nil, the warning is logged.
+func HashStrategy(warn func(string), peers ...string) ClusterIdStrategy {
+	if warn == nil {
+		warn = func(msg string) { log.Print(msg) }
+	}
+	return hashStrategy{warn: warn, peers: slices.Clone(peers)}
+}
+
+func (s hashStrategy) ClusterId(provider Provider, zone string, clusterBits int) (int, error) {
+	id := hashZone(zone, clusterBits)
+	peers := s.peers
+	if len(peers) == 0 {
+		peers = regionZones(provider, zone)
+	}
+	for _, peer := range peers {
+		if peer != zone && hashZone(peer, clusterBits) == id {
+			s.warn(fmt.Sprintf("cloud: zones %s and %s share the hashed cluster id %d, their IDs may collide", zone, peer, id))
+		}
+	}
+	return id, nil
+}
+
+func (hashStrategy) Zone(Provider, int) (string, bool) {
+	return "", false
+}
+
+func hashZone(zone string, clusterBits int) int {
+	h := fnv.New32a()
+	h.Write([]byte(zone))
+	return int(h.Sum32() % (1 << clusterBits))
+}
+
+// regionZones returns the zones in the tables that share the region of zone.
+func regionZones(provider Provider, zone string) []string {
+	region, ok := zoneRegion(provider, zone)
+	if !ok {
+		return nil
+	}
+	var zones []string
+	for i := 0; ; i++ {
+		name, ok := ZoneForIndex(provider, i)
+		if !ok {
+			return zones
+		}
+		if r, _ := zoneRegion(provider, name); r == region {
+			zones = append(zones, name)
+		}
+	}
+}
+
+type overrideStrategy struct {
+	ids      map[string]int
+	fallback ClusterIdStrategy
+}
+
+// OverrideStrategy takes the cluster ID of a zone from ids, and asks
+// fallback for the other zones. If fallback is nil, they fail with
+// ErrZoneNotMapped. Zones overridden with a negative ID fail with
+// ErrInvalidClusterId, and zones whose fallback ID is the override of
+// another zone fail with ErrClusterIdCollision.
+func OverrideStrategy(ids map[string]int, fallback ClusterIdStrategy) ClusterIdStrategy {
+	s := overrideStrategy{ids: make(map[string]int, len(ids)), fallback: fallback}
+	for zone, id := range ids {
+		s.ids[zone] = id
+	}
+	return s
+}
+
+func (s overrideStrategy) ClusterId(provider Provider, zone string, clusterBits int) (int, error) {
+	id, ok := s.ids[zone]
+	if !ok {
+		if s.fallback == nil {
+			return -1, fmt.Errorf("%w: %s is not overridden", ErrZoneNotMapped, zone)
+		}
+		id, err := s.fallback.ClusterId(provider, zone, clusterBits)
+		if err != nil {
+			return -1, err
+		}
+		for other, overridden := range s.ids {
+			if overridden == id {
+				return -1, fmt.Errorf("%w: %s has the cluster id %d that %s is overridden with", ErrClusterIdCollision, zone, id, other)
+			}
+		}
+		return id, nil
+	}
+	if id < 0 {
+		return -1, fmt.Errorf("%w: %s is overridden with %d", ErrInvalidClusterId, zone, id)
+	}
+	if id >= 1<<clusterBits {
+		return -1, overflow(zone, id, clusterBits)
+	}
+	return id, nil
+}
+
+// Zone returns the zone overridden with id, if only one is, or else the
+// zone of the fallback, unless that zone is overridden with another ID.
+func (s overrideStrategy) Zone(provider Provider, id int) (string, bool) {
+	var zones []string
+	for zone, zoneId := range s.ids {
+		if zoneId == id {
+			zones = append(zones, zone)
+		}
+	}
+	switch {
+	case len(zones) == 1:
+		return zones[0], true
+	case len(zones) == 0 && s.fallback != nil:
+		zone, ok := s.fallback.Zone(provider, id)
+		if _, overridden := s.ids[zone]; !ok || overridden {
+			return "", false
+		}
+		return zone, true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,151 @@
+package cloud
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	internal "github.com/FlorinBalint/kubeflake/internal/cloud"
+)
+
+func TestZoneIndexStrategy_NamesTheZoneAndBits(t *testing.T) {
+	zone, _ := ZoneForIndex(GCPProvider, 8)
+	if _, err := ZoneIndexStrategy().ClusterId(GCPProvider, zone, 3); !errors.Is(err, ErrClusterIdOverflow) ||
+		!strings.Contains(err.Error(), zone) || !strings.Contains(err.Error(), "needs 4 cluster bits, 3 are available") {
+		t.Fatalf("want an overflow naming %s and 4 bits, got %v", zone, err)
+	}
+	if id, err := ZoneIndexStrategy().ClusterId(GCPProvider, zone, 4); err != nil || id != 8 {
+		t.Fatalf("want 8 with 4 bits, got %d, %v", id, err)
+	}
+	if _, err := ZoneIndexStrategy().ClusterId(GCPProvider, "mars-north1-a", 8); !errors.Is(err, ErrGCPZoneNotFound) {
+		t.Fatalf("want ErrGCPZoneNotFound, got %v", err)
+	}
+}
+
+func TestRegionStrategy(t *testing.T) {
+	region, _ := RegionForIndex(GCPProvider, 2)
+	for _, letter := range []string{"a", "b", "c"} {
+		zone := region + "-" + letter
+		if _, ok := internal.GCPZoneIndex(zone); !ok {
+			continue
+		}
+		if id, err := RegionStrategy().ClusterId(GCPProvider, zone, 3); err != nil || id != 2 {
+			t.Fatalf("%s: want the region index 2, got %d, %v", zone, id, err)
+		}
+	}
+	if name, ok := RegionStrategy().Zone(GCPProvider, 2); !ok || name != region {
+		t.Fatalf("want the region %s, got %q", region, name)
+	}
+
+	zoneID, region, _ := internal.AWSZoneForIndex(0)
+	want, _ := internal.AWSRegionIndex(region)
+	if id, err := RegionStrategy().ClusterId(AWSZoneProvider, zoneID, 8); err != nil || id != want {
+		t.Fatalf("%s: want the index %d of %s, got %d, %v", zoneID, want, region, id, err)
+	}
+	if id, err := RegionStrategy().ClusterId(AzureProvider, "westeurope-2", 8); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if want, _ := internal.AzureRegionIndex("westeurope"); id != want {
+		t.Fatalf("want the index %d of westeurope, got %d", want, id)
+	}
+}
+
+func TestTopZonesStrategy(t *testing.T) {
+	st := TopZonesStrategy("us-east1-b", "europe-west4-a", "asia-south1-c")
+	if id, err := st.ClusterId(GCPProvider, "asia-south1-c", 2); err != nil || id != 2 {
+		t.Fatalf("want 2, got %d, %v", id, err)
+	}
+	if _, err := st.ClusterId(GCPProvider, "asia-south1-c", 1); !errors.Is(err, ErrClusterIdOverflow) {
+		t.Fatalf("want ErrClusterIdOverflow, got %v", err)
+	}
+	if _, err := st.ClusterId(GCPProvider, "us-west1-a", 2); !errors.Is(err, ErrZoneNotMapped) {
+		t.Fatalf("want ErrZoneNotMapped, got %v", err)
+	}
+	if zone, ok := st.Zone(GCPProvider, 1); !ok || zone != "europe-west4-a" {
+		t.Fatalf("want europe-west4-a, got %q", zone)
+	}
+	if _, ok := st.Zone(GCPProvider, 3); ok {
+		t.Fatal("want no zone for cluster 3")
+	}
+}
+
+func TestHashStrategy_WarnsAboutCollisions(t *testing.T) {
+	var warnings []string
+	st := HashStrategy(func(msg string) { warnings = append(warnings, msg) })
+
+	// With 2 cluster bits, some zone of a 3 zone region shares its cluster ID.
+	region, _ := RegionForIndex(GCPProvider, 0)
+	var collisions int
+	for _, letter := range []string{"a", "b", "c"} {
+		id, err := st.ClusterId(GCPProvider, region+"-"+letter, 2)
+		if err != nil || id < 0 || id >= 4 {
+			t.Fatalf("want a cluster id below 4, got %d, %v", id, err)
+		}
+		for _, other := range []string{"a", "b", "c"} {
+			if other != letter && hashZone(region+"-"+other, 2) == id {
+				collisions++
+			}
+		}
+	}
+	if len(warnings) != collisions {
+		t.Fatalf("want %d warnings, got %v", collisions, warnings)
+	}
+
+	warnings = nil
+	zone := "us-central1-a"
+	peer := ""
+	for i := 0; peer == ""; i++ {
+		if candidate, _ := ZoneForIndex(GCPProvider, i); candidate != zone && hashZone(candidate, 3) == hashZone(zone, 3) {
+			peer = candidate
+		}
+	}
+	if _, err := HashStrategy(func(msg string) { warnings = append(warnings, msg) }, zone, peer).ClusterId(GCPProvider, zone, 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], peer) {
+		t.Fatalf("want a warning about %s, got %v", peer, warnings)
+	}
+}
+
+func TestOverrideStrategy(t *testing.T) {
+	st := OverrideStrategy(map[string]int{"us-west1-b": 5, "europe-west2-a": 0}, ZoneIndexStrategy())
+	if id, err := st.ClusterId(GCPProvider, "us-west1-b", 3); err != nil || id != 5 {
+		t.Fatalf("want the override 5, got %d, %v", id, err)
+	}
+	zone, _ := ZoneForIndex(GCPProvider, 1)
+	if id, err := st.ClusterId(GCPProvider, zone, 3); err != nil || id != 1 {
+		t.Fatalf("want the fallback index 1, got %d, %v", id, err)
+	}
+	if name, ok := st.Zone(GCPProvider, 5); !ok || name != "us-west1-b" {
+		t.Fatalf("want us-west1-b, got %q", name)
+	}
+	if name, ok := st.Zone(GCPProvider, 1); !ok || name != zone {
+		t.Fatalf("want the fallback zone %s, got %q", zone, name)
+	}
+	if _, err := st.ClusterId(GCPProvider, "us-west1-b", 2); !errors.Is(err, ErrClusterIdOverflow) {
+		t.Fatalf("want ErrClusterIdOverflow, got %v", err)
+	}
+	negative := OverrideStrategy(map[string]int{"us-west1-b": -1}, nil)
+	if _, err := negative.ClusterId(GCPProvider, "us-west1-b", 3); !errors.Is(err, ErrInvalidClusterId) || errors.Is(err, ErrClusterIdOverflow) {
+		t.Fatalf("want ErrInvalidClusterId, got %v", err)
+	}
+
+	// The fallback must not hand out the override of another zone, nor name
+	// an overridden zone.
+	zone2, _ := ZoneForIndex(GCPProvider, 2)
+	zone3, _ := ZoneForIndex(GCPProvider, 3)
+	mixed := OverrideStrategy(map[string]int{"us-west1-b": 2, zone3: 6}, ZoneIndexStrategy())
+	if _, err := mixed.ClusterId(GCPProvider, zone2, 3); !errors.Is(err, ErrClusterIdCollision) || !strings.Contains(err.Error(), "us-west1-b") {
+		t.Fatalf("want ErrClusterIdCollision naming us-west1-b, got %v", err)
+	}
+	if name, ok := mixed.Zone(GCPProvider, 2); !ok || name != "us-west1-b" {
+		t.Fatalf("want us-west1-b for 2, got %q", name)
+	}
+	if name, ok := mixed.Zone(GCPProvider, 3); ok {
+		t.Fatalf("want no zone for 3, %s is overridden with 6, got %q", zone3, name)
+	}
+
+	strict := OverrideStrategy(map[string]int{"us-west1-b": 5}, nil)
+	if _, err := strict.ClusterId(GCPProvider, "us-west1-a", 3); !errors.Is(err, ErrZoneNotMapped) {
+		t.Fatalf("want ErrZoneNotMapped, got %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package cloud
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// dmiRoot is where the kernel exposes the SMBIOS strings. It is a variable
+// so that tests can point it at a fake sysfs tree.
+var dmiRoot = "/sys/class/dmi/id"
+
+// azureAssetTag is the chassis asset tag Hyper-V reports on Azure VMs only.
+const azureAssetTag = "7783-7084-3265-9085-8269-3286-77"
+
+// providerEnvHints lists variables that managed Kubernetes offerings (or
+// their workload identity webhooks) inject into pods.
+var providerEnvHints = map[Provider][]string{
+	GCPProvider:   {"GCP_ZONE"},
+	AWSProvider:   {"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE"},
+	AzureProvider: {"AZURE_FEDERATED_TOKEN_FILE", "AZURE_AUTHORITY_HOST"},
+}
+
+// DetectProvider figures out which cloud the current pod runs on.
+// It checks, in order:
+//   - the CLOUD_PROVIDER override (gcp, aws or azure),
+//   - the DMI product files under /sys/class/dmi/id,
+//   - env hints, if they point at exactly one provider,
+//   - the metadata servers, probed concurrently within DetectTimeout.
+//
+// The hints come after DMI since workloads set them for other clouds too,
+// e.g. GKE pods often set AWS_REGION to talk to S3, while GKE sets no hint.
+func (r *Resolver) DetectProvider(ctx context.Context) (Provider, error) {
+	if p, ok := providerFromOverride(); ok {
+		return p, nil
+	}
+	if p, ok := providerFromDMI(dmiRoot); ok {
+		return p, nil
+	}
+	if p, ok := providerFromHints(); ok {
+		return p, nil
+	}
+	return r.probeMetadataServers(ctx)
+}
+
+// providerFromOverride returns the provider named by CLOUD_PROVIDER.
+func providerFromOverride() (Provider, bool) {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("CLOUD_PROVIDER"))) {
+	case "gcp", "gce", "gke", "google":
+		return GCPProvider, true
+	case "aws", "eks", "amazon":
+		return AWSProvider, true
+	case "azure", "aks":
+		return AzureProvider, true
+	}
+	return DetectProvider, false
+}
+
+// providerFromHints returns the provider the env hints point at, if they
+// are unambiguous.
+func providerFromHints() (Provider, bool) {
+	matches := make([]Provider, 0, 1)
+	for _, p := range []Provider{GCPProvider, AWSProvider, AzureProvider} {
+		for _, key := range providerEnvHints[p] {
+			if strings.TrimSpace(os.Getenv(key)) != "" {
+				matches = append(matches, p)
+				break
+			}
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], true
+	}
+	return DetectProvider, false
+}
+
+// providerFromDMI inspects the SMBIOS strings the kernel exposes under root.
+func providerFromDMI(root string) (Provider, bool) {
+	read := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			return ""
+		}
+		return strings.ToLower(strings.TrimSpace(string(b)))
+	}
+
+	vendor := read("sys_vendor")
+	product := read("product_name")
+	switch {
+	case vendor == "google" || strings.Contains(product, "google compute engine"):
+		return GCPProvider, true
+	case strings.Contains(vendor, "amazon") ||
+		strings.Contains(read("bios_vendor"), "amazon") ||
+		strings.Contains(read("bios_version"), "amazon") ||
+		strings.Contains(read("product_version"), "amazon"):
+		return AWSProvider, true
+	case read("chassis_asset_tag") == azureAssetTag:
+		return AzureProvider, true
+	}
+	return DetectProvider, false
+}
+
+// probeMetadataServers queries every known metadata server concurrently and
+// returns the first provider that answers like its own metadata server.
+func (r *Resolver) probeMetadataServers(ctx context.Context) (Provider, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.detectTimeout())
+	defer cancel()
+
+	probes := map[Provider]func(context.Context, *http.Client) bool{
+		GCPProvider:   r.probeGCP,
+		AWSProvider:   r.probeAWS,
+		AzureProvider: r.probeAzure,
+	}
+	client := r.client()
+	found := make(chan Provider, len(probes))
+	var wg sync.WaitGroup
+	for p, probe := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if probe(ctx, client) {
+				found <- p
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+
+	if p, ok := <-found; ok {
+		return p, nil
+	}
+	return DetectProvider, ErrFailedToDetectProvider
+}
+
+func (r *Resolver) probeGCP(ctx context.Context, client *http.Client) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.gcpMetadataBase()+"/computeMetadata/v1/", nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK && resp.Header.Get("Metadata-Flavor") == "Google"
+}
+
+func (r *Resolver) probeAWS(ctx context.Context, client *http.Client) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, r.awsMetadataBase()+"/latest/api/token", nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func (r *Resolver) probeAzure(ctx context.Context, client *http.Client) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.azureMetadataBase()+"/metadata/instance?api-version=2021-02-01", nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
@@ -0,0 +1,263 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// isolateDetection clears every env hint and returns a Resolver that sees
+// an empty sysfs root and metadata servers that know nothing.
+func isolateDetection(t *testing.T) *Resolver {
+	t.Helper()
+	t.Setenv("CLOUD_PROVIDER", "")
+	t.Setenv("GCE_METADATA_HOST", "")
+	for _, keys := range providerEnvHints {
+		for _, key := range keys {
+			t.Setenv(key, "")
+		}
+	}
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(notFound.Close)
+
+	oldRoot := dmiRoot
+	t.Cleanup(func() { dmiRoot = oldRoot })
+	dmiRoot = t.TempDir()
+
+	return &Resolver{
+		GCPMetadataURL:   notFound.URL,
+		AWSMetadataURL:   notFound.URL,
+		AzureMetadataURL: notFound.URL,
+		DetectTimeout:    time.Second,
+	}
+}
+
+func writeDMI(t *testing.T, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dmiRoot, name), []byte(content+"\n"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+}
+
+func TestDetectProvider_NothingMatches(t *testing.T) {
+	r := isolateDetection(t)
+
+	_, err := r.DetectProvider(context.Background())
+	if !errors.Is(err, ErrFailedToDetectProvider) {
+		t.Fatalf("expected ErrFailedToDetectProvider, got %v", err)
+	}
+}
+
+func TestDetectProvider_Env(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want Provider
+	}{
+		{name: "explicit gcp", env: map[string]string{"CLOUD_PROVIDER": "GKE"}, want: GCPProvider},
+		{name: "explicit aws", env: map[string]string{"CLOUD_PROVIDER": "aws"}, want: AWSProvider},
+		{name: "explicit azure", env: map[string]string{"CLOUD_PROVIDER": "azure"}, want: AzureProvider},
+		{name: "gcp zone hint", env: map[string]string{"GCP_ZONE": "us-central1-a"}, want: GCPProvider},
+		{name: "aws irsa hint", env: map[string]string{"AWS_ROLE_ARN": "arn:aws:iam::1:role/x"}, want: AWSProvider},
+		{name: "azure workload identity hint", env: map[string]string{"AZURE_FEDERATED_TOKEN_FILE": "/var/run/token"}, want: AzureProvider},
+		{
+			name: "explicit wins over hints",
+			env:  map[string]string{"CLOUD_PROVIDER": "azure", "AWS_REGION": "us-east-1"},
+			want: AzureProvider,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := isolateDetection(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			got, err := r.DetectProvider(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("want provider %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDetectProvider_AmbiguousEnvFallsThroughToDMI(t *testing.T) {
+	r := isolateDetection(t)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("GCP_ZONE", "us-central1-a")
+	writeDMI(t, map[string]string{"product_name": "Google Compute Engine", "sys_vendor": "Google"})
+
+	got, err := r.DetectProvider(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != GCPProvider {
+		t.Fatalf("want GCPProvider, got %v", got)
+	}
+}
+
+func TestDetectProvider_DMIBeatsHints(t *testing.T) {
+	// A GKE pod that talks to S3.
+	r := isolateDetection(t)
+	t.Setenv("AWS_REGION", "us-east-1")
+	writeDMI(t, map[string]string{"product_name": "Google Compute Engine", "sys_vendor": "Google"})
+
+	got, err := r.DetectProvider(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != GCPProvider {
+		t.Fatalf("want GCPProvider, got %v", got)
+	}
+}
+
+func TestDetectProvider_DMI(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  Provider
+	}{
+		{
+			name:  "gcp",
+			files: map[string]string{"product_name": "Google Compute Engine", "sys_vendor": "Google"},
+			want:  GCPProvider,
+		},
+		{
+			name:  "aws nitro",
+			files: map[string]string{"product_name": "m5.large", "sys_vendor": "Amazon EC2", "bios_vendor": "Amazon EC2"},
+			want:  AWSProvider,
+		},
+		{
+			name:  "aws xen",
+			files: map[string]string{"product_name": "HVM domU", "sys_vendor": "Xen", "bios_version": "4.11.amazon"},
+			want:  AWSProvider,
+		},
+		{
+			name:  "azure",
+			files: map[string]string{"product_name": "Virtual Machine", "sys_vendor": "Microsoft Corporation", "chassis_asset_tag": azureAssetTag},
+			want:  AzureProvider,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := isolateDetection(t)
+			writeDMI(t, tt.files)
+			got, err := r.DetectProvider(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("want provider %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDetectProvider_HyperVIsNotAzure(t *testing.T) {
+	r := isolateDetection(t)
+	writeDMI(t, map[string]string{"product_name": "Virtual Machine", "sys_vendor": "Microsoft Corporation"})
+
+	_, err := r.DetectProvider(context.Background())
+	if !errors.Is(err, ErrFailedToDetectProvider) {
+		t.Fatalf("expected ErrFailedToDetectProvider, got %v", err)
+	}
+}
+
+func TestDetectProvider_MetadataServers(t *testing.T) {
+	gcp := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Metadata-Flavor", "Google")
+		w.Write([]byte("instance/\n"))
+	})
+	aws := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/latest/api/token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("token"))
+	})
+	azure := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" || r.URL.Path != "/metadata/instance" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"compute":{}}`))
+	})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		want    Provider
+	}{
+		{name: "gcp", handler: gcp, want: GCPProvider},
+		{name: "aws", handler: aws, want: AWSProvider},
+		{name: "azure", handler: azure, want: AzureProvider},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := isolateDetection(t)
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			switch tt.want {
+			case GCPProvider:
+				// Falls back to the GCE convention when no URL is configured.
+				r.GCPMetadataURL = ""
+				t.Setenv("GCE_METADATA_HOST", srv.URL)
+			case AWSProvider:
+				r.AWSMetadataURL = srv.URL
+			case AzureProvider:
+				r.AzureMetadataURL = srv.URL
+			}
+
+			got, err := r.DetectProvider(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("want provider %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDetectProvider_ProbeDeadline(t *testing.T) {
+	r := isolateDetection(t)
+	block := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-block:
+		case <-req.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(block)
+	r.GCPMetadataURL = slow.URL
+	r.AWSMetadataURL = slow.URL
+	r.AzureMetadataURL = slow.URL
+	r.DetectTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, err := r.DetectProvider(context.Background())
+	if !errors.Is(err, ErrFailedToDetectProvider) {
+		t.Fatalf("expected ErrFailedToDetectProvider, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("detection took %v, expected it to honor the probe deadline", elapsed)
+	}
+}
@@ -0,0 +1,111 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultGCPMetadataURL   = "http://metadata.google.internal"
+	defaultAWSMetadataURL   = "http://169.254.169.254"
+	defaultAzureMetadataURL = "http://169.254.169.254"
+	defaultRequestTimeout   = 2 * time.Second
+	defaultDetectTimeout    = 500 * time.Millisecond
+)
+
+// Resolver looks up the availability zone of the current node from the
+// cloud metadata servers. The zero value is ready to use and queries the
+// well-known metadata endpoints.
+type Resolver struct {
+	// Client sends the metadata requests.
+	// If nil, a client with a 2 second timeout is used.
+	Client *http.Client
+
+	// GCPMetadataURL, AWSMetadataURL and AzureMetadataURL override the base
+	// URL of the metadata servers, e.g. to go through a proxy or to point at
+	// a local stand-in. The GCP URL defaults to GCE_METADATA_HOST if set.
+	GCPMetadataURL   string
+	AWSMetadataURL   string
+	AzureMetadataURL string
+
+	// DetectTimeout bounds the metadata probes used to detect the provider.
+	// If 0, 500 msec is used.
+	DetectTimeout time.Duration
+}
+
+// AvailabilityZoneId returns the availability zone ID for the given provider.
+// For GCP and Azure, this returns the zone index. For AWS, this returns the region index,
+// or the availability zone index for AWSZoneProvider.
+// The metadata queries are cancelled when ctx is done.
+func (r *Resolver) AvailabilityZoneId(ctx context.Context, provider Provider) (int, error) {
+	if provider == DetectProvider {
+		detected, err := r.DetectProvider(ctx)
+		if err != nil {
+			return -1, err
+		}
+		provider = detected
+	}
+	zone, err := r.AvailabilityZone(ctx, provider)
+	if err != nil {
+		return -1, err
+	}
+	if i, ok := zoneIndex(provider, zone); ok {
+		return i, nil
+	}
+	return -1, zoneNotFound(provider)
+}
+
+// AvailabilityZone returns the name of the availability zone whose index
+// AvailabilityZoneId returns: the zone for GCP and Azure, the region for AWS,
+// or the availability zone ID for AWSZoneProvider.
+// The metadata queries are cancelled when ctx is done.
+func (r *Resolver) AvailabilityZone(ctx context.Context, provider Provider) (string, error) {
+	switch provider {
+	case GCPProvider:
+		return r.gcpZone(ctx)
+	case AWSProvider:
+		return r.awsRegion(ctx)
+	case AWSZoneProvider:
+		return r.awsZoneID(ctx)
+	case AzureProvider:
+		return r.azureZone(ctx)
+	case DetectProvider:
+		detected, err := r.DetectProvider(ctx)
+		if err != nil {
+			return "", err
+		}
+		return r.AvailabilityZone(ctx, detected)
+	default:
+		return "", fmt.Errorf("function not implemented for provider: %v", provider)
+	}
+}
+
+func (r *Resolver) client() *http.Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	return &http.Client{Timeout: defaultRequestTimeout}
+}
+
+func (r *Resolver) awsMetadataBase() string {
+	if r.AWSMetadataURL != "" {
+		return r.AWSMetadataURL
+	}
+	return defaultAWSMetadataURL
+}
+
+func (r *Resolver) azureMetadataBase() string {
+	if r.AzureMetadataURL != "" {
+		return r.AzureMetadataURL
+	}
+	return defaultAzureMetadataURL
+}
+
+func (r *Resolver) detectTimeout() time.Duration {
+	if r.DetectTimeout > 0 {
+		return r.DetectTimeout
+	}
+	return defaultDetectTimeout
+}
@@ -0,0 +1,191 @@
+package kubernetes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/FlorinBalint/kubeflake/pkg/cloud"
+)
+
+// Errors returned by ClusterIdFromFile and ReadClusterId.
+var (
+	ErrInvalidClusterIds = errors.New("invalid cluster id mapping")
+	ErrClusterNotMapped  = errors.New("cluster not found in the cluster id mapping")
+)
+
+// ClusterIdFromFile returns a cluster ID function for
+// kubeflake.WithClusterIdContextFn that reads the cluster IDs from the mapping
+// file at path, see ReadClusterId, and looks up the availability zone of the
+// detected provider. kubeflake.WithClusterIdFile does the same with the cloud
+// provider, resolver and cluster bits of the generator.
+func ClusterIdFromFile(path string, clusterBits int) func(context.Context) (int, error) {
+	return func(ctx context.Context) (int, error) {
+		return ReadClusterId(path, clusterBits, func() (string, error) {
+			return new(cloud.Resolver).AvailabilityZone(ctx, cloud.DetectProvider)
+		})
+	}
+}
+
+// ReadClusterId reads the cluster ID from the mapping file at path, e.g. a
+// ConfigMap key mounted as a volume, for cluster IDs assigned centrally rather
+// than derived from the availability zone. The file maps cluster names or
+// zones to cluster IDs, as a JSON object or as flat YAML:
+//
+//	# cluster name or zone: cluster id
+//	prod-eu: 0
+//	prod-us: 1
+//	us-east1-b: 2
+//
+// The local entry is the one named by the CLUSTER_NAME environment variable,
+// or if it is unset, the availability zone returned by zone, named like
+// cloud.Resolver.AvailabilityZone names it, e.g. the region on AWS.
+//
+// Every ID of the mapping must be unique and below 1<<clusterBits, so that a
+// broken mapping fails on every cluster, not only on those it breaks. The
+// file is read on every call, so retries see the updates of a ConfigMap.
+func ReadClusterId(path string, clusterBits int, zone func() (string, error)) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return -1, err
+	}
+	ids, err := ParseClusterIds(data, clusterBits)
+	if err != nil {
+		return -1, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if name := strings.TrimSpace(os.Getenv("CLUSTER_NAME")); name != "" {
+		id, ok := ids[name]
+		if !ok {
+			return -1, fmt.Errorf("%w: CLUSTER_NAME %q is not in %s", ErrClusterNotMapped, name, path)
+		}
+		return id, nil
+	}
+	local, err := zone()
+	if err != nil {
+		return -1, fmt.Errorf("CLUSTER_NAME is not set and the availability zone is unknown: %w", err)
+	}
+	id, ok := ids[local]
+	if !ok {
+		return -1, fmt.Errorf("%w: CLUSTER_NAME is not set and the zone %q is not in %s", ErrClusterNotMapped, local, path)
+	}
+	return id, nil
+}
+
+// ParseClusterIds parses a cluster ID mapping, see ReadClusterId, and
+// checks that its IDs are unique and below 1<<clusterBits.
+func ParseClusterIds(data []byte, clusterBits int) (map[string]int, error) {
+	m := clusterIds{ids: map[string]int{}, names: map[int]string{}, clusterBits: clusterBits}
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		err = m.parseJSON(data)
+	} else {
+		err = m.parseYAML(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(m.ids) == 0 {
+		return nil, fmt.Errorf("%w: no cluster ids", ErrInvalidClusterIds)
+	}
+	return m.ids, nil
+}
+
+type clusterIds struct {
+	ids         map[string]int
+	names       map[int]string
+	clusterBits int
+}
+
+// add records that name has the cluster ID id, where names the place of
+// the entry in the file for the errors.
+func (m *clusterIds) add(where, name string, id int) error {
+	if name == "" {
+		return fmt.Errorf("%w: %s: empty cluster name", ErrInvalidClusterIds, where)
+	}
+	if _, ok := m.ids[name]; ok {
+		return fmt.Errorf("%w: %s: %q is listed twice", ErrInvalidClusterIds, where, name)
+	}
+	if id < 0 || id >= 1<<m.clusterBits {
+		return fmt.Errorf("%w: %s: cluster id %d of %q does not fit %d cluster bits", ErrInvalidClusterIds, where, id, name, m.clusterBits)
+	}
+	if prev, ok := m.names[id]; ok {
+		return fmt.Errorf("%w: %s: %q and %q share cluster id %d", ErrInvalidClusterIds, where, prev, name, id)
+	}
+	m.ids[name] = id
+	m.names[id] = name
+	return nil
+}
+
+func (m *clusterIds) parseJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidClusterIds, err)
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidClusterIds, err)
+		}
+		name, _ := token.(string)
+		var id int
+		if err := dec.Decode(&id); err != nil {
+			return fmt.Errorf("%w: %q: %v", ErrInvalidClusterIds, name, err)
+		}
+		if err := m.add(fmt.Sprintf("offset %d", dec.InputOffset()), name, id); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidClusterIds, err)
+	}
+	return nil
+}
+
+// parseYAML parses "name: id" lines, the subset of YAML a flat mapping
+// needs: comments, blank lines, a "---" document start and quoted names.
+func (m *clusterIds) parseYAML(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		where := fmt.Sprintf("line %d", n)
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i == 0 || i > 0 && (line[i-1] == ' ' || line[i-1] == '\t') {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" || line == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return fmt.Errorf("%w: %s: nested values are not supported", ErrInvalidClusterIds, where)
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("%w: %s: want \"name: id\", got %q", ErrInvalidClusterIds, where, line)
+		}
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("%w: %s: nested values are not supported", ErrInvalidClusterIds, where)
+		}
+		id, err := strconv.Atoi(unquote(value))
+		if err != nil {
+			return fmt.Errorf("%w: %s: cluster id %q is not an integer", ErrInvalidClusterIds, where, strings.TrimSpace(value))
+		}
+		if err := m.add(where, unquote(name), id); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
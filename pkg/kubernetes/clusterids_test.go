@@ -0,0 +1,105 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeClusterIds(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clusters")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseClusterIds_JSONAndYAML(t *testing.T) {
+	inputs := map[string]string{
+		"json": `{"prod-eu": 0, "prod-us": 1, "us-east1-b": 7}`,
+		"yaml": `---
+# assigned by the platform team
+prod-eu: 0
+"prod-us": '1'   # second cluster
+
+us-east1-b: 7
+`,
+	}
+	for format, data := range inputs {
+		ids, err := ParseClusterIds([]byte(data), 3)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", format, err)
+		}
+		if len(ids) != 3 || ids["prod-eu"] != 0 || ids["prod-us"] != 1 || ids["us-east1-b"] != 7 {
+			t.Fatalf("%s: unexpected ids %v", format, ids)
+		}
+	}
+}
+
+func TestParseClusterIds_Errors(t *testing.T) {
+	tests := map[string]struct {
+		data string
+		want string
+	}{
+		"empty":         {"# nothing yet\n", "no cluster ids"},
+		"out of range":  {"prod-eu: 8\n", `cluster id 8 of "prod-eu" does not fit 3 cluster bits`},
+		"negative":      {`{"prod-eu": -1}`, "does not fit 3 cluster bits"},
+		"shared id":     {"prod-eu: 2\nprod-us: 2\n", `line 2: "prod-eu" and "prod-us" share cluster id 2`},
+		"listed twice":  {`{"prod-eu": 1, "prod-eu": 2}`, `"prod-eu" is listed twice`},
+		"not a number":  {"prod-eu: zero\n", `line 1: cluster id "zero" is not an integer`},
+		"nested":        {"clusters:\n  prod-eu: 0\n", "line 1: nested values are not supported"},
+		"indented":      {"prod-eu: 0\n  prod-us: 1\n", "line 2: nested values are not supported"},
+		"no colon":      {"prod-eu 0\n", `want "name: id"`},
+		"json string":   {`{"prod-eu": "0"}`, `"prod-eu"`},
+		"json trailing": {`{"prod-eu": 0`, "invalid cluster id mapping"},
+	}
+	for name, tt := range tests {
+		_, err := ParseClusterIds([]byte(tt.data), 3)
+		if !errors.Is(err, ErrInvalidClusterIds) || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%s: want an error with %q, got %v", name, tt.want, err)
+		}
+	}
+}
+
+func TestClusterIdFromFile_SelectsTheLocalEntry(t *testing.T) {
+	path := writeClusterIds(t, "prod-eu: 0\nprod-us: 1\nus-east1-b: 5\n")
+	ctx := context.Background()
+
+	t.Setenv("CLUSTER_NAME", "prod-us")
+	if id, err := ClusterIdFromFile(path, 3)(ctx); err != nil || id != 1 {
+		t.Fatalf("want the id 1 of prod-us, got %d, %v", id, err)
+	}
+	t.Setenv("CLUSTER_NAME", "prod-asia")
+	if _, err := ClusterIdFromFile(path, 3)(ctx); !errors.Is(err, ErrClusterNotMapped) || !strings.Contains(err.Error(), "prod-asia") {
+		t.Fatalf("want ErrClusterNotMapped naming prod-asia, got %v", err)
+	}
+
+	t.Setenv("CLUSTER_NAME", "")
+	t.Setenv("CLOUD_PROVIDER", "gcp")
+	t.Setenv("GCP_ZONE", "us-east1-b")
+	if id, err := ClusterIdFromFile(path, 3)(ctx); err != nil || id != 5 {
+		t.Fatalf("want the id 5 of the zone, got %d, %v", id, err)
+	}
+	t.Setenv("GCP_ZONE", "us-east1-c")
+	if _, err := ClusterIdFromFile(path, 3)(ctx); !errors.Is(err, ErrClusterNotMapped) || !strings.Contains(err.Error(), "us-east1-c") {
+		t.Fatalf("want ErrClusterNotMapped naming the zone, got %v", err)
+	}
+
+	// The mapping is checked as a whole, and read again on every call.
+	if _, err := ClusterIdFromFile(path, 2)(ctx); !errors.Is(err, ErrInvalidClusterIds) {
+		t.Fatalf("want ErrInvalidClusterIds for 2 cluster bits, got %v", err)
+	}
+	if err := os.WriteFile(path, []byte(`{"us-east1-c": 3}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := ClusterIdFromFile(path, 2)(ctx); err != nil || id != 3 {
+		t.Fatalf("want the id 3 of the updated mapping, got %d, %v", id, err)
+	}
+	if _, err := ClusterIdFromFile(filepath.Join(t.TempDir(), "missing"), 3)(ctx); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want os.ErrNotExist, got %v", err)
+	}
+}
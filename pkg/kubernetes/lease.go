@@ -0,0 +1,600 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Errors returned by LeaseAllocator.
+var (
+	ErrNotInCluster     = errors.New("not running inside a kubernetes cluster")
+	ErrNoFreeMachineId  = errors.New("no free machine id lease")
+	ErrLeaseLost        = errors.New("machine id lease lost")
+	ErrLeaseNotAcquired = errors.New("machine id lease not acquired")
+)
+
+const (
+	serviceAccountDir    = "/var/run/secrets/kubernetes.io/serviceaccount"
+	defaultLeasePrefix   = "kubeflake"
+	defaultLeaseDuration = 15 * time.Second
+	leaseGroupLabel      = "kubeflake.io/group"
+	leaseMachineLabel    = "kubeflake.io/machine-id"
+	// microTimeFormat is the wire format of metav1.MicroTime.
+	microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// LeaseAllocator claims a machine ID for pods without a stable ordinal
+// (Deployments, Jobs, Knative services) by holding the coordination.k8s.io/v1
+// Lease named "<Prefix>-<id>" for the first free id, and renewing it in the
+// background. It talks to the API server's REST interface directly, and needs
+// RBAC permission to get, list, create, update and delete leases in Namespace.
+//
+// Once a renewal fails for longer than the lease allows, Err reports
+// ErrLeaseLost and the ID must no longer be used, since another pod may
+// claim it as soon as the Lease expires.
+type LeaseAllocator struct {
+	// APIServer is the base URL of the Kubernetes API server.
+	APIServer string
+	// Token is the bearer token sent to the API server. If empty, the token
+	// is read from TokenFile on every request, so rotated tokens are picked up.
+	Token     string
+	TokenFile string
+	// Client sends the API requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Namespace holds the Leases.
+	Namespace string
+	// Holder is written as holderIdentity and must be unique to the
+	// allocator. If empty, the pod name with a random suffix is used, so
+	// that several allocators in one pod claim different IDs.
+	Holder string
+	// Prefix names the Leases and groups the allocators sharing the ID space.
+	// If empty, "kubeflake" is used.
+	Prefix string
+	// LeaseDuration is how long a Lease stays valid without renewal.
+	// If 0, 15 seconds is used. The Lease is renewed every third of it.
+	LeaseDuration time.Duration
+
+	mu              sync.Mutex
+	id              int
+	held            bool
+	claimed         bool
+	observed        map[string]observation
+	uid             string
+	resourceVersion string
+	acquireTime     string
+	transitions     int
+	stop            chan struct{}
+	done            chan struct{}
+
+	lost       atomic.Bool
+	validUntil atomic.Int64
+	nowFunc    func() time.Time
+}
+
+// NewInClusterLeaseAllocator returns a LeaseAllocator configured from the
+// pod's service account, for Leases in the pod's own namespace.
+func NewInClusterLeaseAllocator() (*LeaseAllocator, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrNotInCluster
+	}
+	namespace, err := os.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotInCluster, err)
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotInCluster, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("%w: invalid service account CA", ErrNotInCluster)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &LeaseAllocator{
+		APIServer: "https://" + net.JoinHostPort(host, port),
+		TokenFile: serviceAccountDir + "/token",
+		Client:    &http.Client{Transport: transport, Timeout: 10 * time.Second},
+		Namespace: strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+// observation is the version of a Lease of another holder, and when this
+// allocator first saw it.
+type observation struct {
+	resourceVersion string
+	since           time.Time
+	expiry          time.Time
+}
+
+// Acquire claims the first free machine ID in [0, n) and starts renewing its
+// Lease in the background. Calling Acquire again returns the ID already held.
+//
+// The Leases of other holders are free once they are released, or once they
+// were not written for their whole duration, as timed on the local clock so
+// that clock skew between nodes cannot expire a live Lease. If no ID is free
+// otherwise, Acquire waits up to a lease duration for a Lease to expire.
+func (a *LeaseAllocator) Acquire(ctx context.Context, n int) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.held {
+		if a.id >= n {
+			return 0, fmt.Errorf("held machine id %d does not fit in %d ids", a.id, n)
+		}
+		return a.id, nil
+	}
+	if a.Holder == "" {
+		pod, err := PodName()
+		if err != nil {
+			return 0, ErrPodNameNotFound
+		}
+		var suffix [4]byte
+		rand.Read(suffix[:])
+		a.Holder = pod + "-" + hex.EncodeToString(suffix[:])
+	}
+
+	var start time.Time
+	for {
+		existing, err := a.listLeases(ctx)
+		if err != nil {
+			return 0, err
+		}
+		now := a.now()
+		if start.IsZero() {
+			start = now
+		}
+		wait := time.Duration(-1)
+		for id := 0; id < n; id++ {
+			lease, ok := existing[id]
+			if ok && !a.free(id, lease, now) {
+				// Only the Leases left alone since Acquire started may still expire.
+				if obs := a.observed[lease.Metadata.Name]; !obs.since.After(start) {
+					if d := obs.expiry.Sub(now); wait < 0 || d < wait {
+						wait = d
+					}
+				}
+				continue
+			}
+			var claimed bool
+			if ok {
+				claimed, err = a.takeOver(ctx, id, lease)
+			} else {
+				claimed, err = a.create(ctx, id)
+			}
+			if err != nil {
+				return 0, err
+			}
+			if claimed {
+				a.id = id
+				a.held = true
+				a.claimed = true
+				a.lost.Store(false)
+				a.stop = make(chan struct{})
+				a.done = make(chan struct{})
+				go a.renewLoop(a.stop, a.done)
+				return id, nil
+			}
+		}
+		if wait < 0 {
+			return 0, ErrNoFreeMachineId
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, errors.Join(ErrNoFreeMachineId, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// free reports whether the Lease for id may be claimed: it was released,
+// it is the one this allocator held last, or it was not written for its
+// whole duration since this allocator first saw it.
+func (a *LeaseAllocator) free(id int, l *lease, now time.Time) bool {
+	if l.Spec.HolderIdentity == "" {
+		return true
+	}
+	if l.Spec.HolderIdentity == a.Holder && a.claimed && id == a.id {
+		return true
+	}
+	if a.observed == nil {
+		a.observed = map[string]observation{}
+	}
+	obs, ok := a.observed[l.Metadata.Name]
+	if !ok || obs.resourceVersion != l.Metadata.ResourceVersion {
+		obs = observation{
+			resourceVersion: l.Metadata.ResourceVersion,
+			since:           now,
+			expiry:          now.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second),
+		}
+		a.observed[l.Metadata.Name] = obs
+	}
+	return !now.Before(obs.expiry)
+}
+
+// Err returns ErrLeaseLost once the Lease could not be renewed in time,
+// ErrLeaseNotAcquired before Acquire succeeds, and nil otherwise.
+func (a *LeaseAllocator) Err() error {
+	if a.lost.Load() {
+		return ErrLeaseLost
+	}
+	validUntil := a.validUntil.Load()
+	if validUntil == 0 {
+		return ErrLeaseNotAcquired
+	}
+	if a.now().UnixNano() >= validUntil {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Release stops renewing the Lease and deletes it, so the machine ID is
+// free for other pods right away. A lost Lease is left alone, and the delete
+// only goes through if nobody wrote the Lease since our last renewal.
+func (a *LeaseAllocator) Release(ctx context.Context) error {
+	a.mu.Lock()
+	if !a.held {
+		a.mu.Unlock()
+		return nil
+	}
+	close(a.stop)
+	done := a.done
+	a.mu.Unlock()
+	<-done
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	lost := errors.Is(a.Err(), ErrLeaseLost)
+	a.held = false
+	a.validUntil.Store(0)
+	if lost {
+		// Another pod may hold the Lease by now.
+		return nil
+	}
+
+	var opts struct {
+		Kind          string `json:"kind"`
+		APIVersion    string `json:"apiVersion"`
+		Preconditions struct {
+			UID             string `json:"uid,omitempty"`
+			ResourceVersion string `json:"resourceVersion,omitempty"`
+		} `json:"preconditions"`
+	}
+	opts.Kind, opts.APIVersion = "DeleteOptions", "v1"
+	opts.Preconditions.UID = a.uid
+	opts.Preconditions.ResourceVersion = a.resourceVersion
+	body, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	resp, err := a.do(ctx, http.MethodDelete, a.leaseURL(a.leaseName(a.id)), body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNotFound, http.StatusConflict:
+		// A conflict means the Lease changed hands since, it is no longer ours to delete.
+		return nil
+	default:
+		return apiError(resp)
+	}
+}
+
+// renewLoop renews the Lease every third of its duration until stop is
+// closed or the Lease is lost.
+func (a *LeaseAllocator) renewLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(a.leaseDuration() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		a.mu.Lock()
+		err := a.renew()
+		a.mu.Unlock()
+		if errors.Is(err, ErrLeaseLost) {
+			a.lost.Store(true)
+			return
+		}
+		// Other errors are retried on the next tick, Err reports the
+		// Lease lost if none of them succeeds in time.
+	}
+}
+
+// renew bumps renewTime, must be called with mu held.
+func (a *LeaseAllocator) renew() error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.leaseDuration()/3)
+	defer cancel()
+
+	start := a.now()
+	lease := a.lease(a.id, start)
+	lease.Metadata.ResourceVersion = a.resourceVersion
+	updated, status, err := a.write(ctx, http.MethodPut, a.leaseURL(lease.Metadata.Name), lease)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case http.StatusOK:
+		a.uid = updated.Metadata.UID
+		a.resourceVersion = updated.Metadata.ResourceVersion
+		a.markRenewed(start)
+		return nil
+	case http.StatusConflict, http.StatusNotFound:
+		// Someone else wrote the Lease, it is only still ours if nobody took it over.
+		current, err := a.getLease(ctx, lease.Metadata.Name)
+		if err != nil {
+			return err
+		}
+		if current == nil || current.Spec.HolderIdentity != a.Holder {
+			return ErrLeaseLost
+		}
+		a.uid = current.Metadata.UID
+		a.resourceVersion = current.Metadata.ResourceVersion
+		return fmt.Errorf("lease %s changed concurrently", lease.Metadata.Name)
+	default:
+		return fmt.Errorf("renewing lease %s: unexpected status %d", lease.Metadata.Name, status)
+	}
+}
+
+func (a *LeaseAllocator) create(ctx context.Context, id int) (bool, error) {
+	start := a.now()
+	a.acquireTime = start.UTC().Format(microTimeFormat)
+	a.transitions = 0
+	lease := a.lease(id, start)
+	created, status, err := a.write(ctx, http.MethodPost, a.leasesURL(), lease)
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case http.StatusCreated, http.StatusOK:
+		a.uid = created.Metadata.UID
+		a.resourceVersion = created.Metadata.ResourceVersion
+		a.markRenewed(start)
+		return true, nil
+	case http.StatusConflict:
+		return false, nil
+	default:
+		return false, fmt.Errorf("creating lease %s: unexpected status %d", lease.Metadata.Name, status)
+	}
+}
+
+func (a *LeaseAllocator) takeOver(ctx context.Context, id int, current *lease) (bool, error) {
+	start := a.now()
+	a.acquireTime = start.UTC().Format(microTimeFormat)
+	a.transitions = current.Spec.LeaseTransitions
+	if current.Spec.HolderIdentity != a.Holder {
+		a.transitions++
+	}
+	lease := a.lease(id, start)
+	lease.Metadata.ResourceVersion = current.Metadata.ResourceVersion
+	updated, status, err := a.write(ctx, http.MethodPut, a.leaseURL(lease.Metadata.Name), lease)
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case http.StatusOK:
+		a.uid = updated.Metadata.UID
+		a.resourceVersion = updated.Metadata.ResourceVersion
+		a.markRenewed(start)
+		return true, nil
+	case http.StatusConflict, http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("taking over lease %s: unexpected status %d", lease.Metadata.Name, status)
+	}
+}
+
+// markRenewed records a successful write started at start. The ID stays
+// usable until a renewal period before the Lease would expire for others.
+func (a *LeaseAllocator) markRenewed(start time.Time) {
+	d := a.leaseDuration()
+	a.validUntil.Store(start.Add(d - d/3).UnixNano())
+}
+
+func (a *LeaseAllocator) listLeases(ctx context.Context) (map[int]*lease, error) {
+	u := a.leasesURL() + "?labelSelector=" + url.QueryEscape(leaseGroupLabel+"="+a.prefix())
+	resp, err := a.do(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+	var list struct {
+		Items []*lease `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	leases := make(map[int]*lease, len(list.Items))
+	for _, l := range list.Items {
+		id, err := strconv.Atoi(l.Metadata.Labels[leaseMachineLabel])
+		if err != nil || l.Metadata.Name != a.leaseName(id) {
+			continue
+		}
+		leases[id] = l
+	}
+	return leases, nil
+}
+
+func (a *LeaseAllocator) getLease(ctx context.Context, name string) (*lease, error) {
+	resp, err := a.do(ctx, http.MethodGet, a.leaseURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+	l := new(lease)
+	if err := json.NewDecoder(resp.Body).Decode(l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// write sends l and decodes the Lease in the response of a successful write.
+func (a *LeaseAllocator) write(ctx context.Context, method, u string, l *lease) (*lease, int, error) {
+	body, err := json.Marshal(l)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := a.do(ctx, method, u, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, resp.StatusCode, nil
+	}
+	written := new(lease)
+	if err := json.NewDecoder(resp.Body).Decode(written); err != nil {
+		return nil, 0, err
+	}
+	return written, resp.StatusCode, nil
+}
+
+func (a *LeaseAllocator) do(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	token := a.Token
+	if token == "" && a.TokenFile != "" {
+		b, err := os.ReadFile(a.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+func (a *LeaseAllocator) lease(id int, renewTime time.Time) *lease {
+	l := &lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+	l.Metadata.Name = a.leaseName(id)
+	l.Metadata.Namespace = a.Namespace
+	l.Metadata.Labels = map[string]string{
+		leaseGroupLabel:   a.prefix(),
+		leaseMachineLabel: strconv.Itoa(id),
+	}
+	l.Spec.HolderIdentity = a.Holder
+	// Round up, a Lease must not look expired to others before we stop using it.
+	l.Spec.LeaseDurationSeconds = int((a.leaseDuration() + time.Second - 1) / time.Second)
+	l.Spec.AcquireTime = a.acquireTime
+	l.Spec.RenewTime = renewTime.UTC().Format(microTimeFormat)
+	l.Spec.LeaseTransitions = a.transitions
+	return l
+}
+
+func (a *LeaseAllocator) leasesURL() string {
+	return strings.TrimSuffix(a.APIServer, "/") + "/apis/coordination.k8s.io/v1/namespaces/" + url.PathEscape(a.Namespace) + "/leases"
+}
+
+func (a *LeaseAllocator) leaseURL(name string) string {
+	return a.leasesURL() + "/" + url.PathEscape(name)
+}
+
+func (a *LeaseAllocator) leaseName(id int) string {
+	return a.prefix() + "-" + strconv.Itoa(id)
+}
+
+func (a *LeaseAllocator) prefix() string {
+	if a.Prefix != "" {
+		return a.Prefix
+	}
+	return defaultLeasePrefix
+}
+
+func (a *LeaseAllocator) leaseDuration() time.Duration {
+	if a.LeaseDuration > 0 {
+		return a.LeaseDuration
+	}
+	return defaultLeaseDuration
+}
+
+func (a *LeaseAllocator) now() time.Time {
+	if a.nowFunc != nil {
+		return a.nowFunc()
+	}
+	return time.Now()
+}
+
+// lease is the subset of a coordination.k8s.io/v1 Lease used for machine IDs.
+type lease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace,omitempty"`
+		UID             string            `json:"uid,omitempty"`
+		ResourceVersion string            `json:"resourceVersion,omitempty"`
+		Labels          map[string]string `json:"labels,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+	} `json:"spec"`
+}
+
+func apiError(resp *http.Response) error {
+	var status struct {
+		Message string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&status)
+	if status.Message == "" {
+		status.Message = http.StatusText(resp.StatusCode)
+	}
+	return fmt.Errorf("kubernetes api: %d %s", resp.StatusCode, status.Message)
+}
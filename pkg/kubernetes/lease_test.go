@@ -0,0 +1,402 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testNamespace = "ids"
+	testToken     = "test-token"
+	leasesPath    = "/apis/coordination.k8s.io/v1/namespaces/" + testNamespace + "/leases"
+)
+
+// fakeAPIServer implements the Lease endpoints of the Kubernetes API server,
+// including resourceVersion conflicts and delete preconditions.
+type fakeAPIServer struct {
+	mu        sync.Mutex
+	leases    map[string]*lease
+	version   int
+	failPuts  bool
+	deletes   int
+	srv       *httptest.Server
+	allocated []*LeaseAllocator
+}
+
+func newFakeAPIServer(t *testing.T) *fakeAPIServer {
+	t.Helper()
+	f := &fakeAPIServer{leases: map[string]*lease{}}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(func() {
+		for _, a := range f.allocated {
+			a.Release(context.Background())
+		}
+		f.srv.Close()
+	})
+	return f
+}
+
+func (f *fakeAPIServer) allocator(holder string, duration time.Duration) *LeaseAllocator {
+	a := &LeaseAllocator{
+		APIServer:     f.srv.URL,
+		Token:         testToken,
+		Namespace:     testNamespace,
+		Holder:        holder,
+		LeaseDuration: duration,
+	}
+	f.allocated = append(f.allocated, a)
+	return a
+}
+
+// put stores a Lease as if another pod had written it.
+func (f *fakeAPIServer) put(id int, holder string, renewed time.Time, seconds int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	l := &lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+	l.Metadata.Name = "kubeflake-" + strconv.Itoa(id)
+	l.Metadata.UID = "uid-" + strconv.Itoa(f.version)
+	if current, ok := f.leases[l.Metadata.Name]; ok {
+		l.Metadata.UID = current.Metadata.UID
+	}
+	l.Metadata.ResourceVersion = strconv.Itoa(f.version)
+	l.Metadata.Labels = map[string]string{leaseGroupLabel: "kubeflake", leaseMachineLabel: strconv.Itoa(id)}
+	l.Spec.HolderIdentity = holder
+	l.Spec.RenewTime = renewed.UTC().Format(microTimeFormat)
+	l.Spec.LeaseDurationSeconds = seconds
+	f.leases[l.Metadata.Name] = l
+}
+
+func (f *fakeAPIServer) get(name string) *lease {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.leases[name]
+}
+
+func (f *fakeAPIServer) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	name, isItem := strings.CutPrefix(r.URL.Path, leasesPath+"/")
+	if !isItem && r.URL.Path != leasesPath {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case !isItem && r.Method == http.MethodGet:
+		selector := strings.SplitN(r.URL.Query().Get("labelSelector"), "=", 2)
+		items := []*lease{}
+		for _, l := range f.leases {
+			if len(selector) == 2 && l.Metadata.Labels[selector[0]] == selector[1] {
+				items = append(items, l)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"items": items})
+	case !isItem && r.Method == http.MethodPost:
+		l := new(lease)
+		json.NewDecoder(r.Body).Decode(l)
+		if _, ok := f.leases[l.Metadata.Name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.version++
+		l.Metadata.UID = "uid-" + strconv.Itoa(f.version)
+		l.Metadata.ResourceVersion = strconv.Itoa(f.version)
+		f.leases[l.Metadata.Name] = l
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(l)
+	case r.Method == http.MethodGet:
+		l, ok := f.leases[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(l)
+	case r.Method == http.MethodPut:
+		if f.failPuts {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		l := new(lease)
+		json.NewDecoder(r.Body).Decode(l)
+		current, ok := f.leases[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if current.Metadata.ResourceVersion != l.Metadata.ResourceVersion {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.version++
+		l.Metadata.UID = current.Metadata.UID
+		l.Metadata.ResourceVersion = strconv.Itoa(f.version)
+		f.leases[name] = l
+		json.NewEncoder(w).Encode(l)
+	case r.Method == http.MethodDelete:
+		f.deletes++
+		current, ok := f.leases[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var opts struct {
+			Preconditions struct {
+				UID             string `json:"uid"`
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"preconditions"`
+		}
+		json.NewDecoder(r.Body).Decode(&opts)
+		if uid := opts.Preconditions.UID; uid != "" && uid != current.Metadata.UID {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if rv := opts.Preconditions.ResourceVersion; rv != "" && rv != current.Metadata.ResourceVersion {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		delete(f.leases, name)
+		w.Write([]byte(`{"kind":"Status","status":"Success"}`))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// waitFor polls cond for up to 2 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLeaseAllocator_DistinctIDs(t *testing.T) {
+	api := newFakeAPIServer(t)
+	ctx := context.Background()
+
+	seen := map[int]bool{}
+	for _, holder := range []string{"web-7c9f-abcde", "web-7c9f-fghij", "web-7c9f-klmno"} {
+		a := api.allocator(holder, 0)
+		if err := a.Err(); !errors.Is(err, ErrLeaseNotAcquired) {
+			t.Fatalf("%s: expected ErrLeaseNotAcquired before Acquire, got %v", holder, err)
+		}
+		id, err := a.Acquire(ctx, 8)
+		if err != nil {
+			t.Fatalf("%s: Acquire error: %v", holder, err)
+		}
+		if seen[id] {
+			t.Fatalf("%s: machine id %d handed out twice", holder, id)
+		}
+		seen[id] = true
+		if err := a.Err(); err != nil {
+			t.Fatalf("%s: unexpected Err: %v", holder, err)
+		}
+		if again, err := a.Acquire(ctx, 8); err != nil || again != id {
+			t.Fatalf("%s: Acquire again: want %d, got %d (%v)", holder, id, again, err)
+		}
+		if l := api.get("kubeflake-" + strconv.Itoa(id)); l == nil || l.Spec.HolderIdentity != holder {
+			t.Fatalf("%s: lease for id %d not held by it: %+v", holder, id, l)
+		}
+	}
+}
+
+// keepAlive rewrites the Lease for id every 50ms until the test ends, as a
+// live pod would, with a renewTime from a clock an hour behind.
+func (f *fakeAPIServer) keepAlive(t *testing.T, id int, holder string) {
+	f.put(id, holder, time.Now().Add(-time.Hour), 1)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				f.put(id, holder, time.Now().Add(-time.Hour), 1)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+}
+
+func TestLeaseAllocator_SkipsLiveAndTakesOverExpiredLeases(t *testing.T) {
+	api := newFakeAPIServer(t)
+	api.keepAlive(t, 0, "skewed")
+	api.put(1, "crashed", time.Now(), 1)
+
+	a := api.allocator("newcomer", 0)
+	start := time.Now()
+	id, err := a.Acquire(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+	if id != 1 {
+		t.Fatalf("want the expired id 1, got %d", id)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Fatalf("the lease of crashed was taken over after %v, before it was seen unchanged for its duration", waited)
+	}
+	l := api.get("kubeflake-1")
+	if l.Spec.HolderIdentity != "newcomer" || l.Spec.LeaseTransitions != 1 {
+		t.Fatalf("lease not taken over: %+v", l.Spec)
+	}
+}
+
+func TestLeaseAllocator_NoFreeID(t *testing.T) {
+	api := newFakeAPIServer(t)
+	api.keepAlive(t, 0, "alive-0")
+	api.keepAlive(t, 1, "alive-1")
+
+	_, err := api.allocator("late", 0).Acquire(context.Background(), 2)
+	if !errors.Is(err, ErrNoFreeMachineId) {
+		t.Fatalf("expected ErrNoFreeMachineId, got %v", err)
+	}
+}
+
+func TestLeaseAllocator_AllocatorsInOnePodClaimDistinctIDs(t *testing.T) {
+	api := newFakeAPIServer(t)
+	t.Setenv("POD_NAME", "web-7c9f-abcde")
+	ctx := context.Background()
+
+	first, second := api.allocator("", 0), api.allocator("", 0)
+	a, err := first.Acquire(ctx, 8)
+	if err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+	b, err := second.Acquire(ctx, 8)
+	if err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+	if a == b || first.Holder == second.Holder || !strings.HasPrefix(first.Holder, "web-7c9f-abcde-") {
+		t.Fatalf("want distinct ids and holders, got %d for %q and %d for %q", a, first.Holder, b, second.Holder)
+	}
+
+	// A shared holder does not make the Lease of another allocator free.
+	twin := api.allocator(first.Holder, 0)
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if id, err := twin.Acquire(ctx, 1); !errors.Is(err, ErrNoFreeMachineId) {
+		t.Fatalf("want ErrNoFreeMachineId for a shared holder, got %d, %v", id, err)
+	}
+}
+
+func TestLeaseAllocator_RenewsInTheBackground(t *testing.T) {
+	api := newFakeAPIServer(t)
+	a := api.allocator("renewer", 300*time.Millisecond)
+	if _, err := a.Acquire(context.Background(), 4); err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+	first := api.get("kubeflake-0").Spec.RenewTime
+
+	time.Sleep(600 * time.Millisecond)
+	if err := a.Err(); err != nil {
+		t.Fatalf("lease should have been kept alive, got %v", err)
+	}
+	if renewed := api.get("kubeflake-0").Spec.RenewTime; renewed == first {
+		t.Fatalf("renewTime was never bumped from %s", first)
+	}
+}
+
+func TestLeaseAllocator_LostWhenRenewalsFail(t *testing.T) {
+	api := newFakeAPIServer(t)
+	a := api.allocator("flaky", 300*time.Millisecond)
+	if _, err := a.Acquire(context.Background(), 4); err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+
+	api.mu.Lock()
+	api.failPuts = true
+	api.mu.Unlock()
+	waitFor(t, "ErrLeaseLost", func() bool { return errors.Is(a.Err(), ErrLeaseLost) })
+}
+
+func TestLeaseAllocator_LostWhenTakenOver(t *testing.T) {
+	api := newFakeAPIServer(t)
+	a := api.allocator("victim", 300*time.Millisecond)
+	id, err := a.Acquire(context.Background(), 4)
+	if err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+
+	api.put(id, "thief", time.Now(), 15)
+	waitFor(t, "ErrLeaseLost", func() bool { return errors.Is(a.Err(), ErrLeaseLost) })
+	if l := api.get("kubeflake-" + strconv.Itoa(id)); l.Spec.HolderIdentity != "thief" {
+		t.Fatalf("the lost lease must not be written back, holder is %q", l.Spec.HolderIdentity)
+	}
+	if err := a.Release(context.Background()); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+	api.mu.Lock()
+	deletes := api.deletes
+	api.mu.Unlock()
+	if deletes != 0 {
+		t.Fatalf("Release of a lost lease sent %d deletes", deletes)
+	}
+	if l := api.get("kubeflake-" + strconv.Itoa(id)); l == nil || l.Spec.HolderIdentity != "thief" {
+		t.Fatalf("Release deleted the lease of the new holder: %+v", l)
+	}
+}
+
+func TestLeaseAllocator_ReleaseKeepsALeaseWrittenSince(t *testing.T) {
+	api := newFakeAPIServer(t)
+	a := api.allocator("first", 0)
+	id, err := a.Acquire(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+
+	// Taken over before the next renewal noticed, the delete preconditions fail.
+	api.put(id, "second", time.Now(), 15)
+	if err := a.Release(context.Background()); err != nil {
+		t.Fatalf("want a conflict treated as already released, got %v", err)
+	}
+	if l := api.get("kubeflake-0"); l == nil || l.Spec.HolderIdentity != "second" {
+		t.Fatalf("Release deleted the lease of the new holder: %+v", l)
+	}
+}
+
+func TestLeaseAllocator_ReleaseFreesTheID(t *testing.T) {
+	api := newFakeAPIServer(t)
+	ctx := context.Background()
+	first := api.allocator("first", 0)
+	if _, err := first.Acquire(ctx, 1); err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+	if err := first.Release(ctx); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+	if api.get("kubeflake-0") != nil {
+		t.Fatalf("released lease still exists")
+	}
+	if err := first.Err(); !errors.Is(err, ErrLeaseNotAcquired) {
+		t.Fatalf("expected ErrLeaseNotAcquired after Release, got %v", err)
+	}
+
+	id, err := api.allocator("second", 0).Acquire(ctx, 1)
+	if err != nil || id != 0 {
+		t.Fatalf("want id 0 after release, got %d (%v)", id, err)
+	}
+}
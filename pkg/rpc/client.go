@@ -0,0 +1,295 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	kubeflake "github.com/FlorinBalint/kubeflake/v1"
+)
+
+// DefaultTimeout bounds every unary call of a Client, unless Client.Timeout is set.
+const DefaultTimeout = 5 * time.Second
+
+var errNoStatus = errors.New("response without grpc-status")
+
+var _ kubeflake.Generator = (*Client)(nil)
+
+// Client calls a remote Kubeflake service. It implements kubeflake.Generator,
+// so it can replace an in-process Kubeflake.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	// Timeout bounds every unary call, DefaultTimeout if 0.
+	Timeout time.Duration
+	// MaxIDs bounds the count of a NextIDs call, DefaultMaxIDs if 0. Set it
+	// to the MaxIDs of the server, so that larger calls fail without a round trip.
+	MaxIDs int
+}
+
+// NewClient returns a Client for the service at addr ("host:port"), over
+// unencrypted HTTP/2.
+func NewClient(addr string) *Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return NewClientWithHTTP("http://"+addr, &http.Client{
+		Transport: &http.Transport{Protocols: protocols},
+	})
+}
+
+// NewClientWithHTTP returns a Client for the service at baseURL, e.g.
+// "https://ids.example.com", sending the calls through c, which must
+// speak HTTP/2 to the server.
+func NewClientWithHTTP(baseURL string, c *http.Client) *Client {
+	return &Client{baseURL: baseURL, httpClient: c}
+}
+
+// NextID generates a new ID on the server.
+func (c *Client) NextID() (uint64, error) {
+	return c.NextIDWithContext(context.Background())
+}
+
+// NextIDWithContext is NextID, bounded by ctx as well as Timeout.
+func (c *Client) NextIDWithContext(ctx context.Context) (uint64, error) {
+	resp := new(ID)
+	err := c.unary(ctx, "NextID", new(NextIDRequest), resp)
+	return resp.ID, err
+}
+
+// NextKey generates a new ID on the server, as a key.
+func (c *Client) NextKey() (string, error) {
+	return c.NextKeyWithContext(context.Background())
+}
+
+// NextKeyWithContext is NextKey, bounded by ctx as well as Timeout.
+func (c *Client) NextKeyWithContext(ctx context.Context) (string, error) {
+	resp := new(ID)
+	err := c.unary(ctx, "NextID", new(NextIDRequest), resp)
+	return resp.Key, err
+}
+
+// NextIDs generates n new IDs on the server, in increasing order.
+func (c *Client) NextIDs(n int) ([]uint64, error) {
+	return c.NextIDsWithContext(context.Background(), n)
+}
+
+// NextIDsWithContext is NextIDs, bounded by ctx as well as Timeout.
+func (c *Client) NextIDsWithContext(ctx context.Context, n int) ([]uint64, error) {
+	if n <= 0 || n > c.maxIDs() {
+		return nil, &StatusError{Code: InvalidArgument, Message: fmt.Sprintf("count must be in [1, %d]", c.maxIDs())}
+	}
+	resp := new(IDs)
+	if err := c.unary(ctx, "NextIDs", &NextIDsRequest{Count: uint32(n)}, resp); err != nil {
+		return nil, err
+	}
+	return resp.IDs, nil
+}
+
+func (c *Client) maxIDs() int {
+	if c.MaxIDs > 0 {
+		return min(c.MaxIDs, 1<<32-1)
+	}
+	return DefaultMaxIDs
+}
+
+// Compose returns the ID of the given parts, see Kubeflake.Compose.
+func (c *Client) Compose(t time.Time, sequence, machineID, clusterId int) (uint64, error) {
+	return c.ComposeWithContext(context.Background(), t, sequence, machineID, clusterId)
+}
+
+// ComposeWithContext is Compose, bounded by ctx as well as Timeout.
+func (c *Client) ComposeWithContext(ctx context.Context, t time.Time, sequence, machineID, clusterId int) (uint64, error) {
+	resp, err := c.compose(ctx, t, sequence, machineID, clusterId)
+	return resp.ID, err
+}
+
+// ComposeKey returns the key of the given parts, see Kubeflake.ComposeKey.
+func (c *Client) ComposeKey(t time.Time, sequence, machineID, clusterId int) (string, error) {
+	return c.ComposeKeyWithContext(context.Background(), t, sequence, machineID, clusterId)
+}
+
+// ComposeKeyWithContext is ComposeKey, bounded by ctx as well as Timeout.
+func (c *Client) ComposeKeyWithContext(ctx context.Context, t time.Time, sequence, machineID, clusterId int) (string, error) {
+	resp, err := c.compose(ctx, t, sequence, machineID, clusterId)
+	return resp.Key, err
+}
+
+func (c *Client) compose(ctx context.Context, t time.Time, sequence, machineID, clusterId int) (*ID, error) {
+	if sequence < 0 || machineID < 0 || clusterId < 0 {
+		return new(ID), &StatusError{Code: InvalidArgument, Message: "negative id part"}
+	}
+	resp := new(ID)
+	err := c.unary(ctx, "Compose", &ComposeRequest{
+		TimeUnixNano: t.UnixNano(),
+		Sequence:     uint32(sequence),
+		MachineID:    uint32(machineID),
+		ClusterID:    uint32(clusterId),
+	}, resp)
+	return resp, err
+}
+
+// DecomposeKey splits a key into its parts on the server.
+func (c *Client) DecomposeKey(key string) (map[kubeflake.IdParts]uint64, error) {
+	return c.DecomposeKeyWithContext(context.Background(), key)
+}
+
+// DecomposeKeyWithContext is DecomposeKey, bounded by ctx as well as Timeout.
+func (c *Client) DecomposeKeyWithContext(ctx context.Context, key string) (map[kubeflake.IdParts]uint64, error) {
+	resp, err := c.DecomposeWithContext(ctx, &DecomposeRequest{Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return map[kubeflake.IdParts]uint64{
+		kubeflake.Timestamp: resp.Timestamp,
+		kubeflake.Sequence:  uint64(resp.Sequence),
+		kubeflake.MachineID: uint64(resp.MachineID),
+		kubeflake.ClusterID: uint64(resp.ClusterID),
+	}, nil
+}
+
+// Decompose splits an ID, given as a key or as a number, into its parts.
+func (c *Client) Decompose(req *DecomposeRequest) (*Decomposition, error) {
+	return c.DecomposeWithContext(context.Background(), req)
+}
+
+// DecomposeWithContext is Decompose, bounded by ctx as well as Timeout.
+func (c *Client) DecomposeWithContext(ctx context.Context, req *DecomposeRequest) (*Decomposition, error) {
+	resp := new(Decomposition)
+	if err := c.unary(ctx, "Decompose", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// StreamIDs streams count new IDs from the server in batches of up to
+// batchSize, or until ctx is done if count is 0, and calls fn with every
+// batch. The stream stops at the first error fn returns.
+func (c *Client) StreamIDs(ctx context.Context, count uint64, batchSize int, fn func(*IDs) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	resp, err := c.do(ctx, "StreamIDs", &StreamIDsRequest{Count: count, BatchSize: uint32(batchSize)})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	for {
+		batch := new(IDs)
+		err := readResponse(ctx, resp.Body, batch)
+		if isEOF(err) {
+			return callStatus(resp)
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+	}
+}
+
+// unary makes a call with one request and one response message.
+func (c *Client) unary(ctx context.Context, method string, req, resp message) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	httpResp, err := c.do(ctx, method, req)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if err := readResponse(ctx, httpResp.Body, resp); err != nil && !isEOF(err) {
+		return err
+	}
+	// The status trailer follows the message.
+	io.Copy(io.Discard, httpResp.Body)
+	return callStatus(httpResp)
+}
+
+func (c *Client) do(ctx context.Context, method string, req message) (*http.Response, error) {
+	var body bytes.Buffer
+	if err := writeMessage(&body, req); err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/"+ServiceName+"/"+method, &body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", grpcContentType)
+	httpReq.Header.Set("TE", "trailers")
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, transportError(ctx, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &StatusError{Code: Unknown, Message: "unexpected HTTP status " + resp.Status}
+	}
+	if ct := resp.Header.Get("Content-Type"); !isGRPCContentType(ct) {
+		resp.Body.Close()
+		return nil, &StatusError{Code: Unknown, Message: fmt.Sprintf("unexpected content type %q", ct)}
+	}
+	return resp, nil
+}
+
+// callStatus returns the status of a call whose body was read to the end,
+// from the trailers or the headers of a trailers-only response.
+func callStatus(resp *http.Response) error {
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status == "" {
+		return statusError(Internal, errNoStatus)
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return statusError(Internal, fmt.Errorf("invalid grpc-status %q", status))
+	}
+	if code == int(OK) {
+		return nil
+	}
+	return &StatusError{Code: Code(code), Message: decodeMessage(message)}
+}
+
+// readResponse reads one response message. readMessage blames a bad
+// message on the caller, as the server must; on the client a response cut
+// short is Unavailable and one that cannot be decoded is Internal.
+func readResponse(ctx context.Context, r io.Reader, m message) error {
+	err := readMessage(r, m)
+	var status *StatusError
+	if err == nil || isEOF(err) || !errors.As(err, &status) || status.Code != InvalidArgument {
+		return err
+	}
+	if errors.Is(err, errMalformed) {
+		return statusError(Internal, fmt.Errorf("decoding the response: %w", status.err))
+	}
+	return transportError(ctx, status.err)
+}
+
+// transportError returns the status of a call that failed to reach the
+// server or to read its response.
+func transportError(ctx context.Context, err error) *StatusError {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded):
+		return statusError(DeadlineExceeded, err)
+	case ctx.Err() != nil:
+		return statusError(Canceled, err)
+	default:
+		return statusError(Unavailable, err)
+	}
+}
+
+// isEOF reports whether readMessage failed because the stream ended
+// before a message header.
+func isEOF(err error) bool {
+	return errors.Is(err, io.EOF)
+}
@@ -0,0 +1,349 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	kubeflake "github.com/FlorinBalint/kubeflake/v1"
+)
+
+func newTestGenerator(t *testing.T) *kubeflake.Kubeflake {
+	t.Helper()
+	kf, err := kubeflake.New(
+		kubeflake.WithEpoch(time.Now().Add(-time.Hour)),
+		kubeflake.WithClusterIdFn(func() (int, error) { return 3, nil }),
+		kubeflake.WithMachineIdFn(func() (int, error) { return 9, nil }),
+	)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	return kf
+}
+
+// newTestClient serves s over unencrypted HTTP/2 and returns a client for it.
+func newTestClient(t *testing.T, s http.Handler) *Client {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(s)
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return NewClient(strings.TrimPrefix(ts.URL, "http://"))
+}
+
+func wantCode(t *testing.T, err error, code Code) {
+	t.Helper()
+	var status *StatusError
+	if !errors.As(err, &status) || status.Code != code {
+		t.Fatalf("want status code %d, got %v", code, err)
+	}
+}
+
+func TestClient_UnaryCalls(t *testing.T) {
+	kf := newTestGenerator(t)
+	c := newTestClient(t, &Server{Generator: func() (*kubeflake.Kubeflake, error) { return kf, nil }, MaxIDs: 100})
+
+	id, err := c.NextID()
+	if err != nil {
+		t.Fatalf("NextID() error: %v", err)
+	}
+	ids, err := c.NextIDs(50)
+	if err != nil {
+		t.Fatalf("NextIDs() error: %v", err)
+	}
+	if len(ids) != 50 {
+		t.Fatalf("want 50 ids, got %d", len(ids))
+	}
+	last := id
+	for _, next := range ids {
+		if next <= last {
+			t.Fatalf("ids must increase: %d after %d", next, last)
+		}
+		last = next
+	}
+
+	key, err := c.NextKey()
+	if err != nil {
+		t.Fatalf("NextKey() error: %v", err)
+	}
+	parts, err := c.DecomposeKey(key)
+	if err != nil {
+		t.Fatalf("DecomposeKey() error: %v", err)
+	}
+	want, _ := kf.DecomposeKey(key)
+	for part, v := range want {
+		if parts[part] != v {
+			t.Fatalf("part %v: want %d, got %d", part, v, parts[part])
+		}
+	}
+
+	byID, err := c.Decompose(&DecomposeRequest{ID: id})
+	if err != nil {
+		t.Fatalf("Decompose() error: %v", err)
+	}
+	if byID.ID != id || byID.Key != kf.ID(id).String() || byID.MachineID != 9 || byID.ClusterID != 3 {
+		t.Fatalf("unexpected decomposition %+v", byID)
+	}
+	if time.Since(time.Unix(0, byID.TimeUnixNano)) > time.Minute {
+		t.Fatalf("want a recent time, got %v", time.Unix(0, byID.TimeUnixNano))
+	}
+
+	at := time.Now().Add(-time.Minute).Truncate(10 * time.Millisecond)
+	composed, err := c.Compose(at, 4, 9, 3)
+	if err != nil {
+		t.Fatalf("Compose() error: %v", err)
+	}
+	local, _ := kf.Compose(at, 4, 9, 3)
+	if composed != local {
+		t.Fatalf("want the id %d, got %d", local, composed)
+	}
+	composedKey, err := c.ComposeKey(at, 4, 9, 3)
+	if err != nil || composedKey != kf.ID(local).String() {
+		t.Fatalf("ComposeKey() = %q, %v; want %q", composedKey, err, kf.ID(local).String())
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	kf := newTestGenerator(t)
+	c := newTestClient(t, &Server{Generator: func() (*kubeflake.Kubeflake, error) { return kf, nil }, MaxIDs: 100})
+
+	_, err := c.NextIDs(101)
+	wantCode(t, err, InvalidArgument)
+	_, err = c.DecomposeKey("abc!def")
+	wantCode(t, err, InvalidArgument)
+	_, err = c.Compose(time.Now(), 1<<20, 0, 0)
+	wantCode(t, err, InvalidArgument)
+	err = c.unary(context.Background(), "Unknown", new(NextIDRequest), new(ID))
+	wantCode(t, err, Unimplemented)
+
+	errResolving := errors.New("resolving the machine id")
+	pending := newTestClient(t, &Server{Generator: func() (*kubeflake.Kubeflake, error) { return nil, errResolving }})
+	_, err = pending.NextID()
+	wantCode(t, err, Unavailable)
+	if !strings.Contains(err.Error(), errResolving.Error()) {
+		t.Fatalf("want the generator error in the status, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.NextIDWithContext(ctx)
+	wantCode(t, err, Canceled)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want the context error wrapped in the status, got %v", err)
+	}
+}
+
+func TestClient_BadResponses(t *testing.T) {
+	// Responses the server cuts short or garbles are not the caller's fault.
+	responses := map[string]struct {
+		frame []byte
+		code  Code
+	}{
+		"truncated":   {[]byte{0, 0, 0, 0, 10, 8, 1}, Unavailable},
+		"header only": {[]byte{0, 0, 0, 0, 10}, Unavailable},
+		"malformed":   {[]byte{0, 0, 0, 0, 2, 0xff, 0xff}, Internal},
+	}
+	for name, tt := range responses {
+		c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", grpcContentType)
+			w.Write(tt.frame)
+		}))
+		_, err := c.NextID()
+		var status *StatusError
+		if !errors.As(err, &status) || status.Code != tt.code {
+			t.Fatalf("%s: want status code %d, got %v", name, tt.code, err)
+		}
+	}
+}
+
+func TestClient_StreamIDs(t *testing.T) {
+	c := newTestClient(t, NewServer(newTestGenerator(t)))
+
+	var got []uint64
+	var batches int
+	err := c.StreamIDs(context.Background(), 2500, 1000, func(b *IDs) error {
+		if len(b.IDs) != len(b.Keys) || len(b.IDs) > 1000 {
+			t.Fatalf("unexpected batch of %d ids and %d keys", len(b.IDs), len(b.Keys))
+		}
+		got = append(got, b.IDs...)
+		batches++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamIDs() error: %v", err)
+	}
+	if len(got) != 2500 || batches != 3 {
+		t.Fatalf("want 2500 ids in 3 batches, got %d in %d", len(got), batches)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Fatalf("ids must increase: %d after %d", got[i], got[i-1])
+		}
+	}
+
+	// An unbounded stream runs until the client stops it.
+	errEnough := errors.New("enough")
+	var streamed int
+	err = c.StreamIDs(context.Background(), 0, 10, func(b *IDs) error {
+		if streamed += len(b.IDs); streamed >= 100 {
+			return errEnough
+		}
+		return nil
+	})
+	if !errors.Is(err, errEnough) {
+		t.Fatalf("want the callback error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = c.StreamIDs(ctx, 0, 10, func(*IDs) error {
+		cancel()
+		return nil
+	})
+	wantCode(t, err, Canceled)
+}
+
+func TestClient_IsAGenerator(t *testing.T) {
+	kf := newTestGenerator(t)
+	generators := map[string]kubeflake.Generator{
+		"local":  kf,
+		"remote": newTestClient(t, NewServer(kf)),
+	}
+	for name, g := range generators {
+		key, err := g.NextKey()
+		if err != nil {
+			t.Fatalf("%s: NextKey() error: %v", name, err)
+		}
+		parts, err := g.DecomposeKey(key)
+		if err != nil {
+			t.Fatalf("%s: DecomposeKey() error: %v", name, err)
+		}
+		if parts[kubeflake.MachineID] != 9 || parts[kubeflake.ClusterID] != 3 {
+			t.Fatalf("%s: unexpected parts %v", name, parts)
+		}
+	}
+}
+
+func TestMessages_RoundTrip(t *testing.T) {
+	messages := []struct {
+		in, out message
+	}{
+		{&ID{ID: 1 << 63, Key: "key"}, new(ID)},
+		{&NextIDsRequest{Count: 300}, new(NextIDsRequest)},
+		{&IDs{IDs: []uint64{1, 300, 1 << 60}, Keys: []string{"a", "", "c"}}, new(IDs)},
+		{&DecomposeRequest{Key: "key", ID: 7}, new(DecomposeRequest)},
+		{&Decomposition{ID: 1, Key: "k", TimeUnixNano: -5, Timestamp: 2, Sequence: 3, MachineID: 4, ClusterID: 5, Location: "us-east-1"}, new(Decomposition)},
+		{&ComposeRequest{TimeUnixNano: 1, Sequence: 2, MachineID: 3, ClusterID: 4}, new(ComposeRequest)},
+		{&StreamIDsRequest{Count: 1 << 40, BatchSize: 10}, new(StreamIDsRequest)},
+	}
+	for _, m := range messages {
+		// An unknown field must be skipped.
+		b := appendStringField(m.in.marshal(nil), 99, "unknown")
+		if err := m.out.unmarshal(b); err != nil {
+			t.Fatalf("%T: unmarshal error: %v", m.in, err)
+		}
+		if string(m.out.marshal(nil)) != string(m.in.marshal(nil)) {
+			t.Fatalf("%T: want %+v, got %+v", m.in, m.in, m.out)
+		}
+	}
+
+	for _, b := range [][]byte{{0x08}, {0x0a, 0x05, 'a'}, {0x00}, {0x0f}} {
+		if err := new(ID).unmarshal(b); !errors.Is(err, errMalformed) {
+			t.Fatalf("%x: want errMalformed, got %v", b, err)
+		}
+	}
+}
+
+// TestServer_Wire checks the parts of the protocol that grpc-go and grpcurl
+// rely on, with a plain HTTP/2 client instead of Client.
+func TestServer_Wire(t *testing.T) {
+	c := newTestClient(t, &Server{Generator: func() (*kubeflake.Kubeflake, error) { return newTestGenerator(t), nil }, MaxIDs: 100})
+	post := func(contentType, method string, req message) *http.Response {
+		t.Helper()
+		var body bytes.Buffer
+		if err := writeMessage(&body, req); err != nil {
+			t.Fatal(err)
+		}
+		httpReq, err := http.NewRequest(http.MethodPost, c.baseURL+"/"+ServiceName+"/"+method, &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpReq.Header.Set("Content-Type", contentType)
+		httpReq.Header.Set("TE", "trailers")
+		resp, err := c.httpClient.Do(httpReq)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	// A call with a message has the status in the trailers.
+	resp := post("application/grpc+proto", "NextID", new(NextIDRequest))
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Grpc-Status") != "" || resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Fatalf("want grpc-status 0 in the trailers only, got headers %v and trailers %v", resp.Header, resp.Trailer)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != grpcContentType {
+		t.Fatalf("want content type %s, got %q", grpcContentType, ct)
+	}
+
+	// A call failing before any message is trailers-only.
+	resp = post(grpcContentType, "NextIDs", &NextIDsRequest{Count: 101})
+	if body, _ := io.ReadAll(resp.Body); len(body) != 0 {
+		t.Fatalf("want no messages, got %x", body)
+	}
+	if resp.Header.Get("Grpc-Status") != "3" || resp.Header.Get("Grpc-Message") != "count must be in [1, 100]" {
+		t.Fatalf("want the status in the headers, got %v", resp.Header)
+	}
+	if len(resp.Trailer) != 0 {
+		t.Fatalf("want no trailers, got %v", resp.Trailer)
+	}
+
+	for _, ct := range []string{"application/grpc+json", "application/grpcweb", "application/json"} {
+		if resp := post(ct, "NextID", new(NextIDRequest)); resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Fatalf("%s: want HTTP 415, got %s", ct, resp.Status)
+		}
+	}
+}
+
+func TestStatusMessage_PercentEncoding(t *testing.T) {
+	msg := "key \"ab%\" is not base62: ü\n"
+	encoded := encodeMessage(msg)
+	if want := `key "ab%25" is not base62: %C3%BC%0A`; encoded != want {
+		t.Fatalf("encodeMessage() = %q, want %q", encoded, want)
+	}
+	if decoded := decodeMessage(encoded); decoded != msg {
+		t.Fatalf("decodeMessage() = %q, want %q", decoded, msg)
+	}
+	// A '%' that does not start an escape is kept.
+	for _, s := range []string{"100%", "%zz", "%4"} {
+		if decoded := decodeMessage(s); decoded != s {
+			t.Fatalf("decodeMessage(%q) = %q", s, decoded)
+		}
+	}
+}
+
+func TestClient_MaxIDsAndContentType(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "<html></html>")
+	}))
+	c.MaxIDs = 10
+	_, err := c.NextIDs(11)
+	wantCode(t, err, InvalidArgument)
+	if !strings.Contains(err.Error(), "[1, 10]") {
+		t.Fatalf("want the client bound in the error, got %v", err)
+	}
+	// Responses that are not gRPC, e.g. of a proxy, are not decoded.
+	_, err = c.NextIDs(10)
+	wantCode(t, err, Unknown)
+}
@@ -0,0 +1,356 @@
+// Package rpc serves Kubeflake IDs over gRPC, as described by
+// kubeflake.proto, and provides a Go client for the service.
+//
+// The gRPC protocol is implemented on top of net/http and HTTP/2, with the
+// messages encoded by hand, so the package has no dependencies beyond the
+// standard library. Any gRPC client generated from kubeflake.proto can talk
+// to Server, and Client can talk to any server of the service.
+//
+// Server sends the status in the trailers after the messages, or in the
+// headers of a trailers-only response for calls that fail before any, and
+// percent-encodes grpc-message as the gRPC protocol specifies. Compared to
+// grpc-go, it has these limits:
+//
+//   - Only the protobuf encoding is served, as application/grpc or
+//     application/grpc+proto; other content types fail with HTTP 415.
+//   - Compressed messages fail with Unimplemented, so clients must not
+//     enable compression.
+//   - The grpc-timeout header is ignored, calls end when the client cancels.
+//   - There is no server reflection, so grpcurl needs the service
+//     definition, e.g. grpcurl -plaintext -proto kubeflake.proto
+//     localhost:9090 kubeflake.v1.Kubeflake/NextID.
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	kubeflake "github.com/FlorinBalint/kubeflake/v1"
+)
+
+// ServiceName is the full name of the service in kubeflake.proto.
+const ServiceName = "kubeflake.v1.Kubeflake"
+
+const (
+	// DefaultMaxIDs bounds the IDs of one NextIDs call, unless Server.MaxIDs is set.
+	DefaultMaxIDs = 10000
+	// defaultBatchSize is the most IDs per message of StreamIDs.
+	defaultBatchSize = 1000
+	// maxMessageSize bounds the size of the received messages.
+	maxMessageSize  = 4 << 20
+	grpcContentType = "application/grpc"
+	upperHex        = "0123456789ABCDEF"
+)
+
+// Code is a gRPC status code.
+type Code int
+
+const (
+	OK                Code = 0
+	Canceled          Code = 1
+	Unknown           Code = 2
+	InvalidArgument   Code = 3
+	DeadlineExceeded  Code = 4
+	ResourceExhausted Code = 8
+	Unimplemented     Code = 12
+	Internal          Code = 13
+	Unavailable       Code = 14
+)
+
+// StatusError is a call that failed with a gRPC status other than OK.
+type StatusError struct {
+	Code    Code
+	Message string
+
+	err error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("rpc error: code = %d desc = %s", e.Code, e.Message)
+}
+
+// Unwrap returns the local error behind the status, nil for statuses
+// received from the server.
+func (e *StatusError) Unwrap() error {
+	return e.err
+}
+
+func statusError(code Code, err error) *StatusError {
+	return &StatusError{Code: code, Message: err.Error(), err: err}
+}
+
+// encodeMessage percent-encodes a grpc-message value: every byte outside
+// printable ASCII and '%' itself, as the gRPC protocol specifies.
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < ' ' || c > '~' || c == '%' {
+			b.Write([]byte{'%', upperHex[c>>4], upperHex[c&0xf]})
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// decodeMessage undoes encodeMessage, keeping a '%' without two hex digits
+// after it as it is, as grpc-go does.
+func decodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if msg[i] == '%' && i+2 < len(msg) {
+			if c, err := strconv.ParseUint(msg[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(msg[i])
+	}
+	return b.String()
+}
+
+// isGRPCContentType reports whether ct is a gRPC content type of the
+// protobuf encoding, the only one the service speaks.
+func isGRPCContentType(ct string) bool {
+	rest, ok := strings.CutPrefix(ct, grpcContentType)
+	if !ok {
+		return false
+	}
+	rest, _, _ = strings.Cut(rest, ";")
+	return rest == "" || rest == "+proto"
+}
+
+// responseWriter records whether a message was written, since the status
+// goes into the trailers after messages and into the headers otherwise.
+type responseWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController flush the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Server is the gRPC Kubeflake service, as an http.Handler. It must be
+// served over HTTP/2, e.g. by an http.Server with unencrypted HTTP/2 enabled.
+type Server struct {
+	// Generator returns the Kubeflake that serves the calls, or why it is
+	// not available, e.g. while its machine ID is being resolved. The calls
+	// fail with Unavailable meanwhile.
+	Generator func() (*kubeflake.Kubeflake, error)
+	// MaxIDs bounds the count of a NextIDs call, DefaultMaxIDs if 0.
+	MaxIDs int
+}
+
+// NewServer returns a Server backed by kf.
+func NewServer(kf *kubeflake.Kubeflake) *Server {
+	return &Server{Generator: func() (*kubeflake.Kubeflake, error) { return kf, nil }}
+}
+
+// ServeHTTP serves a gRPC call.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !isGRPCContentType(r.Header.Get("Content-Type")) {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", grpcContentType)
+
+	rw := &responseWriter{ResponseWriter: w}
+	err := s.call(rw, r)
+	status := &StatusError{Code: OK}
+	if err != nil && !errors.As(err, &status) {
+		status = statusError(Internal, err)
+	}
+	// Without messages, the status makes a trailers-only response.
+	prefix := ""
+	if rw.wrote {
+		prefix = http.TrailerPrefix
+	}
+	w.Header().Set(prefix+"Grpc-Status", strconv.Itoa(int(status.Code)))
+	if status.Message != "" {
+		w.Header().Set(prefix+"Grpc-Message", encodeMessage(status.Message))
+	}
+}
+
+func (s *Server) call(w http.ResponseWriter, r *http.Request) error {
+	method, ok := strings.CutPrefix(r.URL.Path, "/"+ServiceName+"/")
+	if !ok {
+		return &StatusError{Code: Unimplemented, Message: "unknown service " + r.URL.Path}
+	}
+	kf, err := s.Generator()
+	if err != nil {
+		return statusError(Unavailable, err)
+	}
+
+	switch method {
+	case "NextID":
+		if err := readMessage(r.Body, new(NextIDRequest)); err != nil {
+			return err
+		}
+		id, err := kf.Next()
+		if err != nil {
+			return statusError(Unavailable, err)
+		}
+		return writeMessage(w, &ID{ID: id.Uint64(), Key: id.String()})
+	case "NextIDs":
+		req := new(NextIDsRequest)
+		if err := readMessage(r.Body, req); err != nil {
+			return err
+		}
+		if req.Count == 0 || int(req.Count) > s.maxIDs() {
+			return &StatusError{Code: InvalidArgument, Message: fmt.Sprintf("count must be in [1, %d]", s.maxIDs())}
+		}
+		resp, err := nextIDs(kf, int(req.Count))
+		if err != nil {
+			return err
+		}
+		return writeMessage(w, resp)
+	case "Decompose":
+		req := new(DecomposeRequest)
+		if err := readMessage(r.Body, req); err != nil {
+			return err
+		}
+		return s.decompose(w, kf, req)
+	case "Compose":
+		req := new(ComposeRequest)
+		if err := readMessage(r.Body, req); err != nil {
+			return err
+		}
+		id, err := kf.Compose(time.Unix(0, req.TimeUnixNano), int(req.Sequence), int(req.MachineID), int(req.ClusterID))
+		if err != nil {
+			return statusError(InvalidArgument, err)
+		}
+		return writeMessage(w, &ID{ID: id, Key: kf.ID(id).String()})
+	case "StreamIDs":
+		req := new(StreamIDsRequest)
+		if err := readMessage(r.Body, req); err != nil {
+			return err
+		}
+		return s.streamIDs(w, r, kf, req)
+	default:
+		return &StatusError{Code: Unimplemented, Message: "unknown method " + method}
+	}
+}
+
+func (s *Server) maxIDs() int {
+	if s.MaxIDs > 0 {
+		return s.MaxIDs
+	}
+	return DefaultMaxIDs
+}
+
+func (s *Server) decompose(w http.ResponseWriter, kf *kubeflake.Kubeflake, req *DecomposeRequest) error {
+	id := kf.ID(req.ID)
+	if req.Key != "" {
+		var err error
+		if id, err = kf.ParseID(req.Key); err != nil {
+			return statusError(InvalidArgument, err)
+		}
+	}
+	location, _ := kf.Location(id.Uint64())
+	return writeMessage(w, &Decomposition{
+		ID:           id.Uint64(),
+		Key:          id.String(),
+		TimeUnixNano: id.Time().UnixNano(),
+		Timestamp:    kf.Decompose(id.Uint64())[kubeflake.Timestamp],
+		Sequence:     uint32(id.Sequence()),
+		MachineID:    uint32(id.MachineID()),
+		ClusterID:    uint32(id.ClusterID()),
+		Location:     location,
+	})
+}
+
+// streamIDs sends batches of IDs until the requested count is reached or
+// the client cancels the call.
+func (s *Server) streamIDs(w http.ResponseWriter, r *http.Request, kf *kubeflake.Kubeflake, req *StreamIDsRequest) error {
+	batch := uint64(req.BatchSize)
+	if batch == 0 || batch > uint64(s.maxIDs()) {
+		batch = min(defaultBatchSize, uint64(s.maxIDs()))
+	}
+	rc := http.NewResponseController(w)
+	for sent := uint64(0); req.Count == 0 || sent < req.Count; {
+		if err := r.Context().Err(); err != nil {
+			return statusError(Canceled, err)
+		}
+		n := batch
+		if req.Count > 0 {
+			n = min(n, req.Count-sent)
+		}
+		resp, err := nextIDs(kf, int(n))
+		if err != nil {
+			return err
+		}
+		if err := writeMessage(w, resp); err != nil {
+			return err
+		}
+		if err := rc.Flush(); err != nil {
+			return statusError(Unavailable, err)
+		}
+		sent += n
+	}
+	return nil
+}
+
+func nextIDs(kf *kubeflake.Kubeflake, n int) (*IDs, error) {
+	ids, err := kf.NextIDs(n)
+	if err != nil {
+		return nil, statusError(Unavailable, err)
+	}
+	resp := &IDs{IDs: ids, Keys: make([]string, len(ids))}
+	for i, id := range ids {
+		resp.Keys[i] = kf.ID(id).String()
+	}
+	return resp, nil
+}
+
+// readMessage reads one length-prefixed message from r. Compressed
+// messages are not supported.
+func readMessage(r io.Reader, m message) error {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return statusError(InvalidArgument, fmt.Errorf("reading the message header: %w", err))
+	}
+	if header[0] != 0 {
+		return &StatusError{Code: Unimplemented, Message: "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxMessageSize {
+		return &StatusError{Code: ResourceExhausted, Message: fmt.Sprintf("message of %d bytes is too large", size)}
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			// Only the end of the stream before a message header is a clean end.
+			err = io.ErrUnexpectedEOF
+		}
+		return statusError(InvalidArgument, fmt.Errorf("reading the message: %w", err))
+	}
+	if err := m.unmarshal(body); err != nil {
+		return statusError(InvalidArgument, err)
+	}
+	return nil
+}
+
+// writeMessage writes m as one length-prefixed message.
+func writeMessage(w io.Writer, m message) error {
+	frame := m.marshal(make([]byte, 5, 64))
+	frame[0] = 0
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(frame)-5))
+	if _, err := w.Write(frame); err != nil {
+		return statusError(Unavailable, err)
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The messages of kubeflake.proto, encoded in the protobuf wire format by
+// hand, so that the package needs neither generated code nor a protobuf
+// runtime. Unknown fields are skipped, as protobuf requires.
+
+var errMalformed = errors.New("malformed protobuf message")
+
+// Wire types of the protobuf encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type NextIDRequest struct{}
+
+type ID struct {
+	ID  uint64
+	Key string
+}
+
+type NextIDsRequest struct {
+	Count uint32
+}
+
+type IDs struct {
+	IDs  []uint64
+	Keys []string
+}
+
+type DecomposeRequest struct {
+	Key string
+	ID  uint64
+}
+
+type Decomposition struct {
+	ID           uint64
+	Key          string
+	TimeUnixNano int64
+	Timestamp    uint64
+	Sequence     uint32
+	MachineID    uint32
+	ClusterID    uint32
+	Location     string
+}
+
+type ComposeRequest struct {
+	TimeUnixNano int64
+	Sequence     uint32
+	MachineID    uint32
+	ClusterID    uint32
+}
+
+type StreamIDsRequest struct {
+	Count     uint64
+	BatchSize uint32
+}
+
+// message is implemented by the messages of the service.
+type message interface {
+	marshal(b []byte) []byte
+	unmarshal(b []byte) error
+}
+
+func (*NextIDRequest) marshal(b []byte) []byte { return b }
+
+func (*NextIDRequest) unmarshal(b []byte) error {
+	return parseFields(b, func(int, int, uint64, []byte) error { return nil })
+}
+
+func (m *ID) marshal(b []byte) []byte {
+	b = appendVarintField(b, 1, m.ID)
+	return appendStringField(b, 2, m.Key)
+}
+
+func (m *ID) unmarshal(b []byte) error {
+	return parseFields(b, func(num, typ int, v uint64, data []byte) error {
+		switch {
+		case num == 1 && typ == wireVarint:
+			m.ID = v
+		case num == 2 && typ == wireBytes:
+			m.Key = string(data)
+		}
+		return nil
+	})
+}
+
+func (m *NextIDsRequest) marshal(b []byte) []byte {
+	return appendVarintField(b, 1, uint64(m.Count))
+}
+
+func (m *NextIDsRequest) unmarshal(b []byte) error {
+	return parseFields(b, func(num, typ int, v uint64, _ []byte) error {
+		if num == 1 && typ == wireVarint {
+			m.Count = uint32(v)
+		}
+		return nil
+	})
+}
+
+func (m *IDs) marshal(b []byte) []byte {
+	if len(m.IDs) > 0 {
+		var packed []byte
+		for _, id := range m.IDs {
+			packed = binary.AppendUvarint(packed, id)
+		}
+		b = appendBytesField(b, 1, packed)
+	}
+	for _, key := range m.Keys {
+		b = appendTag(b, 2, wireBytes)
+		b = binary.AppendUvarint(b, uint64(len(key)))
+		b = append(b, key...)
+	}
+	return b
+}
+
+func (m *IDs) unmarshal(b []byte) error {
+	return parseFields(b, func(num, typ int, v uint64, data []byte) error {
+		switch {
+		case num == 1 && typ == wireVarint:
+			m.IDs = append(m.IDs, v)
+		case num == 1 && typ == wireBytes:
+			// Repeated scalars are packed by default in proto3.
+			for len(data) > 0 {
+				id, n := binary.Uvarint(data)
+				if n <= 0 {
+					return errMalformed
+				}
+				m.IDs = append(m.IDs, id)
+				data = data[n:]
+			}
+		case num == 2 && typ == wireBytes:
+			m.Keys = append(m.Keys, string(data))
+		}
+		return nil
+	})
+}
+
+func (m *DecomposeRequest) marshal(b []byte) []byte {
+	b = appendStringField(b, 1, m.Key)
+	return appendVarintField(b, 2, m.ID)
+}
+
+func (m *DecomposeRequest) unmarshal(b []byte) error {
+	return parseFields(b, func(num, typ int, v uint64, data []byte) error {
+		switch {
+		case num == 1 && typ == wireBytes:
+			m.Key = string(data)
+		case num == 2 && typ == wireVarint:
+			m.ID = v
+		}
+		return nil
+	})
+}
+
+func (m *Decomposition) marshal(b []byte) []byte {
+	b = appendVarintField(b, 1, m.ID)
+	b = appendStringField(b, 2, m.Key)
+	b = appendVarintField(b, 3, uint64(m.TimeUnixNano))
+	b = appendVarintField(b, 4, m.Timestamp)
+	b = appendVarintField(b, 5, uint64(m.Sequence))
+	b = appendVarintField(b, 6, uint64(m.MachineID))
+	b = appendVarintField(b, 7, uint64(m.ClusterID))
+	return appendStringField(b, 8, m.Location)
+}
+
+func (m *Decomposition) unmarshal(b []byte) error {
+	return parseFields(b, func(num, typ int, v uint64, data []byte) error {
+		if typ == wireBytes {
+			switch num {
+			case 2:
+				m.Key = string(data)
+			case 8:
+				m.Location = string(data)
+			}
+			return nil
+		}
+		if typ != wireVarint {
+			return nil
+		}
+		switch num {
+		case 1:
+			m.ID = v
+		case 3:
+			m.TimeUnixNano = int64(v)
+		case 4:
+			m.Timestamp = v
+		case 5:
+			m.Sequence = uint32(v)
+		case 6:
+			m.MachineID = uint32(v)
+		case 7:
+			m.ClusterID = uint32(v)
+		}
+		return nil
+	})
+}
+
+func (m *ComposeRequest) marshal(b []byte) []byte {
+	b = appendVarintField(b, 1, uint64(m.TimeUnixNano))
+	b = appendVarintField(b, 2, uint64(m.Sequence))
+	b = appendVarintField(b, 3, uint64(m.MachineID))
+	return appendVarintField(b, 4, uint64(m.ClusterID))
+}
+
+func (m *ComposeRequest) unmarshal(b []byte) error {
+	return parseFields(b, func(num, typ int, v uint64, _ []byte) error {
+		if typ != wireVarint {
+			return nil
+		}
+		switch num {
+		case 1:
+			m.TimeUnixNano = int64(v)
+		case 2:
+			m.Sequence = uint32(v)
+		case 3:
+			m.MachineID = uint32(v)
+		case 4:
+			m.ClusterID = uint32(v)
+		}
+		return nil
+	})
+}
+
+func (m *StreamIDsRequest) marshal(b []byte) []byte {
+	b = appendVarintField(b, 1, m.Count)
+	return appendVarintField(b, 2, uint64(m.BatchSize))
+}
+
+func (m *StreamIDsRequest) unmarshal(b []byte) error {
+	return parseFields(b, func(num, typ int, v uint64, _ []byte) error {
+		switch {
+		case num == 1 && typ == wireVarint:
+			m.Count = v
+		case num == 2 && typ == wireVarint:
+			m.BatchSize = uint32(v)
+		}
+		return nil
+	})
+}
+
+func appendTag(b []byte, num, typ int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+// appendVarintField appends a varint field, unless it has the default value 0.
+func appendVarintField(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendTag(b, num, wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+// appendStringField appends a string field, unless it is empty.
+func appendStringField(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendTag(b, num, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendBytesField(b []byte, num int, data []byte) []byte {
+	b = appendTag(b, num, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// parseFields calls fn with every field of the message b: with the value
+// of varint and fixed width fields, or the contents of length-delimited ones.
+func parseFields(b []byte, fn func(num, typ int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 || tag>>3 == 0 {
+			return errMalformed
+		}
+		b = b[n:]
+		num, typ := int(tag>>3), int(tag&7)
+
+		var v uint64
+		var data []byte
+		switch typ {
+		case wireVarint:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return errMalformed
+			}
+		case wireFixed64:
+			if n = 8; len(b) < n {
+				return errMalformed
+			}
+			v = binary.LittleEndian.Uint64(b)
+		case wireFixed32:
+			if n = 4; len(b) < n {
+				return errMalformed
+			}
+			v = uint64(binary.LittleEndian.Uint32(b))
+		case wireBytes:
+			length, m := binary.Uvarint(b)
+			if m <= 0 || length > uint64(len(b)-m) {
+				return errMalformed
+			}
+			data = b[m : m+int(length)]
+			n = m + int(length)
+		default:
+			return fmt.Errorf("%w: wire type %d", errMalformed, typ)
+		}
+		if err := fn(num, typ, v, data); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package kubeflake
+
+import "errors"
+
+var errInvalidCount = errors.New("invalid id count")
+
+// IDRange is a block of IDs that share a timestamp and have consecutive
+// sequence numbers. Consecutive IDs in the block are Stride apart.
+type IDRange struct {
+	First  uint64
+	Count  int
+	Stride uint64
+}
+
+// ID returns the i-th ID of the range.
+func (r IDRange) ID(i int) uint64 {
+	return r.First + uint64(i)*r.Stride
+}
+
+// Last returns the last ID of the range.
+func (r IDRange) Last() uint64 {
+	return r.ID(r.Count - 1)
+}
+
+// AppendIDs appends every ID of the range to ids.
+func (r IDRange) AppendIDs(ids []uint64) []uint64 {
+	for i := 0; i < r.Count; i++ {
+		ids = append(ids, r.ID(i))
+	}
+	return ids
+}
+
+// ReserveRange reserves n IDs under a single lock and returns them as blocks
+// of consecutive sequence numbers, one block per time unit. The IDs of later
+// blocks are greater than those of earlier ones, and none of them is returned
+// by any other call. A lock-free generator claims each block with one
+// compare-and-swap instead.
+// Like NextID, ReserveRange sleeps when a time unit runs out of sequence
+// numbers, borrowing time only after the clock moved backwards, and fails if
+// the Kubeflake time overflows.
+func (kf *Kubeflake) ReserveRange(n int) ([]IDRange, error) {
+	if n <= 0 {
+		return nil, errInvalidCount
+	}
+	if kf.lease != nil {
+		if err := kf.lease.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !kf.lockFree {
+		kf.mutex.Lock()
+		defer kf.mutex.Unlock()
+	}
+
+	stride := uint64(1) << kf.shiftSequence
+	var ranges []IDRange
+	for remaining := uint64(n); remaining > 0; {
+		first, count, err := kf.reserve(remaining)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, IDRange{First: first, Count: int(count), Stride: stride})
+		remaining -= count
+	}
+	return ranges, nil
+}
+
+// NextIDs generates n unique IDs in increasing order.
+// It takes the lock once and reads the clock once per time unit it spans,
+// see ReserveRange.
+func (kf *Kubeflake) NextIDs(n int) ([]uint64, error) {
+	ranges, err := kf.ReserveRange(n)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint64, 0, n)
+	for _, r := range ranges {
+		ids = r.AppendIDs(ids)
+	}
+	return ids, nil
+}
+
+// NextKeys generates n unique IDs as base-encoded strings, see NextIDs.
+func (kf *Kubeflake) NextKeys(n int) ([]string, error) {
+	ids, err := kf.NextIDs(n)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = kf.base.Encode(id)
+	}
+	return keys, nil
+}
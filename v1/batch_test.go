@@ -0,0 +1,130 @@
+package kubeflake
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNextIDs_SpansSequenceRollover(t *testing.T) {
+	s := validSettings()
+	kf, err := newWithSettings(context.Background(), s)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	clk := newStepClock(s.EpochTime.Add(time.Hour), 0)
+	kf.nowFunc = clk.Now
+
+	perUnit := 1 << s.BitsSequence
+	n := 2*perUnit + 10
+	ranges, err := kf.ReserveRange(n)
+	if err != nil {
+		t.Fatalf("ReserveRange error: %v", err)
+	}
+	if len(ranges) != 3 {
+		t.Fatalf("want 3 blocks for %d ids, got %d", n, len(ranges))
+	}
+	total := 0
+	for i, r := range ranges {
+		total += r.Count
+		if i > 0 && r.First <= ranges[i-1].Last() {
+			t.Fatalf("block %d starts at %d, not after %d", i, r.First, ranges[i-1].Last())
+		}
+		parts := kf.Decompose(r.Last())
+		if parts[MachineID] != 5 || parts[ClusterID] != 2 {
+			t.Fatalf("block %d has wrong machine/cluster: %v", i, parts)
+		}
+		if want := kf.timePart(ranges[0].First) + uint64(i); parts[Timestamp] != want {
+			t.Fatalf("block %d: want timestamp %d, got %d", i, want, parts[Timestamp])
+		}
+	}
+	if total != n {
+		t.Fatalf("want %d ids reserved, got %d", n, total)
+	}
+
+	ids, err := kf.NextIDs(perUnit)
+	if err != nil {
+		t.Fatalf("NextIDs error: %v", err)
+	}
+	if len(ids) != perUnit {
+		t.Fatalf("want %d ids, got %d", perUnit, len(ids))
+	}
+	last := ranges[len(ranges)-1].Last()
+	for i, id := range ids {
+		if id <= last {
+			t.Fatalf("ids must increase: last=%d current=%d at i=%d", last, id, i)
+		}
+		last = id
+	}
+	next, err := kf.NextID()
+	if err != nil || next <= last {
+		t.Fatalf("NextID after NextIDs must continue the sequence: last=%d next=%d (%v)", last, next, err)
+	}
+}
+
+func TestNextKeys_Decodable(t *testing.T) {
+	s := validSettings()
+	kf, err := newWithSettings(context.Background(), s)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+
+	keys, err := kf.NextKeys(100)
+	if err != nil {
+		t.Fatalf("NextKeys error: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key] {
+			t.Fatalf("duplicate key %q", key)
+		}
+		seen[key] = true
+		if _, err := kf.DecomposeKey(key); err != nil {
+			t.Fatalf("DecomposeKey(%q) error: %v", key, err)
+		}
+	}
+}
+
+func TestNextKeys_SortableKeys(t *testing.T) {
+	kf, err := New(
+		WithSortableKeys(),
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithMachineIdFn(func() (int, error) { return 1, nil }),
+		WithClusterIdFn(func() (int, error) { return 0, nil }),
+	)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	keys, err := kf.NextKeys(1000)
+	if err != nil {
+		t.Fatalf("NextKeys error: %v", err)
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Fatal("sortable keys must sort like the ids")
+	}
+	if _, err := kf.DecomposeKey(keys[0]); err != nil {
+		t.Fatalf("DecomposeKey error: %v", err)
+	}
+}
+
+func TestReserveRange_Errors(t *testing.T) {
+	s := validSettings()
+	kf, err := newWithSettings(context.Background(), s)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	for _, n := range []int{0, -1} {
+		if _, err := kf.ReserveRange(n); !errors.Is(err, errInvalidCount) {
+			t.Fatalf("ReserveRange(%d): expected errInvalidCount, got %v", n, err)
+		}
+	}
+
+	kf.nowFunc = func() time.Time {
+		return s.EpochTime.Add(time.Duration(1<<kf.bitsTime) * s.TimeUnit)
+	}
+	if _, err := kf.NextIDs(3); !errors.Is(err, errOverTimeLimit) {
+		t.Fatalf("expected errOverTimeLimit, got %v", err)
+	}
+}
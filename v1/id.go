@@ -0,0 +1,361 @@
+package kubeflake
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	internal "github.com/FlorinBalint/kubeflake/internal/kubeflake"
+)
+
+var errInvalidIDValue = errors.New("invalid id value")
+
+// ErrIDOutOfRange is returned when a decoded ID has bits set above the
+// fields of its layout, so it cannot have been generated with that layout.
+var ErrIDOutOfRange = errors.New("id out of range for the layout")
+
+var (
+	_ encoding.TextMarshaler   = ID{}
+	_ encoding.TextAppender    = ID{}
+	_ encoding.TextUnmarshaler = (*ID)(nil)
+	_ json.Marshaler           = ID{}
+	_ json.Unmarshaler         = (*ID)(nil)
+	_ driver.Valuer            = ID{}
+	_ sql.Scanner              = (*ID)(nil)
+)
+
+// Layout describes how a Kubeflake packs the parts of an ID and encodes it
+// as a key. IDs of one Kubeflake can only be decomposed with its Layout.
+// A nil Base or a zero TimeUnit stand for those of DefaultLayout.
+//
+// Order lists the fields from the most to the least significant bits,
+// nil means timestamp|sequence|cluster|machine. The timestamp comes first
+// and takes BitsTime bits; if the fields take fewer than 64 bits, the bits
+// above it stay zero. The cluster ID may be left out, in which case
+// BitsCluster must be 0.
+type Layout struct {
+	BitsTime     int
+	BitsSequence int
+	BitsCluster  int
+	BitsMachine  int
+
+	TimeUnit time.Duration
+	Epoch    time.Time
+	Base     BaseConverter
+	Order    []IdParts
+}
+
+// SnowflakeLayout returns the layout of Twitter Snowflake IDs: 41 bits of
+// milliseconds since the Twitter epoch, a 5 bit datacenter ID (the cluster ID), a 5 bit
+// worker ID (the machine ID) and a 12 bit sequence, in that order. The sign
+// bit of the 63 bit IDs stays clear.
+func SnowflakeLayout() *Layout {
+	return &Layout{
+		BitsTime:     41,
+		BitsSequence: 12,
+		BitsCluster:  5,
+		BitsMachine:  5,
+		TimeUnit:     time.Millisecond,
+		Epoch:        time.UnixMilli(1288834974657).UTC(),
+		Base:         internal.Base62Converter{},
+		Order:        []IdParts{Timestamp, ClusterID, MachineID, Sequence},
+	}
+}
+
+// SonyflakeLayout returns the layout of Sonyflake IDs: 39 bits of 10 msec
+// units since 2014-09-01, an 8 bit sequence and a 16 bit machine ID, in that order.
+// Sonyflake IDs have no cluster ID.
+func SonyflakeLayout() *Layout {
+	return &Layout{
+		BitsTime:     39,
+		BitsSequence: 8,
+		BitsMachine:  16,
+		TimeUnit:     10 * time.Millisecond,
+		Epoch:        time.Date(2014, 9, 1, 0, 0, 0, 0, time.UTC),
+		Base:         internal.Base62Converter{},
+		Order:        []IdParts{Timestamp, Sequence, MachineID},
+	}
+}
+
+var defaultLayout = func() *Layout {
+	s := internal.DefaultSettings()
+	return &Layout{
+		BitsTime:     64 - s.BitsSequence - s.BitsCluster - s.BitsMachine,
+		BitsSequence: s.BitsSequence,
+		BitsCluster:  s.BitsCluster,
+		BitsMachine:  s.BitsMachine,
+		TimeUnit:     s.TimeUnit,
+		Epoch:        s.EpochTime,
+		Base:         s.Base,
+	}
+}()
+
+// DefaultLayout returns the Layout of a Kubeflake created with the default settings.
+func DefaultLayout() *Layout {
+	l := *defaultLayout
+	return &l
+}
+
+// ID returns the id as an ID with this Layout.
+func (l *Layout) ID(id uint64) ID {
+	return ID{value: id, layout: l}
+}
+
+// Parse decodes a base-encoded key into an ID with this Layout.
+// Like DecomposeKey, it rejects non-canonical keys and IDs out of range.
+func (l *Layout) Parse(key string) (ID, error) {
+	id, err := l.base().Decode(key)
+	if err != nil {
+		return ID{}, err
+	}
+	if err := l.check(id); err != nil {
+		return ID{}, err
+	}
+	return l.ID(id), nil
+}
+
+// check fails with ErrIDOutOfRange if id has bits set above the fields of
+// the layout, e.g. for a layout with fewer than 64 bits in total.
+func (l *Layout) check(id uint64) error {
+	used := 0
+	for _, part := range l.order() {
+		used += l.bits(part)
+	}
+	if used < 64 && id>>used != 0 {
+		return fmt.Errorf("%w: %d has more than %d bits", ErrIDOutOfRange, id, used)
+	}
+	return nil
+}
+
+// base returns Base, or the base of DefaultLayout if it is nil.
+func (l *Layout) base() BaseConverter {
+	if l.Base == nil {
+		return defaultLayout.Base
+	}
+	return l.Base
+}
+
+// timeUnit returns TimeUnit, or the time unit of DefaultLayout if it is not positive.
+func (l *Layout) timeUnit() time.Duration {
+	if l.TimeUnit <= 0 {
+		return defaultLayout.TimeUnit
+	}
+	return l.TimeUnit
+}
+
+// epoch returns Epoch, or the epoch of DefaultLayout if it is zero.
+func (l *Layout) epoch() time.Time {
+	if l.Epoch.IsZero() {
+		return defaultLayout.Epoch
+	}
+	return l.Epoch
+}
+
+func (l *Layout) order() []IdParts {
+	if l.Order == nil {
+		return internal.DefaultOrder
+	}
+	return l.Order
+}
+
+func (l *Layout) bits(part IdParts) int {
+	switch part {
+	case Sequence:
+		return l.BitsSequence
+	case ClusterID:
+		return l.BitsCluster
+	case MachineID:
+		return l.BitsMachine
+	default:
+		return l.BitsTime
+	}
+}
+
+// shift returns the position of the lowest bit of part, the fields after it
+// in Order take up the bits below.
+func (l *Layout) shift(part IdParts) int {
+	shift := 0
+	order := l.order()
+	for i := len(order) - 1; i >= 0 && order[i] != part; i-- {
+		shift += l.bits(order[i])
+	}
+	return shift
+}
+
+func (l *Layout) part(id uint64, part IdParts) uint64 {
+	return id >> l.shift(part) & (1<<l.bits(part) - 1)
+}
+
+func (l *Layout) timePart(id uint64) uint64 {
+	return id >> l.shift(Timestamp)
+}
+
+func (l *Layout) sequencePart(id uint64) uint64 {
+	return l.part(id, Sequence)
+}
+
+func (l *Layout) clusterPart(id uint64) uint64 {
+	return l.part(id, ClusterID)
+}
+
+func (l *Layout) machinePart(id uint64) uint64 {
+	return l.part(id, MachineID)
+}
+
+// ID is a Kubeflake ID together with the Layout needed to decompose it.
+//
+// IDs are encoded as their base-encoded key in text and JSON, and as a signed
+// 64 bit integer with the same bits in SQL, which fits a Postgres bigint.
+// The zero ID and IDs decoded into a zero ID use DefaultLayout; to decode
+// with another layout, decode into an ID obtained from Layout.ID.
+type ID struct {
+	value  uint64
+	layout *Layout
+}
+
+// Uint64 returns the ID as uint64, like NextID does.
+func (id ID) Uint64() uint64 {
+	return id.value
+}
+
+// Layout returns the layout of the ID.
+func (id ID) Layout() *Layout {
+	if id.layout == nil {
+		return defaultLayout
+	}
+	return id.layout
+}
+
+// Time returns the time the ID was generated at, truncated to the time unit.
+func (id ID) Time() time.Time {
+	l := id.Layout()
+	unit := l.timeUnit().Nanoseconds()
+	start := l.epoch().UTC().UnixNano() / unit
+	return time.Unix(0, int64(uint64(start)+l.timePart(id.value))*unit).UTC()
+}
+
+// Sequence returns the sequence number of the ID.
+func (id ID) Sequence() int {
+	return int(id.Layout().sequencePart(id.value))
+}
+
+// MachineID returns the machine ID of the ID.
+func (id ID) MachineID() int {
+	return int(id.Layout().machinePart(id.value))
+}
+
+// ClusterID returns the cluster ID of the ID.
+func (id ID) ClusterID() int {
+	return int(id.Layout().clusterPart(id.value))
+}
+
+// String returns the base-encoded key of the ID, like NextKey does.
+func (id ID) String() string {
+	return id.Layout().base().Encode(id.value)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (id ID) MarshalText() ([]byte, error) {
+	return id.AppendText(nil)
+}
+
+// AppendText implements encoding.TextAppender, appending the key to b.
+func (id ID) AppendText(b []byte) ([]byte, error) {
+	return internal.AppendEncode(id.Layout().base(), b, id.value), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (id *ID) UnmarshalText(text []byte) error {
+	parsed, err := id.Layout().Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, encoding the ID as its key.
+func (id ID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(id.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Besides keys, it accepts
+// plain JSON numbers holding the uint64 value. Like the json package does
+// for other types, it leaves the ID unchanged for null.
+func (id *ID) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var key string
+	if err := json.Unmarshal(data, &key); err == nil {
+		return id.UnmarshalText([]byte(key))
+	}
+	var value uint64
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("%w: %s", errInvalidIDValue, data)
+	}
+	return id.set(value)
+}
+
+// Value implements driver.Valuer, storing the ID bits as an int64.
+func (id ID) Value() (driver.Value, error) {
+	return int64(id.value), nil
+}
+
+// Scan implements sql.Scanner. It accepts integers as stored by Value,
+// and keys stored in text columns.
+func (id *ID) Scan(src any) error {
+	switch v := src.(type) {
+	case int64:
+		return id.set(uint64(v))
+	case uint64:
+		return id.set(v)
+	case []byte:
+		return id.UnmarshalText(v)
+	case string:
+		return id.UnmarshalText([]byte(v))
+	default:
+		return fmt.Errorf("%w: cannot scan %T", errInvalidIDValue, src)
+	}
+}
+
+// set sets the ID to value, like the keys decoded by Parse it must fit
+// the layout.
+func (id *ID) set(value uint64) error {
+	l := id.Layout()
+	if err := l.check(value); err != nil {
+		return err
+	}
+	*id = l.ID(value)
+	return nil
+}
+
+// Layout returns a copy of the layout of the IDs generated by kf.
+func (kf *Kubeflake) Layout() *Layout {
+	l := *kf.layout
+	l.Order = append([]IdParts(nil), l.Order...)
+	return &l
+}
+
+// ID returns id as an ID with the layout of kf.
+func (kf *Kubeflake) ID(id uint64) ID {
+	return kf.layout.ID(id)
+}
+
+// ParseID decodes a key generated by kf into an ID.
+func (kf *Kubeflake) ParseID(key string) (ID, error) {
+	return kf.layout.Parse(key)
+}
+
+// Next generates a next unique ID, see NextID.
+func (kf *Kubeflake) Next() (ID, error) {
+	id, err := kf.NextID()
+	if err != nil {
+		return ID{}, err
+	}
+	return kf.ID(id), nil
+}
@@ -0,0 +1,313 @@
+package kubeflake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	internal "github.com/FlorinBalint/kubeflake/internal/kubeflake"
+)
+
+func TestID_Decomposition(t *testing.T) {
+	s := validSettings()
+	kf, err := newWithSettings(context.Background(), s)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	at := s.EpochTime.Add(90 * time.Minute).Truncate(s.TimeUnit)
+	raw, err := kf.Compose(at, 17, 5, 2)
+	if err != nil {
+		t.Fatalf("Compose error: %v", err)
+	}
+
+	id := kf.ID(raw)
+	if !id.Time().Equal(at) {
+		t.Fatalf("want time %v, got %v", at, id.Time())
+	}
+	if id.Sequence() != 17 || id.MachineID() != 5 || id.ClusterID() != 2 {
+		t.Fatalf("want sequence 17, machine 5, cluster 2, got %d, %d, %d", id.Sequence(), id.MachineID(), id.ClusterID())
+	}
+	if id.Layout() != kf.layout || id.Uint64() != raw {
+		t.Fatalf("ID does not carry its value and layout")
+	}
+	parts := kf.Decompose(raw)
+	if parts[Sequence] != uint64(id.Sequence()) || parts[MachineID] != uint64(id.MachineID()) || parts[ClusterID] != uint64(id.ClusterID()) {
+		t.Fatalf("ID disagrees with Decompose: %v", parts)
+	}
+
+	key, _ := kf.ComposeKey(at, 17, 5, 2)
+	if id.String() != key {
+		t.Fatalf("want String %q, got %q", key, id.String())
+	}
+	parsed, err := kf.ParseID(key)
+	if err != nil || parsed != id {
+		t.Fatalf("ParseID(%q) = %v, %v; want %v", key, parsed, err, id)
+	}
+}
+
+func TestID_JSONAndText(t *testing.T) {
+	kf, err := newWithSettings(context.Background(), validSettings())
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	id, err := kf.Next()
+	if err != nil {
+		t.Fatalf("Next error: %v", err)
+	}
+
+	type record struct {
+		ID ID `json:"id"`
+	}
+	data, err := json.Marshal(record{ID: id})
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	if want := `{"id":"` + id.String() + `"}`; string(data) != want {
+		t.Fatalf("want %s, got %s", want, data)
+	}
+
+	got := record{ID: kf.ID(0)}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if got.ID != id {
+		t.Fatalf("JSON round trip: want %v, got %v", id, got.ID)
+	}
+
+	number := record{ID: kf.ID(0)}
+	if err := json.Unmarshal([]byte(`{"id":`+strconv.FormatUint(id.Uint64(), 10)+`}`), &number); err != nil || number.ID != id {
+		t.Fatalf("want %v from a JSON number, got %v (%v)", id, number.ID, err)
+	}
+	if err := json.Unmarshal([]byte(`{"id":true}`), &number); !errors.Is(err, errInvalidIDValue) {
+		t.Fatalf("expected errInvalidIDValue, got %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"id":null}`), &number); err != nil || number.ID != id {
+		t.Fatalf("null should leave %v unchanged, got %v (%v)", id, number.ID, err)
+	}
+
+	var zero ID
+	if err := zero.UnmarshalText([]byte("1BcD")); err != nil {
+		t.Fatalf("UnmarshalText error: %v", err)
+	}
+	if zero.Layout() != defaultLayout {
+		t.Fatalf("a zero ID should decode with the default layout")
+	}
+	if text, _ := zero.MarshalText(); string(text) != "1BcD" {
+		t.Fatalf("text round trip: want 1BcD, got %s", text)
+	}
+}
+
+func TestID_TimeWithZeroEpoch(t *testing.T) {
+	l := DefaultLayout()
+	want := l.ID(1 << l.shift(Timestamp)).Time()
+	l.Epoch = time.Time{}
+	if got := l.ID(1 << l.shift(Timestamp)).Time(); !got.Equal(want) {
+		t.Fatalf("a zero Epoch should use the default epoch: want %v, got %v", want, got)
+	}
+}
+
+func TestID_SQL(t *testing.T) {
+	kf, err := newWithSettings(context.Background(), validSettings())
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+
+	for _, raw := range []uint64{0, 42, 1<<63 + 7} {
+		id := kf.ID(raw)
+		v, err := id.Value()
+		if err != nil {
+			t.Fatalf("Value error: %v", err)
+		}
+		if _, ok := v.(int64); !ok {
+			t.Fatalf("want an int64 column value, got %T", v)
+		}
+
+		scanned := kf.ID(0)
+		if err := scanned.Scan(v); err != nil {
+			t.Fatalf("Scan error: %v", err)
+		}
+		if scanned != id {
+			t.Fatalf("SQL round trip: want %d, got %d", raw, scanned.Uint64())
+		}
+	}
+
+	id := kf.ID(123456789)
+	var scanned ID
+	if err := scanned.Scan([]byte(id.String())); err != nil || scanned.Uint64() != id.Uint64() {
+		t.Fatalf("want %d scanned from a key, got %d (%v)", id.Uint64(), scanned.Uint64(), err)
+	}
+	if err := scanned.Scan(3.14); !errors.Is(err, errInvalidIDValue) {
+		t.Fatalf("expected errInvalidIDValue, got %v", err)
+	}
+}
+
+func TestLayout_FieldOrder(t *testing.T) {
+	at := time.Now().Add(-time.Minute)
+	tests := []struct {
+		name    string
+		layout  *Layout
+		cluster int
+		// want is the ID composed one time unit after the epoch,
+		// with sequence 3, machine 2 and the cluster above.
+		want uint64
+	}{
+		{
+			name:    "snowflake",
+			layout:  SnowflakeLayout(),
+			cluster: 1,
+			want:    1<<22 | 1<<17 | 2<<12 | 3,
+		},
+		{
+			name:    "sonyflake",
+			layout:  SonyflakeLayout(),
+			cluster: 0,
+			want:    1<<24 | 3<<16 | 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := tt.cluster
+			kf, err := New(
+				WithLayout(tt.layout),
+				WithMachineIdFn(func() (int, error) { return 2, nil }),
+				WithClusterIdFn(func() (int, error) { return cluster, nil }),
+			)
+			if err != nil {
+				t.Fatalf("New error: %v", err)
+			}
+			if kf.Layout().BitsTime != tt.layout.BitsTime {
+				t.Fatalf("want %d time bits, got %d", tt.layout.BitsTime, kf.Layout().BitsTime)
+			}
+			limit := tt.layout.Epoch.Add(tt.layout.TimeUnit << tt.layout.BitsTime)
+			if _, err := kf.Compose(limit, 0, 2, cluster); !errors.Is(err, errOverTimeLimit) {
+				t.Fatalf("expected errOverTimeLimit at the %d bit time limit, got %v", tt.layout.BitsTime, err)
+			}
+			maxID, err := kf.Compose(limit.Add(-tt.layout.TimeUnit), 1<<tt.layout.BitsSequence-1, 2, cluster)
+			if err != nil || maxID>>63 != 0 {
+				t.Fatalf("want the last id below the sign bit, got %b (%v)", maxID, err)
+			}
+
+			got, err := kf.Compose(tt.layout.Epoch.Add(tt.layout.TimeUnit), 3, 2, cluster)
+			if err != nil {
+				t.Fatalf("Compose error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %b, got %b", tt.want, got)
+			}
+
+			id, err := kf.Compose(at, 3, 2, cluster)
+			if err != nil {
+				t.Fatalf("Compose error: %v", err)
+			}
+			parts := kf.Decompose(id)
+			if parts[Sequence] != 3 || parts[MachineID] != 2 || parts[ClusterID] != uint64(cluster) {
+				t.Fatalf("Decompose round trip mismatch: %v", parts)
+			}
+			if typed := kf.ID(id); !typed.Time().Equal(at.Truncate(tt.layout.TimeUnit)) {
+				t.Fatalf("want time %v, got %v", at.Truncate(tt.layout.TimeUnit), typed.Time())
+			}
+
+			var last uint64
+			ids, err := kf.NextIDs(3 << tt.layout.BitsSequence)
+			if err != nil {
+				t.Fatalf("NextIDs error: %v", err)
+			}
+			for i, id := range ids {
+				if id <= last {
+					t.Fatalf("ids must increase: last=%d current=%d at i=%d", last, id, i)
+				}
+				last = id
+				if parts := kf.Decompose(id); parts[MachineID] != 2 || parts[ClusterID] != uint64(cluster) {
+					t.Fatalf("NextIDs made an id with wrong fields: %v", parts)
+				}
+			}
+		})
+	}
+}
+
+func TestLayout_PresetsEncodeAndParse(t *testing.T) {
+	layouts := map[string]*Layout{
+		"snowflake": SnowflakeLayout(),
+		"sonyflake": SonyflakeLayout(),
+		// Without a base and time unit, those of DefaultLayout are used.
+		"bare": {BitsTime: 40, BitsSequence: 8, BitsCluster: 3, BitsMachine: 13, Epoch: time.Unix(0, 0)},
+	}
+	for name, l := range layouts {
+		at := l.Epoch.Add(time.Hour)
+		id := l.ID(uint64(time.Hour/l.timeUnit()) << l.shift(Timestamp))
+		if !id.Time().Equal(at) {
+			t.Fatalf("%s: want time %v, got %v", name, at, id.Time())
+		}
+		key := id.String()
+		text, err := id.MarshalText()
+		if err != nil || string(text) != key {
+			t.Fatalf("%s: MarshalText() = %q, %v; want %q", name, text, err, key)
+		}
+		parsed, err := l.Parse(key)
+		if err != nil || parsed != id {
+			t.Fatalf("%s: Parse(%q) = %d, %v; want %d", name, key, parsed.Uint64(), err, id.Uint64())
+		}
+		decoded := l.ID(0)
+		if err := decoded.UnmarshalText([]byte(key)); err != nil || decoded != id {
+			t.Fatalf("%s: UnmarshalText(%q) = %d, %v; want %d", name, key, decoded.Uint64(), err, id.Uint64())
+		}
+		if _, err := l.Parse("abc"); err != nil {
+			t.Fatalf("%s: Parse error: %v", name, err)
+		}
+	}
+}
+
+func TestLayout_ParseRejectsIDsOutOfRange(t *testing.T) {
+	l := SnowflakeLayout()
+	l.Base = internal.Base62Converter{}
+
+	if _, err := l.Parse(l.Base.Encode(1<<63 - 1)); err != nil {
+		t.Fatalf("Parse error for an id of 63 bits: %v", err)
+	}
+	if _, err := l.Parse(l.Base.Encode(1 << 63)); !errors.Is(err, ErrIDOutOfRange) {
+		t.Fatalf("expected ErrIDOutOfRange, got %v", err)
+	}
+
+	// Numbers in JSON and SQL are checked like keys.
+	id := l.ID(0)
+	if err := json.Unmarshal([]byte("9223372036854775808"), &id); !errors.Is(err, ErrIDOutOfRange) {
+		t.Fatalf("expected ErrIDOutOfRange from a JSON number, got %v", err)
+	}
+	for _, src := range []any{int64(-1), uint64(1 << 63)} {
+		if err := id.Scan(src); !errors.Is(err, ErrIDOutOfRange) {
+			t.Fatalf("expected ErrIDOutOfRange scanning %v, got %v", src, err)
+		}
+	}
+	if id.Uint64() != 0 {
+		t.Fatalf("a rejected value must leave the ID alone, got %d", id.Uint64())
+	}
+	if err := id.Scan(int64(1<<63 - 1)); err != nil || id.Uint64() != 1<<63-1 {
+		t.Fatalf("want the id of 63 bits scanned, got %d (%v)", id.Uint64(), err)
+	}
+}
+
+func TestKubeflake_LayoutIsACopy(t *testing.T) {
+	kf, err := newWithSettings(context.Background(), validSettings())
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	id, err := kf.NextID()
+	if err != nil {
+		t.Fatalf("NextID error: %v", err)
+	}
+	want := kf.ID(id).MachineID()
+
+	l := kf.Layout()
+	l.BitsMachine = 3
+	l.Order[len(l.Order)-1] = Sequence
+	if got := kf.ID(id).MachineID(); got != want {
+		t.Fatalf("changing the returned layout changed the IDs of kf: machine %d, want %d", got, want)
+	}
+	if order := kf.Layout().Order; order[len(order)-1] != MachineID {
+		t.Fatalf("changing the returned order changed kf: %v", order)
+	}
+}
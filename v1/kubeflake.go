@@ -1,17 +1,55 @@
 package kubeflake
 
 import (
+	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"errors"
 
 	internal "github.com/FlorinBalint/kubeflake/internal/kubeflake"
+	"github.com/FlorinBalint/kubeflake/pkg/cloud"
 )
 
-type IdParts string
+// IdParts names a field of an ID.
+type IdParts = internal.IdPart
 type settings = internal.Settings
-type baseConverter = internal.BaseConverter
+
+// BaseConverter encodes IDs as keys and decodes them back.
+type BaseConverter = internal.BaseConverter
+
+// AppendEncoder is implemented by the BaseConverters that can append a key
+// to a buffer, which NextKeyAppend and ID.AppendText use to not allocate.
+type AppendEncoder = internal.AppendEncoder
+
+// ObfuscationKey is a versioned secret that IDs are permuted with before
+// they are encoded as keys, see WithObfuscation.
+type ObfuscationKey = internal.ObfuscationKey
+
+// Checksum is an algorithm computing the check character of keys, see WithChecksum.
+type Checksum = internal.Checksum
+
+const (
+	// ChecksumLuhn is the Luhn mod N algorithm, which supports any alphabet.
+	ChecksumLuhn = internal.ChecksumLuhn
+	// ChecksumDamm is the Damm algorithm, which supports alphabets of 10
+	// characters, an odd number of characters or a power of two of them.
+	ChecksumDamm = internal.ChecksumDamm
+)
+
+// ClockRollbackPolicy decides what NextID does when the clock moves backwards.
+type ClockRollbackPolicy = internal.ClockRollbackPolicy
+
+const (
+	// RollbackBorrow keeps issuing IDs ahead of the clock, up to a bound.
+	RollbackBorrow = internal.RollbackBorrow
+	// RollbackWait blocks until the clock catches up, up to a bound.
+	RollbackWait = internal.RollbackWait
+	// RollbackError fails with ErrClockMovedBackwards.
+	RollbackError = internal.RollbackError
+)
 
 const (
 	Timestamp IdParts = "timestamp"
@@ -25,26 +63,96 @@ var (
 	errInvalidMachineID = errors.New("invalid machine id")
 	errInvalidClusterID = errors.New("invalid cluster id")
 	errOverTimeLimit    = errors.New("over the time limit")
+
+	// ErrClockMovedBackwards is returned by NextID when the clock moved
+	// backwards further than the clock rollback policy tolerates.
+	ErrClockMovedBackwards = internal.ErrClockMovedBackwards
+
+	// Errors returned by DecomposeKey and ParseID for keys that Encode would
+	// not have produced: with digits outside the alphabet, of the wrong length
+	// for fixed-width keys, empty, too large for an uint64 or zero-padded.
+	ErrInvalidBase      = internal.ErrInvalidBase
+	ErrInvalidKeyLength = internal.ErrInvalidKeyLength
+	ErrEmptyKey         = internal.ErrEmptyKey
+	ErrKeyOverflow      = internal.ErrKeyOverflow
+	ErrNonCanonicalKey  = internal.ErrNonCanonicalKey
+	// ErrInvalidChecksum is returned for keys whose check character does not match.
+	ErrInvalidChecksum = internal.ErrInvalidChecksum
+	// ErrUnsupportedChecksum is returned by New if the checksum does not
+	// support the alphabet of the keys.
+	ErrUnsupportedChecksum = internal.ErrUnsupportedChecksum
+	// ErrInvalidAlphabet is returned by NewAlphabetConverter.
+	ErrInvalidAlphabet = internal.ErrInvalidAlphabet
+	// ErrInvalidObfuscationKey is returned by New for invalid obfuscation keys.
+	ErrInvalidObfuscationKey = internal.ErrInvalidObfuscationKey
+	// ErrUnknownKeyVersion is returned for obfuscated keys whose version is
+	// not among the obfuscation keys, e.g. after it was rotated out.
+	ErrUnknownKeyVersion = internal.ErrUnknownKeyVersion
 )
 
+// NewAlphabetConverter returns a BaseConverter that encodes IDs as numbers
+// whose digits are the characters of alphabet, in increasing order of value,
+// e.g. to leave out look-alike characters from keys typed by hand. It fails
+// with ErrInvalidAlphabet if alphabet has fewer than 2 characters,
+// duplicates or non-ASCII characters.
+func NewAlphabetConverter(alphabet string) (BaseConverter, error) {
+	return internal.NewAlphabetConverter(alphabet)
+}
+
+// Generator generates and decomposes IDs. Kubeflake implements it in
+// process, and remote clients like rpc.Client implement it on top of an
+// ID service, so that callers can switch between the two.
+type Generator interface {
+	NextID() (uint64, error)
+	NextIDs(n int) ([]uint64, error)
+	NextKey() (string, error)
+	Compose(t time.Time, sequence, machineID, clusterId int) (uint64, error)
+	ComposeKey(t time.Time, sequence, machineID, clusterId int) (string, error)
+	DecomposeKey(key string) (map[IdParts]uint64, error)
+}
+
+var _ Generator = (*Kubeflake)(nil)
+
 type Kubeflake struct {
 	mutex     *sync.Mutex
 	machineId int
 	clusterId int
+	// provider assigned the cluster IDs, DetectProvider if they are not zones
+	provider cloud.Provider
+	// zones maps the cluster IDs back to the zones of provider
+	zones cloud.ClusterIdStrategy
 
 	bitsTime     int
 	bitsCluster  int
 	bitsMachine  int
 	bitsSequence int
 	sequenceMask uint64
+	// sequenceTag is set in the high sequence bits left out of sequenceMask,
+	// see Registry
+	sequenceTag uint64
+
+	shiftSequence int
+	shiftCluster  int
+	shiftMachine  int
 
 	timeUnit    int64
 	startTime   uint64
 	elapsedTime uint64
+	// lastClock is the latest clock reading, in time units since startTime
+	lastClock uint64
+
+	rollbackPolicy ClockRollbackPolicy
+	maxRollback    uint64
 
 	sequence uint64
-	base     baseConverter
-	nowFunc  func() time.Time
+	// lockFree generators keep elapsedTime and sequence packed in state
+	lockFree bool
+	state    atomic.Uint64
+
+	base    BaseConverter
+	layout  *Layout
+	nowFunc func() time.Time
+	lease   internal.MachineLease
 }
 
 // New creates a new Kubeflake with the given options
@@ -60,56 +168,88 @@ type Kubeflake struct {
 // - MachineIdFn: Id of the pod running the Kubeflake instance in a StatefulSet
 // - ClusterIdFn: The ID of the Cloud Availability Zone where the pod is running
 func New(opts ...GeneratorOptions) (*Kubeflake, error) {
+	return NewWithContext(context.Background(), opts...)
+}
+
+// NewWithContext is like New, but the cluster and machine ID lookups
+// (and their retries) are abandoned once ctx is done.
+func NewWithContext(ctx context.Context, opts ...GeneratorOptions) (*Kubeflake, error) {
 	s := internal.DefaultSettings()
 	for _, opt := range opts {
 		opt.apply(&s)
 	}
-	return newWithSettings(s)
+	return newWithSettings(ctx, s)
 }
 
 // New returns a new Kubeflake configured with the given Settings.
 // New returns an error in the following cases:
 // - Settings.BitsSequence is less than 8 or greater than 30.
 // - Settings.BitsMachine is less than 3 or greater than 16.
-// - Settings.BitsCluster is less than 2 or greater than 8.
+// - Settings.BitsCluster is less than 2 or greater than 8, or not 0 without a cluster field.
+// - Settings.Order does not start with the timestamp or lacks the sequence or machine ID.
 // - Settings.BitsCluster + Settings.BitsMachine + Settings.BitsSequence is 35 or more.
+// - Settings.BitsTime is less than 30 or leaves the fields more than 64 bits.
 // - Settings.TimeUnit is less than 1 msec.
 // - Settings.StartTime is ahead of the current time.
 // - Settings.MachineID returns an error.
 // - Settings.ClusterId returns an error.
-func newWithSettings(settings settings) (*Kubeflake, error) {
+// - The cluster ID of the availability zone does not fit BitsCluster, see WithClusterIdStrategy.
+// - ctx is done before the cluster and machine IDs are resolved.
+func newWithSettings(ctx context.Context, settings settings) (*Kubeflake, error) {
 	// Validate settings
 	if err := settings.Validate(); err != nil {
 		return nil, err
 	}
 
+	var err error
 	k8sFlake := new(Kubeflake)
 	k8sFlake.mutex = new(sync.Mutex)
 	k8sFlake.nowFunc = time.Now
-	k8sFlake.base = settings.Base
+	if k8sFlake.base, err = settings.KeyBase(); err != nil {
+		return nil, err
+	}
 	k8sFlake.timeUnit = settings.TimeUnit.Nanoseconds()
 	k8sFlake.startTime = k8sFlake.toInternalTime(settings.EpochTime)
 	k8sFlake.bitsCluster = settings.BitsCluster
 	k8sFlake.bitsMachine = settings.BitsMachine
 	k8sFlake.bitsSequence = settings.BitsSequence
 	k8sFlake.sequenceMask = uint64(1<<k8sFlake.bitsSequence - 1)
-	k8sFlake.bitsTime = 64 - k8sFlake.bitsCluster - k8sFlake.bitsMachine - k8sFlake.bitsSequence
+	k8sFlake.bitsTime = settings.TimeBits()
+	k8sFlake.rollbackPolicy = settings.ClockRollback
+	k8sFlake.maxRollback = uint64(settings.MaxClockRollback.Nanoseconds() / k8sFlake.timeUnit)
+	k8sFlake.lockFree = settings.LockFree
+	k8sFlake.layout = &Layout{
+		BitsTime:     k8sFlake.bitsTime,
+		BitsSequence: k8sFlake.bitsSequence,
+		BitsCluster:  k8sFlake.bitsCluster,
+		BitsMachine:  k8sFlake.bitsMachine,
+		TimeUnit:     settings.TimeUnit,
+		Epoch:        settings.EpochTime,
+		Base:         k8sFlake.base,
+		Order:        append([]IdParts(nil), settings.FieldOrder()...),
+	}
+	k8sFlake.shiftSequence = k8sFlake.layout.shift(Sequence)
+	k8sFlake.shiftCluster = k8sFlake.layout.shift(ClusterID)
+	k8sFlake.shiftMachine = k8sFlake.layout.shift(MachineID)
 
-	if cluster, err := settings.ClusterId(); err != nil {
+	if cluster, provider, err := settings.ResolveClusterId(ctx); err != nil {
 		return nil, err
 	} else if cluster < 0 || cluster >= 1<<k8sFlake.bitsCluster {
 		return nil, errInvalidClusterID
 	} else {
 		k8sFlake.clusterId = cluster
+		k8sFlake.provider = provider
+		k8sFlake.zones = settings.ZoneStrategy()
 	}
 
-	if machine, err := settings.MachineId(); err != nil {
+	if machine, err := settings.ResolveMachineId(ctx); err != nil {
 		return nil, err
 	} else if machine < 0 || machine >= 1<<k8sFlake.bitsMachine {
 		return nil, errInvalidMachineID
 	} else {
 		k8sFlake.machineId = machine
 	}
+	k8sFlake.lease = settings.MachineLease
 
 	return k8sFlake, nil
 }
@@ -137,39 +277,196 @@ func (kf *Kubeflake) NextKey() (string, error) {
 	return kf.base.Encode(id), nil
 }
 
+// NextKeyAppend generates a next unique ID and appends it to dst as a
+// base-encoded key. With a reused dst, it does not allocate.
+func (kf *Kubeflake) NextKeyAppend(dst []byte) ([]byte, error) {
+	id, err := kf.NextID()
+	if err != nil {
+		return dst, err
+	}
+	return internal.AppendEncode(kf.base, dst, id), nil
+}
+
 // NextID generates a next unique ID as uint64.
 // After the Kubeflake time overflows, NextID returns an error.
+// With a machine ID lease, NextID returns an error once the lease is lost.
+// If the clock moves backwards, NextID borrows time, waits or fails with
+// ErrClockMovedBackwards, depending on the clock rollback policy.
 func (kf *Kubeflake) NextID() (uint64, error) {
+	if kf.lease != nil {
+		if err := kf.lease.Err(); err != nil {
+			return 0, err
+		}
+	}
 
-	kf.mutex.Lock()
-	defer kf.mutex.Unlock()
+	if !kf.lockFree {
+		kf.mutex.Lock()
+		defer kf.mutex.Unlock()
+	}
+	id, _, err := kf.reserve(1)
+	return id, err
+}
+
+// reserve claims up to max consecutive sequence numbers within one time unit.
+// It returns the first ID and the number of sequence numbers claimed.
+// Unless the generator is lock-free, the caller must hold the mutex.
+func (kf *Kubeflake) reserve(max uint64) (uint64, uint64, error) {
+	if kf.lockFree {
+		return kf.reserveLockFree(max)
+	}
 
+	current, err := kf.clock()
+	if err != nil {
+		return 0, 0, err
+	}
+	last := kf.elapsedTime
+	kf.elapsedTime, kf.sequence = kf.nextState(kf.elapsedTime, kf.sequence, current)
+	if wait := kf.overBorrow(last, kf.elapsedTime, current); wait > 0 {
+		kf.sleep(int64(wait))
+	}
+	first, err := kf.toID(kf.elapsedTime, kf.sequence)
+	if err != nil {
+		return 0, 0, err
+	}
+	count := min(max, kf.sequenceMask-kf.sequence+1)
+	kf.sequence += count - 1
+	return first, count, nil
+}
+
+// reserveLockFree is reserve for the lock-free generator: the elapsed time
+// and the sequence are packed into state and advanced with compare-and-swap.
+// The state is read before the clock, so a clock reading is never older than
+// the state it is compared with, unless the clock moved backwards.
+func (kf *Kubeflake) reserveLockFree(max uint64) (uint64, uint64, error) {
+	for {
+		old := kf.state.Load()
+		elapsed, sequence := old>>kf.bitsSequence, old&kf.sequenceMask
+		current := kf.currentElapsedTime()
+		if current < elapsed {
+			wait, err := kf.onRollback(elapsed-current, elapsed-current)
+			if err != nil {
+				return 0, 0, err
+			}
+			if wait > 0 {
+				kf.sleep(int64(wait))
+				continue
+			}
+		}
+
+		last := elapsed
+		elapsed, sequence = kf.nextState(elapsed, sequence, current)
+		if wait := kf.overBorrow(last, elapsed, current); wait > 0 {
+			kf.sleep(int64(wait))
+		}
+		first, err := kf.toID(elapsed, sequence)
+		if err != nil {
+			return 0, 0, err
+		}
+		count := min(max, kf.sequenceMask-sequence+1)
+		if kf.state.CompareAndSwap(old, elapsed<<kf.bitsSequence|(sequence+count-1)) {
+			return first, count, nil
+		}
+	}
+}
+
+// nextState returns the elapsed time and sequence number of the ID following
+// the given one, for the given clock reading: the sequence starts over when
+// the clock moved on, and moves to the next time unit once it runs out.
+func (kf *Kubeflake) nextState(elapsed, sequence, current uint64) (uint64, uint64) {
+	if elapsed < current {
+		return current, 0
+	}
+	sequence = (sequence + 1) & kf.sequenceMask
+	if sequence == 0 {
+		elapsed++
+	}
+	return elapsed, sequence
+}
+
+// overBorrow returns how many time units to sleep before an ID with the
+// given elapsed time may be issued at the given clock reading. IDs only run
+// ahead of the clock while the last one already was, i.e. after the clock
+// moved backwards; a plain sequence overflow sleeps until the next time unit.
+func (kf *Kubeflake) overBorrow(last, elapsed, current uint64) uint64 {
+	if elapsed <= current {
+		return 0
+	}
+	var borrow uint64
+	if last > current {
+		borrow = kf.maxBorrow()
+	}
+	if elapsed-current <= borrow {
+		return 0
+	}
+	return elapsed - current - borrow
+}
+
+// clock reads the current elapsed time, applying the rollback policy if the
+// clock moved backwards since the last reading.
+func (kf *Kubeflake) clock() (uint64, error) {
 	current := kf.currentElapsedTime()
-	if kf.elapsedTime < current {
-		kf.elapsedTime = current
-		kf.sequence = 0
-	} else {
-		kf.sequence = (kf.sequence + 1) & kf.sequenceMask
-		if kf.sequence == 0 {
-			kf.elapsedTime++
-			overtime := kf.elapsedTime - current
-			kf.sleep(int64(overtime))
+	for current < kf.lastClock {
+		wait, err := kf.onRollback(kf.lastClock-current, kf.elapsedTime-current)
+		if err != nil {
+			return 0, err
+		}
+		if wait == 0 {
+			break
+		}
+		kf.sleep(int64(wait))
+		current = kf.currentElapsedTime()
+	}
+	kf.lastClock = current
+	return current, nil
+}
+
+// onRollback applies the rollback policy to a clock that is behind the last
+// reading by behind time units, while the last ID is ahead of it by ahead
+// time units. It returns how many time units to wait for the clock.
+func (kf *Kubeflake) onRollback(behind, ahead uint64) (uint64, error) {
+	switch kf.rollbackPolicy {
+	case RollbackBorrow:
+		if ahead > kf.maxRollback {
+			return 0, kf.rollbackError(ahead)
+		}
+		return 0, nil
+	case RollbackWait:
+		if behind > kf.maxRollback {
+			return 0, kf.rollbackError(behind)
 		}
+		return behind, nil
+	default:
+		return 0, kf.rollbackError(behind)
 	}
+}
 
-	return kf.toID()
+func (kf *Kubeflake) rollbackError(units uint64) error {
+	return fmt.Errorf("%w by %v", ErrClockMovedBackwards, time.Duration(int64(units)*kf.timeUnit))
 }
 
-func (kf *Kubeflake) toID() (uint64, error) {
-	if kf.elapsedTime >= 1<<kf.bitsTime {
+// maxBorrow returns how many time units the IDs may run ahead of the clock
+// after it moved backwards, before NextID sleeps on sequence overflow.
+func (kf *Kubeflake) maxBorrow() uint64 {
+	if kf.rollbackPolicy == RollbackBorrow {
+		return kf.maxRollback
+	}
+	return 0
+}
+
+func (kf *Kubeflake) toID(elapsed, sequence uint64) (uint64, error) {
+	if elapsed >= 1<<kf.bitsTime {
 		return 0, errOverTimeLimit
 	}
 
-	res := kf.elapsedTime << (kf.bitsSequence + kf.bitsCluster + kf.bitsMachine)
-	res |= uint64(kf.sequence) << (kf.bitsMachine + kf.bitsCluster)
-	res |= uint64(kf.clusterId) << kf.bitsMachine
-	res |= uint64(kf.machineId)
-	return res, nil
+	return kf.pack(elapsed, kf.sequenceTag|sequence, uint64(kf.clusterId), uint64(kf.machineId)), nil
+}
+
+// pack places the ID fields at their position in the layout.
+func (kf *Kubeflake) pack(elapsed, sequence, clusterId, machineId uint64) uint64 {
+	return elapsed<<(kf.bitsSequence+kf.bitsCluster+kf.bitsMachine) |
+		sequence<<kf.shiftSequence |
+		clusterId<<kf.shiftCluster |
+		machineId<<kf.shiftMachine
 }
 
 func (kf *Kubeflake) ComposeKey(t time.Time, sequence, machineID, clusterId int) (string, error) {
@@ -202,17 +499,20 @@ func (kf *Kubeflake) Compose(t time.Time, sequence, machineID, clusterId int) (u
 		return 0, errInvalidMachineID
 	}
 
-	return elapsedTime<<(kf.bitsSequence+kf.bitsMachine+kf.bitsCluster) |
-		uint64(sequence)<<(kf.bitsMachine+kf.bitsCluster) |
-		uint64(clusterId)<<kf.bitsMachine |
-		uint64(machineID), nil
+	return kf.pack(elapsedTime, uint64(sequence), uint64(clusterId), uint64(machineID)), nil
 }
 
+// DecomposeKey decodes a key generated by kf and splits it into its parts.
+// It fails for keys that are not canonical for the base of kf, see
+// ErrInvalidBase, and with ErrIDOutOfRange for IDs that do not fit the layout.
 func (kf *Kubeflake) DecomposeKey(key string) (map[IdParts]uint64, error) {
 	id, err := kf.base.Decode(key)
 	if err != nil {
 		return nil, err
 	}
+	if err := kf.layout.check(id); err != nil {
+		return nil, err
+	}
 	return kf.Decompose(id), nil
 }
 
@@ -225,21 +525,29 @@ func (kf *Kubeflake) Decompose(id uint64) map[IdParts]uint64 {
 	}
 }
 
+// Location returns the availability zone, or AWS region, of the cluster ID
+// of id, e.g. "europe-west1-b", and whether it is known. It is known only
+// if kf looked its own cluster ID up in the cloud, see WithCloudProvider,
+// and then names the cluster IDs of the other clusters in the same cloud.
+func (kf *Kubeflake) Location(id uint64) (string, bool) {
+	if kf.provider == cloud.DetectProvider {
+		return "", false
+	}
+	return kf.zones.Zone(kf.provider, int(kf.clusterPart(id)))
+}
+
 func (kf *Kubeflake) timePart(id uint64) uint64 {
-	return uint64(id >> (kf.bitsSequence + kf.bitsCluster + kf.bitsMachine))
+	return kf.layout.timePart(id)
 }
 
 func (kf *Kubeflake) sequencePart(id uint64) uint64 {
-	maskSequence := (1<<kf.bitsSequence - 1) << (kf.bitsMachine + kf.bitsCluster)
-	return (id & uint64(maskSequence)) >> (kf.bitsMachine + kf.bitsCluster)
+	return kf.layout.sequencePart(id)
 }
 
 func (kf *Kubeflake) clusterPart(id uint64) uint64 {
-	maskCluster := (1<<kf.bitsCluster - 1) << kf.bitsMachine
-	return (id & uint64(maskCluster)) >> kf.bitsMachine
+	return kf.layout.clusterPart(id)
 }
 
 func (kf *Kubeflake) machinePart(id uint64) uint64 {
-	maskMachine := uint64(1<<kf.bitsMachine - 1)
-	return id & maskMachine
+	return kf.layout.machinePart(id)
 }
@@ -1,23 +1,32 @@
 package kubeflake
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	internalcloud "github.com/FlorinBalint/kubeflake/internal/cloud"
 	internal "github.com/FlorinBalint/kubeflake/internal/kubeflake"
+	"github.com/FlorinBalint/kubeflake/pkg/cloud"
+	"github.com/FlorinBalint/kubeflake/pkg/kubernetes"
 )
 
 func validSettings() settings {
 	settings := internal.DefaultSettings()
 	settings.TimeUnit = time.Millisecond
 	settings.EpochTime = time.Now().Add(-24 * time.Hour)
-	settings.ClusterId = func() (int, error) {
+	settings.ClusterId = func(context.Context) (int, error) {
 		return 2, nil
 	}
-	settings.MachineId = func() (int, error) {
+	settings.MachineId = func(context.Context) (int, error) {
 		return 5, nil
 	}
 	return settings
@@ -32,6 +41,7 @@ type stepClock struct {
 func newStepClock(start time.Time, step time.Duration) *stepClock {
 	return &stepClock{now: start, step: step}
 }
+
 func (c *stepClock) Now() time.Time {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -39,6 +49,13 @@ func (c *stepClock) Now() time.Time {
 	return c.now
 }
 
+// rewind steps the clock back by d, like an NTP correction would.
+func (c *stepClock) rewind(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(-d)
+}
+
 func TestNew_ValidationErrors(t *testing.T) {
 	errDummy := errors.New("provider error")
 	now := time.Now()
@@ -96,6 +113,46 @@ func TestNew_ValidationErrors(t *testing.T) {
 			},
 			wantErr: internal.ErrInvalidBitsClusterID,
 		},
+		{
+			name: "cluster bits without cluster field",
+			mutate: func(s settings) settings {
+				s.Order = []IdParts{Timestamp, Sequence, MachineID}
+				return s
+			},
+			wantErr: internal.ErrInvalidBitsClusterID,
+		},
+		{
+			name: "order without leading timestamp",
+			mutate: func(s settings) settings {
+				s.Order = []IdParts{Sequence, Timestamp, ClusterID, MachineID}
+				return s
+			},
+			wantErr: internal.ErrInvalidOrder,
+		},
+		{
+			name: "order with duplicate field",
+			mutate: func(s settings) settings {
+				s.Order = []IdParts{Timestamp, Sequence, MachineID, Sequence}
+				return s
+			},
+			wantErr: internal.ErrInvalidOrder,
+		},
+		{
+			name: "order without machine id",
+			mutate: func(s settings) settings {
+				s.Order = []IdParts{Timestamp, Sequence, ClusterID}
+				return s
+			},
+			wantErr: internal.ErrInvalidOrder,
+		},
+		{
+			name: "order with unknown field",
+			mutate: func(s settings) settings {
+				s.Order = []IdParts{Timestamp, Sequence, ClusterID, "shard"}
+				return s
+			},
+			wantErr: internal.ErrInvalidOrder,
+		},
 		{
 			name: "time unit negative",
 			mutate: func(s settings) settings {
@@ -131,10 +188,26 @@ func TestNew_ValidationErrors(t *testing.T) {
 			},
 			wantErr: internal.ErrInvalidBitsTime,
 		},
+		{
+			name: "time bits too many for the other fields",
+			mutate: func(s settings) settings {
+				s.BitsTime = 64 - s.BitsSequence - s.BitsMachine - s.BitsCluster + 1
+				return s
+			},
+			wantErr: internal.ErrInvalidBitsTime,
+		},
+		{
+			name: "time bits set too small",
+			mutate: func(s settings) settings {
+				s.BitsTime = internal.MinTimeBits - 1
+				return s
+			},
+			wantErr: internal.ErrInvalidBitsTime,
+		},
 		{
 			name: "cluster id provider error",
 			mutate: func(s settings) settings {
-				s.ClusterId = func() (int, error) { return 0, errDummy }
+				s.ClusterId = func(context.Context) (int, error) { return 0, errDummy }
 				return s
 			},
 			wantErr: errDummy,
@@ -142,7 +215,7 @@ func TestNew_ValidationErrors(t *testing.T) {
 		{
 			name: "machine id provider error",
 			mutate: func(s settings) settings {
-				s.MachineId = func() (int, error) { return 0, errDummy }
+				s.MachineId = func(context.Context) (int, error) { return 0, errDummy }
 				return s
 			},
 			wantErr: errDummy,
@@ -154,7 +227,7 @@ func TestNew_ValidationErrors(t *testing.T) {
 		if tt.mutate != nil {
 			s = tt.mutate(s)
 		}
-		_, err := newWithSettings(s)
+		_, err := newWithSettings(context.Background(), s)
 		if tt.wantErr == nil && err != nil {
 			t.Fatalf("%s: unexpected error: %v", tt.name, err)
 		}
@@ -173,10 +246,10 @@ func TestNew_ProviderValuesAreStored(t *testing.T) {
 	s := validSettings()
 	wantCluster := 3
 	wantMachine := 7
-	s.ClusterId = func() (int, error) { return wantCluster, nil }
-	s.MachineId = func() (int, error) { return wantMachine, nil }
+	s.ClusterId = func(context.Context) (int, error) { return wantCluster, nil }
+	s.MachineId = func(context.Context) (int, error) { return wantMachine, nil }
 
-	kf, err := newWithSettings(s)
+	kf, err := newWithSettings(context.Background(), s)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -190,7 +263,7 @@ func TestNew_ProviderValuesAreStored(t *testing.T) {
 
 func TestNextID_MonotonicSequential(t *testing.T) {
 	s := validSettings()
-	kf, err := newWithSettings(s)
+	kf, err := newWithSettings(context.Background(), s)
 	if err != nil {
 		t.Fatalf("New error: %v", err)
 	}
@@ -214,7 +287,7 @@ func TestNextID_MonotonicSequential(t *testing.T) {
 
 func TestNextID_MonotonicParallel(t *testing.T) {
 	s := validSettings()
-	kf, err := newWithSettings(s)
+	kf, err := newWithSettings(context.Background(), s)
 	if err != nil {
 		t.Fatalf("New error: %v", err)
 	}
@@ -260,7 +333,7 @@ func TestNextID_MonotonicParallel(t *testing.T) {
 
 func TestNextKey_MonotonicAndDecodable(t *testing.T) {
 	s := validSettings()
-	kf, err := newWithSettings(s)
+	kf, err := newWithSettings(context.Background(), s)
 	if err != nil {
 		t.Fatalf("New error: %v", err)
 	}
@@ -287,7 +360,7 @@ func TestNextKey_MonotonicAndDecodable(t *testing.T) {
 
 func TestComposeDecompose_RoundTrip(t *testing.T) {
 	s := validSettings()
-	kf, err := newWithSettings(s)
+	kf, err := newWithSettings(context.Background(), s)
 	if err != nil {
 		t.Fatalf("New error: %v", err)
 	}
@@ -321,7 +394,7 @@ func TestComposeDecompose_RoundTrip(t *testing.T) {
 
 func TestComposeKeyDecomposeKey_RoundTrip(t *testing.T) {
 	s := validSettings()
-	kf, err := newWithSettings(s)
+	kf, err := newWithSettings(context.Background(), s)
 	if err != nil {
 		t.Fatalf("New error: %v", err)
 	}
@@ -358,7 +431,7 @@ func TestComposeKeyDecomposeKey_RoundTrip(t *testing.T) {
 
 func TestCompose_Errors(t *testing.T) {
 	s := validSettings()
-	kf, err := newWithSettings(s)
+	kf, err := newWithSettings(context.Background(), s)
 	if err != nil {
 		t.Fatalf("New error: %v", err)
 	}
@@ -462,7 +535,7 @@ func TestCompose_Errors(t *testing.T) {
 
 func TestDecomposeKey_InvalidBase(t *testing.T) {
 	s := validSettings()
-	kf, err := newWithSettings(s)
+	kf, err := newWithSettings(context.Background(), s)
 	if err != nil {
 		t.Fatalf("New error: %v", err)
 	}
@@ -489,3 +562,784 @@ func TestBase62_EncodeDecode_RoundTrip(t *testing.T) {
 		}
 	}
 }
+
+func TestNew_ResolveRetriesWithBackoff(t *testing.T) {
+	s := validSettings()
+	s.ResolveRetries = 3
+	s.ResolveBackoff = time.Millisecond
+	calls := 0
+	s.ClusterId = func(context.Context) (int, error) {
+		calls++
+		if calls < 3 {
+			return 0, errors.New("metadata server not ready")
+		}
+		return 4, nil
+	}
+
+	kf, err := newWithSettings(context.Background(), s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 || kf.clusterId != 4 {
+		t.Fatalf("want cluster 4 after 3 calls, got cluster %d after %d calls", kf.clusterId, calls)
+	}
+
+	errDummy := errors.New("still failing")
+	calls = 0
+	s.ClusterId = func(context.Context) (int, error) {
+		calls++
+		return 0, errDummy
+	}
+	if _, err := newWithSettings(context.Background(), s); !errors.Is(err, errDummy) {
+		t.Fatalf("expected %v once retries are used up, got %v", errDummy, err)
+	}
+	if calls != 4 {
+		t.Fatalf("want 1 call and 3 retries, got %d calls", calls)
+	}
+}
+
+func TestNew_ResolveTimeoutBoundsEachAttempt(t *testing.T) {
+	s := validSettings()
+	s.ResolveTimeout = 10 * time.Millisecond
+	s.MachineId = func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+
+	start := time.Now()
+	_, err := newWithSettings(context.Background(), s)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("resolution took %v, expected the timeout to cut it short", elapsed)
+	}
+}
+
+func TestNewWithContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewWithContext(ctx,
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithMachineIdFn(func() (int, error) { return 1, nil }),
+		WithClusterIdContextFn(func(ctx context.Context) (int, error) { return 0, ctx.Err() }),
+		WithResolveRetries(5, time.Hour),
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestNew_CloudResolverIsUsedForClusterId(t *testing.T) {
+	t.Setenv("GCP_ZONE", "")
+	t.Setenv("ZONE", "")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("projects/123/zones/us-central1-a"))
+	}))
+	defer srv.Close()
+
+	kf, err := New(
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithMachineIdFn(func() (int, error) { return 1, nil }),
+		WithCloudProvider(cloud.GCPProvider),
+		WithCloudResolver(&cloud.Resolver{GCPMetadataURL: srv.URL}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, _ := internalcloud.GCPZoneIndex("us-central1-a"); kf.clusterId != want {
+		t.Fatalf("want cluster %d, got %d", want, kf.clusterId)
+	}
+	id, err := kf.NextID()
+	if err != nil {
+		t.Fatalf("NextID() error: %v", err)
+	}
+	if zone, ok := kf.Location(id); !ok || zone != "us-central1-a" {
+		t.Fatalf("want the location us-central1-a, got %q", zone)
+	}
+}
+
+func TestNew_ClusterIdFnWinsOverZoneLookupOptions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected metadata request %s", r.URL)
+	}))
+	defer srv.Close()
+	fn := WithClusterIdFn(func() (int, error) { return 6, nil })
+	lookup := []GeneratorOptions{
+		WithCloudProvider(cloud.GCPProvider),
+		WithClusterIdStrategy(cloud.RegionStrategy()),
+		WithCloudResolver(&cloud.Resolver{GCPMetadataURL: srv.URL}),
+		WithHTTPClient(srv.Client()),
+	}
+	orders := map[string][]GeneratorOptions{
+		"fn first": append([]GeneratorOptions{fn}, lookup...),
+		"fn last":  append(append([]GeneratorOptions(nil), lookup...), fn),
+	}
+	for name, opts := range orders {
+		opts = append(opts, WithEpoch(time.Now().Add(-time.Hour)), WithMachineIdFn(func() (int, error) { return 1, nil }))
+		kf, err := New(opts...)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if kf.clusterId != 6 {
+			t.Fatalf("%s: want the cluster 6 of the function, got %d", name, kf.clusterId)
+		}
+	}
+}
+
+func TestNew_ClusterIdFileUsesTheCloudSettings(t *testing.T) {
+	t.Setenv("CLUSTER_NAME", "")
+	t.Setenv("GCP_ZONE", "")
+	t.Setenv("ZONE", "")
+	var lookups int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		w.Write([]byte("projects/123/zones/us-central1-a"))
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "clusters.yaml")
+	if err := os.WriteFile(path, []byte("prod-eu: 0\nus-central1-a: 9\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := []GeneratorOptions{
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithMachineIdFn(func() (int, error) { return 1, nil }),
+		WithCloudProvider(cloud.GCPProvider),
+		WithCloudResolver(&cloud.Resolver{GCPMetadataURL: srv.URL}),
+		WithClusterIdFile(path),
+	}
+
+	// The mapping is checked against the cluster bits of the generator.
+	if _, err := New(opts...); !errors.Is(err, kubernetes.ErrInvalidClusterIds) {
+		t.Fatalf("want ErrInvalidClusterIds for 3 cluster bits, got %v", err)
+	}
+	kf, err := New(append(opts, WithClusterBits(4))...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if kf.clusterId != 9 || lookups != 1 {
+		t.Fatalf("want cluster 9 after one zone lookup, got %d after %d", kf.clusterId, lookups)
+	}
+	id, _ := kf.NextID()
+	if zone, ok := kf.Location(id); ok {
+		t.Fatalf("want no location for a mapped cluster ID, got %q", zone)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewWithContext(ctx, append(opts, WithClusterBits(4))...); err == nil || lookups != 1 {
+		t.Fatalf("want the zone lookup to honor the context, got %v after %d lookups", err, lookups)
+	}
+}
+
+func TestLocation_OnlyForCloudClusterIds(t *testing.T) {
+	t.Setenv("CLOUD_PROVIDER", "gcp")
+	t.Setenv("GCP_ZONE", "africa-south1-a")
+	detected, err := New(
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithMachineIdFn(func() (int, error) { return 1, nil }),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, _ := detected.Compose(time.Now(), 0, 0, 1)
+	want, _ := cloud.ZoneForIndex(cloud.GCPProvider, 1)
+	if zone, ok := detected.Location(other); !ok || zone != want {
+		t.Fatalf("want the location %q of cluster 1, got %q", want, zone)
+	}
+
+	fixed, err := New(
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithMachineIdFn(func() (int, error) { return 1, nil }),
+		WithClusterIdFn(func() (int, error) { return 1, nil }),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if zone, ok := fixed.Location(other); ok {
+		t.Fatalf("want no location for a fixed cluster ID, got %q", zone)
+	}
+}
+
+func TestNew_ClusterIdStrategyFitsZonesBeyondTheClusterBits(t *testing.T) {
+	zone, _ := cloud.ZoneForIndex(cloud.GCPProvider, 20)
+	t.Setenv("GCP_ZONE", zone)
+	opts := []GeneratorOptions{
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithMachineIdFn(func() (int, error) { return 1, nil }),
+		WithCloudProvider(cloud.GCPProvider),
+	}
+
+	_, err := New(opts...)
+	if !errors.Is(err, cloud.ErrClusterIdOverflow) || !strings.Contains(err.Error(), zone) ||
+		!strings.Contains(err.Error(), "needs 5 cluster bits") {
+		t.Fatalf("want an overflow naming %s and 5 bits, got %v", zone, err)
+	}
+
+	kf, err := New(append(opts, WithClusterIdStrategy(cloud.TopZonesStrategy("us-east1-b", zone)))...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if kf.clusterId != 1 {
+		t.Fatalf("want cluster 1, got %d", kf.clusterId)
+	}
+	id, err := kf.NextID()
+	if err != nil {
+		t.Fatalf("NextID() error: %v", err)
+	}
+	if got, ok := kf.Location(id); !ok || got != zone {
+		t.Fatalf("want the location %s, got %q", zone, got)
+	}
+	if got, ok := kf.derive().Location(id); !ok || got != zone {
+		t.Fatalf("want derived generators to name %s, got %q", zone, got)
+	}
+}
+
+type stubLease struct {
+	id  int
+	n   int
+	err error
+}
+
+func (l *stubLease) Acquire(_ context.Context, n int) (int, error) {
+	l.n = n
+	return l.id, nil
+}
+
+func (l *stubLease) Err() error {
+	return l.err
+}
+
+func TestNextID_StopsOnceLeaseIsLost(t *testing.T) {
+	s := validSettings()
+	lease := &stubLease{id: 9}
+	s.MachineLease = lease
+
+	kf, err := newWithSettings(context.Background(), s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if kf.machineId != 9 || lease.n != 1<<s.BitsMachine {
+		t.Fatalf("want machine 9 leased out of %d, got %d out of %d", 1<<s.BitsMachine, kf.machineId, lease.n)
+	}
+	if _, err := kf.NextID(); err != nil {
+		t.Fatalf("unexpected error while the lease is held: %v", err)
+	}
+
+	errLost := errors.New("lease lost")
+	lease.err = errLost
+	if _, err := kf.NextID(); !errors.Is(err, errLost) {
+		t.Fatalf("expected %v once the lease is lost, got %v", errLost, err)
+	}
+	if _, err := kf.NextKey(); !errors.Is(err, errLost) {
+		t.Fatalf("expected NextKey to fail with %v, got %v", errLost, err)
+	}
+}
+
+func TestWithMachineLease_IgnoresNil(t *testing.T) {
+	kf, err := New(
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithMachineIdFn(func() (int, error) { return 6, nil }),
+		WithClusterIdFn(func() (int, error) { return 1, nil }),
+		WithMachineLease(nil),
+	)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	if kf.lease != nil || kf.machineId != 6 {
+		t.Fatalf("want machine 6 without a lease, got %d (lease %v)", kf.machineId, kf.lease)
+	}
+	if _, err := kf.NextID(); err != nil {
+		t.Fatalf("NextID error: %v", err)
+	}
+}
+
+// rollbackFlake returns a Kubeflake with 1 msec time unit and the given
+// rollback policy, and the clock driving it, which advances 1 msec per reading.
+func rollbackFlake(t *testing.T, policy ClockRollbackPolicy, max time.Duration) (*Kubeflake, *stepClock) {
+	t.Helper()
+	s := validSettings()
+	s.ClockRollback = policy
+	s.MaxClockRollback = max
+	kf, err := newWithSettings(context.Background(), s)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	clk := newStepClock(s.EpochTime.Add(time.Hour), time.Millisecond)
+	kf.nowFunc = clk.Now
+	return kf, clk
+}
+
+func TestNextID_ClockRollbackError(t *testing.T) {
+	kf, clk := rollbackFlake(t, RollbackError, time.Second)
+	if _, err := kf.NextID(); err != nil {
+		t.Fatalf("NextID error: %v", err)
+	}
+
+	clk.rewind(5 * time.Millisecond)
+	if _, err := kf.NextID(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Fatalf("expected ErrClockMovedBackwards, got %v", err)
+	}
+}
+
+func TestNextID_ClockRollbackWait(t *testing.T) {
+	kf, clk := rollbackFlake(t, RollbackWait, 10*time.Millisecond)
+	last, err := kf.NextID()
+	if err != nil {
+		t.Fatalf("NextID error: %v", err)
+	}
+
+	// Every reading advances the clock, so waiting lets it catch up.
+	clk.rewind(5 * time.Millisecond)
+	id, err := kf.NextID()
+	if err != nil {
+		t.Fatalf("expected NextID to wait for the clock, got %v", err)
+	}
+	if id <= last {
+		t.Fatalf("ids must increase across a rollback: last=%d current=%d", last, id)
+	}
+	if kf.timePart(id) < kf.timePart(last) {
+		t.Fatalf("timestamp went backwards: last=%d current=%d", kf.timePart(last), kf.timePart(id))
+	}
+
+	clk.rewind(time.Second)
+	if _, err := kf.NextID(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Fatalf("expected ErrClockMovedBackwards beyond the wait bound, got %v", err)
+	}
+}
+
+func TestNextID_ClockRollbackBorrow(t *testing.T) {
+	kf, clk := rollbackFlake(t, RollbackBorrow, 20*time.Millisecond)
+	last, err := kf.NextID()
+	if err != nil {
+		t.Fatalf("NextID error: %v", err)
+	}
+	lastTime := kf.timePart(last)
+
+	// Borrowing keeps issuing increasing IDs on the old timestamp and beyond,
+	// without waiting for the clock, even across a sequence overflow.
+	clk.rewind(10 * time.Millisecond)
+	clk.step = 0
+	start := time.Now()
+	for i := 0; i < 2<<validSettings().BitsSequence; i++ {
+		id, err := kf.NextID()
+		if err != nil {
+			t.Fatalf("NextID error at i=%d: %v", i, err)
+		}
+		if id <= last {
+			t.Fatalf("ids must increase: last=%d current=%d at i=%d", last, id, i)
+		}
+		last = id
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("borrowing took %v, expected no sleeping", elapsed)
+	}
+	if kf.timePart(last) <= lastTime {
+		t.Fatalf("expected future time to be borrowed, timestamp stayed at %d", lastTime)
+	}
+
+	clk.rewind(time.Second)
+	if _, err := kf.NextID(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Fatalf("expected ErrClockMovedBackwards beyond the borrow cap, got %v", err)
+	}
+}
+
+func TestNextID_NeverAheadOfTheClockWithoutRollback(t *testing.T) {
+	for _, lockFree := range []bool{false, true} {
+		s := validSettings()
+		s.BitsSequence = internal.MinSequenceBits
+		s.LockFree = lockFree
+		kf, err := newWithSettings(context.Background(), s)
+		if err != nil {
+			t.Fatalf("New error: %v", err)
+		}
+
+		// Sequence overflows sleep until the next time unit instead of
+		// borrowing it, by default too.
+		for i := 0; i < 8<<s.BitsSequence; i++ {
+			id, err := kf.NextID()
+			if err != nil {
+				t.Fatalf("NextID error: %v", err)
+			}
+			if now := kf.nowFunc(); kf.ID(id).Time().After(now) {
+				t.Fatalf("lock-free=%v: id %d of %v is ahead of the clock at %v", lockFree, i, kf.ID(id).Time(), now)
+			}
+		}
+		ids, err := kf.NextIDs(8 << s.BitsSequence)
+		if err != nil {
+			t.Fatalf("NextIDs error: %v", err)
+		}
+		if last, now := kf.ID(ids[len(ids)-1]).Time(), kf.nowFunc(); last.After(now) {
+			t.Fatalf("lock-free=%v: NextIDs made an id of %v, ahead of the clock at %v", lockFree, last, now)
+		}
+	}
+}
+
+func TestNew_InvalidClockRollback(t *testing.T) {
+	for _, opt := range []GeneratorOptions{
+		WithClockRollbackPolicy(RollbackWait, -time.Second),
+		WithClockRollbackPolicy(ClockRollbackPolicy(42), time.Second),
+	} {
+		_, err := New(
+			WithEpoch(time.Now().Add(-time.Hour)),
+			WithMachineIdFn(func() (int, error) { return 1, nil }),
+			WithClusterIdFn(func() (int, error) { return 1, nil }),
+			opt,
+		)
+		if !errors.Is(err, internal.ErrInvalidClockRollback) {
+			t.Fatalf("expected ErrInvalidClockRollback, got %v", err)
+		}
+	}
+}
+
+func TestNextID_LockFreeMonotonicParallel(t *testing.T) {
+	s := validSettings()
+	s.LockFree = true
+	kf, err := newWithSettings(context.Background(), s)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	clk := newStepClock(s.EpochTime.Add(5*time.Second), 0)
+	kf.nowFunc = clk.Now
+
+	const goroutines = 8
+	const perG = 500
+	results := make([][]uint64, goroutines)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perG; i++ {
+				id, err := kf.NextID()
+				if err != nil {
+					t.Errorf("NextID error: %v", err)
+					return
+				}
+				// Every goroutine must see its own IDs increase.
+				if i > 0 && id <= results[g][i-1] {
+					t.Errorf("ids must increase per goroutine: last=%d current=%d", results[g][i-1], id)
+					return
+				}
+				results[g] = append(results[g], id)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]bool, goroutines*perG)
+	for _, ids := range results {
+		for _, id := range ids {
+			if seen[id] {
+				t.Fatalf("duplicate id %d", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestNextID_LockFreeMatchesMutexLayout(t *testing.T) {
+	s := validSettings()
+	start := s.EpochTime.Add(time.Hour)
+	mutexFlake, err := newWithSettings(context.Background(), s)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	mutexFlake.nowFunc = newStepClock(start, 0).Now
+	s.LockFree = true
+	lockFreeFlake, err := newWithSettings(context.Background(), s)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	lockFreeFlake.nowFunc = newStepClock(start, 0).Now
+
+	// Spans a sequence rollover, which sleeps until the next time unit.
+	want, err := mutexFlake.NextIDs(1000)
+	if err != nil {
+		t.Fatalf("NextIDs error: %v", err)
+	}
+	for i, w := range want {
+		got, err := lockFreeFlake.NextID()
+		if err != nil {
+			t.Fatalf("NextID error: %v", err)
+		}
+		if got != w {
+			t.Fatalf("id %d: mutex generator made %d, lock-free one %d", i, w, got)
+		}
+	}
+}
+
+func TestNextID_LockFreeClockRollback(t *testing.T) {
+	for _, policy := range []ClockRollbackPolicy{RollbackBorrow, RollbackWait, RollbackError} {
+		kf, clk := rollbackFlake(t, policy, 10*time.Millisecond)
+		kf.lockFree = true
+		last, err := kf.NextID()
+		if err != nil {
+			t.Fatalf("policy %d: NextID error: %v", policy, err)
+		}
+
+		clk.rewind(5 * time.Millisecond)
+		id, err := kf.NextID()
+		if policy == RollbackError {
+			if !errors.Is(err, ErrClockMovedBackwards) {
+				t.Fatalf("policy %d: expected ErrClockMovedBackwards, got %v", policy, err)
+			}
+		} else if err != nil || id <= last {
+			t.Fatalf("policy %d: want an id after %d within the bound, got %d (%v)", policy, last, id, err)
+		}
+
+		clk.rewind(time.Second)
+		if _, err := kf.NextID(); !errors.Is(err, ErrClockMovedBackwards) {
+			t.Fatalf("policy %d: expected ErrClockMovedBackwards beyond the bound, got %v", policy, err)
+		}
+	}
+}
+
+func benchmarkNextIDParallel(b *testing.B, opts ...GeneratorOptions) {
+	opts = append([]GeneratorOptions{
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithTimeUnit(time.Millisecond),
+		WithSequenceBits(20),
+		WithMachineBits(3),
+		WithClusterBits(2),
+		WithMachineIdFn(func() (int, error) { return 1, nil }),
+		WithClusterIdFn(func() (int, error) { return 1, nil }),
+	}, opts...)
+	kf, err := New(opts...)
+	if err != nil {
+		b.Fatalf("New error: %v", err)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := kf.NextID(); err != nil {
+				b.Errorf("NextID error: %v", err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkNextID_MutexParallel(b *testing.B) {
+	benchmarkNextIDParallel(b)
+}
+
+func BenchmarkNextID_LockFreeParallel(b *testing.B) {
+	benchmarkNextIDParallel(b, WithLockFree())
+}
+
+func TestDecomposeKey_RejectsNonCanonicalKeys(t *testing.T) {
+	kf, err := newWithSettings(context.Background(), validSettings())
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	key, err := kf.NextKey()
+	if err != nil {
+		t.Fatalf("NextKey error: %v", err)
+	}
+	if _, err := kf.DecomposeKey("0" + key); !errors.Is(err, ErrNonCanonicalKey) {
+		t.Fatalf("expected ErrNonCanonicalKey, got %v", err)
+	}
+	if _, err := kf.DecomposeKey(key + key); !errors.Is(err, ErrKeyOverflow) {
+		t.Fatalf("expected ErrKeyOverflow, got %v", err)
+	}
+}
+
+// encodeOnly hides the AppendEncode method of its BaseConverter.
+type encodeOnly struct {
+	BaseConverter
+}
+
+func TestAppendEncode_FallsBackToEncode(t *testing.T) {
+	s := validSettings()
+	s.Base = encodeOnly{internal.HexConverter{}}
+	kf, err := newWithSettings(context.Background(), s)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	key, err := kf.NextKeyAppend([]byte("key:"))
+	if err != nil {
+		t.Fatalf("NextKeyAppend error: %v", err)
+	}
+	if _, err := kf.DecomposeKey(strings.TrimPrefix(string(key), "key:")); err != nil || len(key) != 4+16 {
+		t.Fatalf("want a hex key, got %q (%v)", key, err)
+	}
+	id, _ := kf.Next()
+	if text, err := id.AppendText([]byte("key:")); err != nil || string(text) != "key:"+id.String() {
+		t.Fatalf("AppendText = %q, %v; want key:%s", text, err, id)
+	}
+}
+
+func TestNextKeyAppend_DoesNotAllocate(t *testing.T) {
+	kf, err := newWithSettings(context.Background(), validSettings())
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	buf := make([]byte, 0, 64)
+	key, err := kf.NextKeyAppend(buf)
+	if err != nil {
+		t.Fatalf("NextKeyAppend error: %v", err)
+	}
+	if _, err := kf.DecomposeKey(string(key)); err != nil {
+		t.Fatalf("DecomposeKey(%q) error: %v", key, err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = kf.NextKeyAppend(buf[:0])
+	})
+	if allocs != 0 {
+		t.Fatalf("want no allocations, got %v", allocs)
+	}
+}
+
+func BenchmarkNextKey(b *testing.B) {
+	kf, err := New(
+		WithTimeUnit(time.Millisecond),
+		WithMachineIdFn(func() (int, error) { return 1, nil }),
+		WithClusterIdFn(func() (int, error) { return 1, nil }),
+	)
+	if err != nil {
+		b.Fatalf("New error: %v", err)
+	}
+	b.Run("NextKey", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := kf.NextKey(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("NextKeyAppend", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, 64)
+		for i := 0; i < b.N; i++ {
+			if buf, err = kf.NextKeyAppend(buf[:0]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestNextKey_WithChecksum(t *testing.T) {
+	kf, err := New(
+		WithBase32Keys(),
+		WithChecksum(ChecksumDamm),
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithMachineIdFn(func() (int, error) { return 3, nil }),
+		WithClusterIdFn(func() (int, error) { return 1, nil }),
+	)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	key, err := kf.NextKey()
+	if err != nil {
+		t.Fatalf("NextKey error: %v", err)
+	}
+	if len(key) != internal.Base32Width+1 {
+		t.Fatalf("want a check character after the key, got %q", key)
+	}
+	parts, err := kf.DecomposeKey(key)
+	if err != nil {
+		t.Fatalf("DecomposeKey error: %v", err)
+	}
+	if parts[MachineID] != 3 || parts[ClusterID] != 1 {
+		t.Fatalf("unexpected parts %v", parts)
+	}
+
+	composed, err := kf.ComposeKey(time.Now(), 1, 3, 1)
+	if err != nil {
+		t.Fatalf("ComposeKey error: %v", err)
+	}
+	typo := []byte(composed)
+	if typo[5] == '1' {
+		typo[5] = '2'
+	} else {
+		typo[5] = '1'
+	}
+	if _, err := kf.DecomposeKey(string(typo)); !errors.Is(err, ErrInvalidChecksum) {
+		t.Fatalf("expected ErrInvalidChecksum for %q, got %v", typo, err)
+	}
+
+	if _, err := New(WithChecksum(ChecksumDamm)); !errors.Is(err, ErrUnsupportedChecksum) {
+		t.Fatalf("expected ErrUnsupportedChecksum for Damm with base62 keys, got %v", err)
+	}
+}
+
+func TestObfuscation_RoundTripAndRotation(t *testing.T) {
+	oldKey := ObfuscationKey{Version: 1, Secret: []byte("old secret")}
+	newKey := ObfuscationKey{Version: 2, Secret: []byte("new secret")}
+	opts := []GeneratorOptions{
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithMachineIdFn(func() (int, error) { return 7, nil }),
+		WithClusterIdFn(func() (int, error) { return 2, nil }),
+	}
+	before, err := New(append(opts, WithObfuscation(oldKey))...)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	after, err := New(append(opts, WithObfuscation(newKey, oldKey))...)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+
+	ids, err := before.NextIDs(100)
+	if err != nil {
+		t.Fatalf("NextIDs error: %v", err)
+	}
+	var lastKey string
+	sorted := true
+	for _, id := range ids {
+		key := before.ID(id).String()
+		if key[0] != '1' {
+			t.Fatalf("want key %q to start with its key version", key)
+		}
+		if parts, err := after.DecomposeKey(after.ID(id).String()); err != nil || parts[Sequence] != before.Decompose(id)[Sequence] {
+			t.Fatalf("DecomposeKey of the rotated key: %v, %v", parts, err)
+		}
+		// The generator with the new key still decodes keys of the old one.
+		oldParts, err := after.DecomposeKey(key)
+		if err != nil {
+			t.Fatalf("DecomposeKey(%q) error: %v", key, err)
+		}
+		if oldParts[MachineID] != 7 || oldParts[ClusterID] != 2 {
+			t.Fatalf("unexpected parts %v", oldParts)
+		}
+		sorted = sorted && key > lastKey
+		lastKey = key
+	}
+	if sorted {
+		t.Fatal("obfuscated keys must not follow the order of the ids")
+	}
+
+	newest, err := after.NextKey()
+	if err != nil {
+		t.Fatalf("NextKey error: %v", err)
+	}
+	if newest[0] != '2' {
+		t.Fatalf("want the new key version first in %q", newest)
+	}
+	if _, err := before.DecomposeKey(newest); !errors.Is(err, ErrUnknownKeyVersion) {
+		t.Fatalf("expected ErrUnknownKeyVersion, got %v", err)
+	}
+}
+
+func TestNew_InvalidObfuscationKeys(t *testing.T) {
+	tests := [][]ObfuscationKey{
+		{{Version: 0}},
+		{{Version: -1, Secret: []byte("s")}},
+		{{Version: 62, Secret: []byte("s")}},
+		{{Version: 3, Secret: []byte("a")}, {Version: 3, Secret: []byte("b")}},
+	}
+	for _, keys := range tests {
+		_, err := New(
+			WithObfuscation(keys...),
+			WithMachineIdFn(func() (int, error) { return 1, nil }),
+			WithClusterIdFn(func() (int, error) { return 1, nil }),
+		)
+		if !errors.Is(err, ErrInvalidObfuscationKey) {
+			t.Fatalf("keys %v: expected ErrInvalidObfuscationKey, got %v", keys, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package kubeflake
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	internal "github.com/FlorinBalint/kubeflake/internal/kubeflake"
+)
+
+// PrefixSeparator separates the type prefix of a key from the ID, as in "usr_3kTm...".
+const PrefixSeparator = "_"
+
+var (
+	// ErrInvalidPrefix is returned by Namespace for prefixes that are empty
+	// or have characters other than ASCII letters and digits.
+	ErrInvalidPrefix = errors.New("invalid key prefix")
+	// ErrPrefixMismatch is returned when decoding a key without the prefix
+	// of the Namespace, e.g. an order key where a user key is expected.
+	ErrPrefixMismatch = errors.New("key prefix mismatch")
+)
+
+// Namespace generates and parses keys with a type prefix, like "usr_3kTm...".
+// It shares the sequence of the Kubeflake it was derived from, so the IDs of
+// all namespaces of one Kubeflake are unique.
+type Namespace struct {
+	kf     *Kubeflake
+	prefix string
+}
+
+// Namespace returns a handle generating keys that start with prefix and
+// PrefixSeparator. The prefix must be made of ASCII letters and digits.
+// Since it cannot contain PrefixSeparator, keys are split at the first one,
+// so bases with "_" as a digit, like base64url, work as well.
+func (kf *Kubeflake) Namespace(prefix string) (*Namespace, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidPrefix)
+	}
+	for _, c := range prefix {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPrefix, prefix)
+		}
+	}
+	return &Namespace{kf: kf, prefix: prefix}, nil
+}
+
+// Prefix returns the prefix of the keys, without PrefixSeparator.
+func (ns *Namespace) Prefix() string {
+	return ns.prefix
+}
+
+// NextKey generates a next unique ID as a prefixed key.
+func (ns *Namespace) NextKey() (string, error) {
+	key, err := ns.NextKeyAppend(nil)
+	return string(key), err
+}
+
+// NextKeyAppend generates a next unique ID and appends it to dst as a prefixed key.
+func (ns *Namespace) NextKeyAppend(dst []byte) ([]byte, error) {
+	id, err := ns.kf.NextID()
+	if err != nil {
+		return dst, err
+	}
+	return ns.appendKey(dst, id), nil
+}
+
+// NextKeys generates n unique IDs as prefixed keys, see Kubeflake.NextIDs.
+func (ns *Namespace) NextKeys(n int) ([]string, error) {
+	ids, err := ns.kf.NextIDs(n)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = string(ns.appendKey(nil, id))
+	}
+	return keys, nil
+}
+
+// ComposeKey is Kubeflake.ComposeKey with the prefix of the namespace.
+func (ns *Namespace) ComposeKey(t time.Time, sequence, machineID, clusterId int) (string, error) {
+	id, err := ns.kf.Compose(t, sequence, machineID, clusterId)
+	if err != nil {
+		return "", err
+	}
+	return string(ns.appendKey(nil, id)), nil
+}
+
+// DecomposeKey splits a prefixed key into its parts. Keys with another
+// prefix, or none, fail with ErrPrefixMismatch.
+func (ns *Namespace) DecomposeKey(key string) (map[IdParts]uint64, error) {
+	id, err := ns.ParseID(key)
+	if err != nil {
+		return nil, err
+	}
+	return ns.kf.Decompose(id.Uint64()), nil
+}
+
+// ParseID decodes a prefixed key into an ID, failing with ErrPrefixMismatch
+// for keys with another prefix.
+func (ns *Namespace) ParseID(key string) (ID, error) {
+	prefix, rest := splitPrefix(key)
+	if prefix != ns.prefix {
+		return ID{}, fmt.Errorf("%w: want %q, got %q", ErrPrefixMismatch, ns.prefix, prefix)
+	}
+	return ns.kf.ParseID(rest)
+}
+
+func (ns *Namespace) appendKey(dst []byte, id uint64) []byte {
+	dst = append(dst, ns.prefix...)
+	dst = append(dst, PrefixSeparator...)
+	return internal.AppendEncode(ns.kf.base, dst, id)
+}
+
+// DecomposePrefixedKey splits a key of any Namespace of kf into its prefix
+// and parts. Keys without a prefix fail with ErrPrefixMismatch. With a base
+// that has PrefixSeparator as a digit, an unprefixed key may be split at a
+// digit instead and fail to decode; Namespace.DecomposeKey has no such case.
+func (kf *Kubeflake) DecomposePrefixedKey(key string) (string, map[IdParts]uint64, error) {
+	prefix, rest := splitPrefix(key)
+	if prefix == "" {
+		return "", nil, fmt.Errorf("%w: %q has no prefix", ErrPrefixMismatch, key)
+	}
+	parts, err := kf.DecomposeKey(rest)
+	if err != nil {
+		return "", nil, err
+	}
+	return prefix, parts, nil
+}
+
+// splitPrefix splits key at the first PrefixSeparator, which prefixes
+// cannot contain. Keys without a separator have an empty prefix.
+func splitPrefix(key string) (string, string) {
+	prefix, rest, found := strings.Cut(key, PrefixSeparator)
+	if !found {
+		return "", key
+	}
+	return prefix, rest
+}
@@ -0,0 +1,98 @@
+package kubeflake
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNamespace_PrefixedKeys(t *testing.T) {
+	kf, err := New(
+		WithBase64URLKeys(),
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithMachineIdFn(func() (int, error) { return 4, nil }),
+		WithClusterIdFn(func() (int, error) { return 1, nil }),
+	)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	for _, prefix := range []string{"", "us_r", "usr-", "ñ"} {
+		if _, err := kf.Namespace(prefix); !errors.Is(err, ErrInvalidPrefix) {
+			t.Fatalf("Namespace(%q): expected ErrInvalidPrefix, got %v", prefix, err)
+		}
+	}
+	users, err := kf.Namespace("usr")
+	if err != nil {
+		t.Fatalf("Namespace error: %v", err)
+	}
+	orders, err := kf.Namespace("ord")
+	if err != nil {
+		t.Fatalf("Namespace error: %v", err)
+	}
+
+	userKeys, err := users.NextKeys(50)
+	if err != nil {
+		t.Fatalf("NextKeys error: %v", err)
+	}
+	orderKey, err := orders.NextKey()
+	if err != nil {
+		t.Fatalf("NextKey error: %v", err)
+	}
+	if !strings.HasPrefix(orderKey, "ord_") {
+		t.Fatalf("want prefix ord_ in %q", orderKey)
+	}
+
+	// Namespaces share the sequence, so their ids never collide.
+	seen := map[uint64]bool{}
+	for _, key := range append(userKeys, orderKey) {
+		prefix, parts, err := kf.DecomposePrefixedKey(key)
+		if err != nil {
+			t.Fatalf("DecomposePrefixedKey(%q) error: %v", key, err)
+		}
+		if want := key[:3]; prefix != want {
+			t.Fatalf("want prefix %q, got %q", want, prefix)
+		}
+		if parts[MachineID] != 4 || parts[ClusterID] != 1 {
+			t.Fatalf("unexpected parts %v", parts)
+		}
+		id, err := kf.ParseID(key[4:])
+		if err != nil {
+			t.Fatalf("ParseID error: %v", err)
+		}
+		if seen[id.Uint64()] {
+			t.Fatalf("id of %q was generated twice", key)
+		}
+		seen[id.Uint64()] = true
+	}
+
+	if _, err := users.DecomposeKey(userKeys[0]); err != nil {
+		t.Fatalf("DecomposeKey error: %v", err)
+	}
+	for _, key := range []string{orderKey, strings.TrimPrefix(userKeys[0], "usr_")} {
+		if _, err := users.DecomposeKey(key); !errors.Is(err, ErrPrefixMismatch) {
+			t.Fatalf("DecomposeKey(%q): expected ErrPrefixMismatch, got %v", key, err)
+		}
+	}
+
+	composed, err := users.ComposeKey(time.Now(), 3, 4, 1)
+	if err != nil {
+		t.Fatalf("ComposeKey error: %v", err)
+	}
+	id, err := users.ParseID(composed)
+	if err != nil || id.Sequence() != 3 {
+		t.Fatalf("ParseID(%q) = %v, %v", composed, id, err)
+	}
+
+	// Keys are split at the first separator, so "_" digits stay in the ID.
+	underscores := "usr_" + kf.ID(64*63+63).String()
+	if !strings.HasSuffix(underscores, "__") {
+		t.Fatalf("want a key ending in base64url _ digits, got %q", underscores)
+	}
+	if id, err := users.ParseID(underscores); err != nil || id.Uint64() != 64*63+63 {
+		t.Fatalf("ParseID(%q) = %v, %v", underscores, id, err)
+	}
+	if prefix, _, err := kf.DecomposePrefixedKey(underscores); err != nil || prefix != "usr" {
+		t.Fatalf("DecomposePrefixedKey(%q) = %q, %v", underscores, prefix, err)
+	}
+}
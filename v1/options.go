@@ -1,9 +1,13 @@
 package kubeflake
 
 import (
+	"context"
+	"net/http"
 	"time"
 
 	internal "github.com/FlorinBalint/kubeflake/internal/kubeflake"
+	"github.com/FlorinBalint/kubeflake/pkg/cloud"
+	"github.com/FlorinBalint/kubeflake/pkg/kubernetes"
 )
 
 // GeneratorOptions defines functional options for Kubeflake generator
@@ -58,6 +62,86 @@ func WithBase64Keys() GeneratorOptions {
 	})
 }
 
+// WithBase64URLKeys converts ids using base64 with the URL-safe "-_" digits
+func WithBase64URLKeys() GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.Base = internal.Base64URLConverter{}
+	})
+}
+
+// WithRawURLBase64Keys converts ids to the base64.RawURLEncoding of their
+// 8 byte big-endian representation, which non-Go services can decode with
+// their standard base64 library.
+func WithRawURLBase64Keys() GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.Base = internal.RawURLBase64Converter{}
+	})
+}
+
+// WithRawStdBase64Keys is like WithRawURLBase64Keys, with the standard
+// "+/" alphabet of base64.RawStdEncoding.
+func WithRawStdBase64Keys() GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.Base = internal.RawStdBase64Converter{}
+	})
+}
+
+// WithSortableKeys converts ids to 11 digit, zero-padded base62 keys,
+// which sort in the same order as the ids, e.g. for range scans.
+func WithSortableKeys() GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.Base = internal.SortableBase62Converter{}
+	})
+}
+
+// WithBase32Keys converts ids to 13 digit Crockford base32 keys, which sort
+// in the same order as the ids and are easy to read out and type.
+func WithBase32Keys() GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.Base = internal.Base32Converter{}
+	})
+}
+
+// WithHexKeys converts ids to 16 digit hexadecimal keys, which sort in the
+// same order as the ids.
+func WithHexKeys() GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.Base = internal.HexConverter{}
+	})
+}
+
+// WithBaseConverter converts ids with b, e.g. one returned by NewAlphabetConverter
+func WithBaseConverter(b BaseConverter) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.Base = b
+	})
+}
+
+// WithChecksum appends a check character computed with c to the keys of
+// NextKey and ComposeKey, which DecomposeKey verifies, failing with
+// ErrInvalidChecksum for mistyped keys. New fails with ErrUnsupportedChecksum
+// if c does not support the alphabet of the keys.
+func WithChecksum(c Checksum) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.Checksum = c
+	})
+}
+
+// WithObfuscation permutes ids with a keyed 64 bit Feistel network before
+// they are encoded, so that keys look random, do not reveal when and where
+// they were generated and cannot be enumerated. DecomposeKey and ParseID
+// undo the permutation, while NextID and Compose still return plain ids.
+//
+// Keys start with a digit holding the version of the obfuscation key.
+// keys[0] obfuscates new keys and every key decodes the keys of its version,
+// so to rotate, put the new key first and keep the old ones until their
+// keys are no longer in use.
+func WithObfuscation(keys ...ObfuscationKey) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.ObfuscationKeys = append([]ObfuscationKey(nil), keys...)
+	})
+}
+
 // WithEpoch sets the epoch time
 func WithEpoch(t time.Time) GeneratorOptions {
 	return optionFunc(func(s *settings) {
@@ -65,16 +149,166 @@ func WithEpoch(t time.Time) GeneratorOptions {
 	})
 }
 
-// WithClusterId sets the cluster ID function
+// WithClusterIdFn sets the cluster ID function. Unlike WithClusterIdContextFn,
+// fn is not given the context of NewWithContext, so the resolve timeout and
+// cancellation cannot interrupt it.
 func WithClusterIdFn(fn func() (int, error)) GeneratorOptions {
+	return WithClusterIdContextFn(func(context.Context) (int, error) {
+		return fn()
+	})
+}
+
+// WithClusterIdContextFn sets a cluster ID function that honors the context
+// given to NewWithContext and the resolve timeout.
+func WithClusterIdContextFn(fn func(context.Context) (int, error)) GeneratorOptions {
 	return optionFunc(func(s *settings) {
 		s.ClusterId = fn
+		s.ClusterIdFile = ""
+	})
+}
+
+// WithClusterIdFile reads the cluster ID from the JSON or YAML mapping file
+// at path, e.g. a mounted ConfigMap, see kubernetes.ReadClusterId. The entry
+// of the CLUSTER_NAME environment variable is used, or if it is unset, the
+// one of the availability zone, looked up with the cloud provider and
+// resolver of the other options. The file is read again on every retry.
+func WithClusterIdFile(path string) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.ClusterId = nil
+		s.ClusterIdFile = path
 	})
 }
 
-// WithMachineId sets the machine ID function
+// WithCloudProvider looks up the availability zone of the given provider
+// instead of detecting the provider, e.g. cloud.AWSZoneProvider assigns
+// cluster IDs per AWS availability zone rather than per region.
+//
+// This and the other zone lookup options below only matter when the cluster
+// ID is derived from the zone: a cluster ID function set with
+// WithClusterIdFn or WithClusterIdContextFn takes precedence, whatever the
+// order of the options.
+func WithCloudProvider(p cloud.Provider) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.Provider = p
+	})
+}
+
+// WithClusterIdStrategy derives the cluster ID from the availability zone
+// with st instead of taking the index of the zone, which only fits the
+// cluster bits for the first few zones of every provider, e.g.
+// cloud.TopZonesStrategy or cloud.RegionStrategy. Location names the
+// zones that st can tell from their cluster ID.
+func WithClusterIdStrategy(st cloud.ClusterIdStrategy) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.ClusterIdStrategy = st
+	})
+}
+
+// WithCloudResolver looks up the availability zone used as cluster ID through r,
+// e.g. to use a custom http.Client or metadata server URL.
+func WithCloudResolver(r *cloud.Resolver) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.Cloud = r
+	})
+}
+
+// WithHTTPClient sends the metadata server requests through c.
+func WithHTTPClient(c *http.Client) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		r := cloud.Resolver{}
+		if s.Cloud != nil {
+			r = *s.Cloud
+		}
+		r.Client = c
+		s.Cloud = &r
+	})
+}
+
+// WithMachineIdFn sets the machine ID function. Unlike WithMachineIdContextFn,
+// fn is not given the context of NewWithContext, so the resolve timeout and
+// cancellation cannot interrupt it.
 func WithMachineIdFn(fn func() (int, error)) GeneratorOptions {
+	return WithMachineIdContextFn(func(context.Context) (int, error) {
+		return fn()
+	})
+}
+
+// WithMachineIdContextFn sets a machine ID function that honors the context
+// given to NewWithContext and the resolve timeout.
+func WithMachineIdContextFn(fn func(context.Context) (int, error)) GeneratorOptions {
 	return optionFunc(func(s *settings) {
 		s.MachineId = fn
+		s.MachineLease = nil
+	})
+}
+
+// WithMachineLease claims the machine ID through a Kubernetes Lease, for pods
+// without a StatefulSet ordinal. NextID and NextKey fail with
+// kubernetes.ErrLeaseLost once the Lease can no longer be renewed.
+// A nil allocator is ignored.
+func WithMachineLease(a *kubernetes.LeaseAllocator) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		if a != nil {
+			s.MachineLease = a
+		}
+	})
+}
+
+// WithResolveTimeout bounds every attempt to resolve the cluster or machine ID.
+func WithResolveTimeout(timeout time.Duration) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.ResolveTimeout = timeout
+	})
+}
+
+// WithResolveRetries retries a failed cluster or machine ID lookup up to
+// retries times, waiting backoff before the first retry and doubling it after.
+func WithResolveRetries(retries int, backoff time.Duration) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.ResolveRetries = retries
+		s.ResolveBackoff = backoff
+	})
+}
+
+// WithClockRollbackPolicy sets what NextID does when the clock moves backwards
+// and how far it may borrow or wait before failing with ErrClockMovedBackwards.
+// By default, up to 1 second of future time is borrowed after the clock moved
+// backwards; without a rollback, a sequence overflow waits for the next time unit.
+func WithClockRollbackPolicy(policy ClockRollbackPolicy, max time.Duration) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.ClockRollback = policy
+		s.MaxClockRollback = max
+	})
+}
+
+// WithLockFree generates IDs with compare-and-swap on a single atomic word
+// instead of a mutex, which scales better when many goroutines generate IDs.
+// The IDs have the same layout and are still strictly increasing per generator.
+func WithLockFree() GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.LockFree = true
+	})
+}
+
+// WithLayout generates IDs with the field order and bit lengths of l, e.g.
+// SnowflakeLayout or SonyflakeLayout. The time unit, epoch and base of l
+// are used as well, unless they are zero. IDs stop at the time limit of
+// BitsTime, so with fewer than 64 bits in total the high bits stay zero.
+func WithLayout(l *Layout) GeneratorOptions {
+	return optionFunc(func(s *settings) {
+		s.BitsTime = l.BitsTime
+		s.BitsSequence = l.BitsSequence
+		s.BitsCluster = l.BitsCluster
+		s.BitsMachine = l.BitsMachine
+		s.Order = append([]IdParts(nil), l.Order...)
+		if l.TimeUnit != 0 {
+			s.TimeUnit = l.TimeUnit
+		}
+		if !l.Epoch.IsZero() {
+			s.EpochTime = l.Epoch
+		}
+		if l.Base != nil {
+			s.Base = l.Base
+		}
 	})
 }
@@ -0,0 +1,194 @@
+package kubeflake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrInvalidNameField is returned by NewRegistry for a NameField that
+	// does not fit the layout or the resolved machine ID.
+	ErrInvalidNameField = errors.New("invalid name field")
+	// ErrUnknownName is returned by Registry.Get for names that cannot get
+	// a value of the name field: names missing from NameField.Names, or new
+	// names once every value is taken.
+	ErrUnknownName = errors.New("unknown generator name")
+)
+
+// NameField carves a field holding the generator name out of the machine
+// ID or the sequence of the IDs of a Registry, so that IDs of different
+// names never collide. The Bits highest bits of Part hold the index of the
+// name: its position in Names, or the order in which it was first used if
+// Names is nil.
+//
+// Carved from the machine ID, the resolved machine ID must fit the bits
+// left. Carved from the sequence, every name can generate fewer IDs per
+// time unit.
+type NameField struct {
+	Part  IdParts
+	Bits  int
+	Names []string
+}
+
+// Registry hands out one generator per name, created on first use, so that
+// a burst of IDs for one name does not exhaust the sequence of the others.
+// The generators share the settings, cluster ID and machine ID of the
+// Registry, which are resolved once by NewRegistry.
+type Registry struct {
+	base  *Kubeflake
+	field NameField
+
+	mutex      sync.Mutex
+	generators map[string]*Kubeflake
+	names      []string
+}
+
+// NewRegistry resolves the settings given by opts like New does, and returns
+// a Registry whose generators use them. A zero field adds no name field,
+// so IDs of different names may collide: use it only if they are stored
+// apart, e.g. in different tables.
+func NewRegistry(field NameField, opts ...GeneratorOptions) (*Registry, error) {
+	return NewRegistryWithContext(context.Background(), field, opts...)
+}
+
+// NewRegistryWithContext is like NewRegistry, see NewWithContext.
+func NewRegistryWithContext(ctx context.Context, field NameField, opts ...GeneratorOptions) (*Registry, error) {
+	base, err := NewWithContext(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateNameField(base, field); err != nil {
+		return nil, err
+	}
+	return &Registry{
+		base:       base,
+		field:      field,
+		generators: map[string]*Kubeflake{},
+	}, nil
+}
+
+func validateNameField(kf *Kubeflake, field NameField) error {
+	if field.Bits == 0 && field.Part == "" && field.Names == nil {
+		return nil
+	}
+	if field.Bits <= 0 || len(field.Names) > 1<<field.Bits {
+		return fmt.Errorf("%w: %d names do not fit %d bits", ErrInvalidNameField, len(field.Names), field.Bits)
+	}
+	switch field.Part {
+	case MachineID:
+		if left := kf.bitsMachine - field.Bits; left < 0 || kf.machineId >= 1<<left {
+			return fmt.Errorf("%w: machine id %d does not fit %d bits", ErrInvalidNameField, kf.machineId, max(left, 0))
+		}
+	case Sequence:
+		if kf.bitsSequence-field.Bits < 1 {
+			return fmt.Errorf("%w: no sequence bits left", ErrInvalidNameField)
+		}
+	default:
+		return fmt.Errorf("%w: cannot carve %q", ErrInvalidNameField, field.Part)
+	}
+	seen := map[string]bool{}
+	for _, name := range field.Names {
+		if seen[name] {
+			return fmt.Errorf("%w: %q is repeated", ErrInvalidNameField, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// Get returns the generator of name, creating it on first use.
+func (r *Registry) Get(name string) (*Kubeflake, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if kf, ok := r.generators[name]; ok {
+		return kf, nil
+	}
+
+	kf := r.base.derive()
+	if r.field.Bits > 0 {
+		index, err := r.index(name)
+		if err != nil {
+			return nil, err
+		}
+		if r.field.Part == MachineID {
+			kf.machineId |= index << (kf.bitsMachine - r.field.Bits)
+		} else {
+			bits := kf.bitsSequence - r.field.Bits
+			kf.sequenceMask = 1<<bits - 1
+			kf.sequenceTag = uint64(index) << bits
+		}
+	}
+	r.generators[name] = kf
+	return kf, nil
+}
+
+// index returns the value of the name field for name. The caller must hold the mutex.
+func (r *Registry) index(name string) (int, error) {
+	names := r.field.Names
+	if names == nil {
+		names = r.names
+	}
+	for i, n := range names {
+		if n == name {
+			return i, nil
+		}
+	}
+	if r.field.Names != nil || len(r.names) >= 1<<r.field.Bits {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownName, name)
+	}
+	r.names = append(r.names, name)
+	return len(r.names) - 1, nil
+}
+
+// Name returns the name whose generator made id, if the Registry has a name
+// field and the name was used before.
+func (r *Registry) Name(id uint64) (string, bool) {
+	if r.field.Bits == 0 {
+		return "", false
+	}
+	field := r.base.layout.part(id, r.field.Part)
+	index := int(field >> (r.base.layout.bits(r.field.Part) - r.field.Bits))
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	names := r.field.Names
+	if names == nil {
+		names = r.names
+	}
+	if index >= len(names) {
+		return "", false
+	}
+	return names[index], true
+}
+
+// derive returns a generator with the settings, cluster ID and machine ID
+// of kf and a sequence of its own.
+func (kf *Kubeflake) derive() *Kubeflake {
+	return &Kubeflake{
+		mutex:          new(sync.Mutex),
+		machineId:      kf.machineId,
+		clusterId:      kf.clusterId,
+		provider:       kf.provider,
+		zones:          kf.zones,
+		bitsTime:       kf.bitsTime,
+		bitsCluster:    kf.bitsCluster,
+		bitsMachine:    kf.bitsMachine,
+		bitsSequence:   kf.bitsSequence,
+		sequenceMask:   kf.sequenceMask,
+		sequenceTag:    kf.sequenceTag,
+		shiftSequence:  kf.shiftSequence,
+		shiftCluster:   kf.shiftCluster,
+		shiftMachine:   kf.shiftMachine,
+		timeUnit:       kf.timeUnit,
+		startTime:      kf.startTime,
+		rollbackPolicy: kf.rollbackPolicy,
+		maxRollback:    kf.maxRollback,
+		lockFree:       kf.lockFree,
+		base:           kf.base,
+		layout:         kf.layout,
+		nowFunc:        kf.nowFunc,
+		lease:          kf.lease,
+	}
+}
@@ -0,0 +1,129 @@
+package kubeflake
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	internal "github.com/FlorinBalint/kubeflake/internal/kubeflake"
+)
+
+func registryOptions() []GeneratorOptions {
+	return []GeneratorOptions{
+		WithTimeUnit(time.Millisecond),
+		WithEpoch(time.Now().Add(-time.Hour)),
+		WithMachineIdFn(func() (int, error) { return 5, nil }),
+		WithClusterIdFn(func() (int, error) { return 2, nil }),
+	}
+}
+
+func TestRegistry_NameFieldsNeverCollide(t *testing.T) {
+	fields := []NameField{
+		{Part: MachineID, Bits: 2},
+		{Part: Sequence, Bits: 3, Names: []string{"users", "orders", "invoices"}},
+	}
+	for _, field := range fields {
+		t.Run(string(field.Part), func(t *testing.T) {
+			r, err := NewRegistry(field, registryOptions()...)
+			if err != nil {
+				t.Fatalf("NewRegistry error: %v", err)
+			}
+
+			var mu sync.Mutex
+			seen := map[uint64]string{}
+			var wg sync.WaitGroup
+			for _, name := range []string{"users", "orders", "invoices"} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					kf, err := r.Get(name)
+					if err != nil {
+						t.Errorf("Get(%q) error: %v", name, err)
+						return
+					}
+					ids, err := kf.NextIDs(2000)
+					if err != nil {
+						t.Errorf("NextIDs error: %v", err)
+						return
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					for _, id := range ids {
+						if other, ok := seen[id]; ok {
+							t.Errorf("id %d of %q collides with %q", id, name, other)
+							return
+						}
+						seen[id] = name
+						if got, ok := r.Name(id); !ok || got != name {
+							t.Errorf("Name(%d) = %q, %v, want %q", id, got, ok, name)
+							return
+						}
+						if field.Part == MachineID && kf.machinePart(id)&(1<<11-1) != 5 {
+							t.Errorf("want machine id 5 below the name field, got %d", kf.machinePart(id))
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			again, _ := r.Get("users")
+			if first, _ := r.Get("users"); first != again {
+				t.Fatal("Get must return the same generator for a name")
+			}
+		})
+	}
+}
+
+func TestRegistry_IndependentSequences(t *testing.T) {
+	r, err := NewRegistry(NameField{}, registryOptions()...)
+	if err != nil {
+		t.Fatalf("NewRegistry error: %v", err)
+	}
+	clk := newStepClock(time.Now(), 0)
+	r.base.nowFunc = clk.Now
+	busy, _ := r.Get("busy")
+	quiet, _ := r.Get("quiet")
+
+	// The busy generator runs out of sequence numbers in this time unit ...
+	if _, err := busy.NextIDs(1 << internal.DefaultBitsSequence); err != nil {
+		t.Fatalf("NextIDs error: %v", err)
+	}
+	// ... but the quiet one starts its own sequence.
+	id, err := quiet.NextID()
+	if err != nil {
+		t.Fatalf("NextID error: %v", err)
+	}
+	if got := quiet.sequencePart(id); got != 0 {
+		t.Fatalf("want sequence 0 for the quiet generator, got %d", got)
+	}
+}
+
+func TestRegistry_Errors(t *testing.T) {
+	invalid := []NameField{
+		{Part: MachineID, Bits: 11}, // machine id 5 needs 3 of the 13 bits
+		{Part: Sequence, Bits: internal.DefaultBitsSequence},
+		{Part: ClusterID, Bits: 1},
+		{Part: Sequence, Bits: 1, Names: []string{"a", "b", "c"}},
+		{Part: Sequence, Bits: 2, Names: []string{"a", "a"}},
+	}
+	for _, field := range invalid {
+		if _, err := NewRegistry(field, registryOptions()...); !errors.Is(err, ErrInvalidNameField) {
+			t.Fatalf("NewRegistry(%+v): expected ErrInvalidNameField, got %v", field, err)
+		}
+	}
+
+	r, err := NewRegistry(NameField{Part: Sequence, Bits: 1}, registryOptions()...)
+	if err != nil {
+		t.Fatalf("NewRegistry error: %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := r.Get(name); err != nil {
+			t.Fatalf("Get(%q) error: %v", name, err)
+		}
+	}
+	if _, err := r.Get("c"); !errors.Is(err, ErrUnknownName) {
+		t.Fatalf("expected ErrUnknownName once the name field is full, got %v", err)
+	}
+}